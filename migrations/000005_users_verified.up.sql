@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS verified_at TIMESTAMP;
\ No newline at end of file
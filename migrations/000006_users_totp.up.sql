@@ -0,0 +1,10 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret varchar(64);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+  id                serial          PRIMARY KEY,
+  code_hash         varchar(64)     NOT NULL,
+  used_at           TIMESTAMP,
+  user_id           INTEGER         REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  UNIQUE(user_id, code_hash)
+);
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role varchar(20) NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'coach', 'admin'));
\ No newline at end of file
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+  id                serial          PRIMARY KEY,
+  event             varchar(40)     NOT NULL,
+  ip                varchar(64)     NOT NULL,
+  detail            TEXT            NOT NULL,
+  created_at        TIMESTAMP       NOT NULL DEFAULT NOW(),
+  user_id           INTEGER         REFERENCES users(id) ON DELETE SET NULL ON UPDATE CASCADE
+);
\ No newline at end of file
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+  id                serial          PRIMARY KEY,
+  name              varchar(80)     NOT NULL,
+  prefix            varchar(16)     NOT NULL,
+  key_hash          varchar(64)     UNIQUE NOT NULL,
+  scopes            TEXT[]          NOT NULL,
+  created_at        TIMESTAMP       NOT NULL DEFAULT NOW(),
+  last_used_at      TIMESTAMP,
+  revoked_at        TIMESTAMP,
+  user_id           INTEGER         REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
\ No newline at end of file
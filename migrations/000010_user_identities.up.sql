@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS user_identities (
+  id                serial          PRIMARY KEY,
+  provider          varchar(40)     NOT NULL,
+  subject           varchar(255)    NOT NULL,
+  created_at        TIMESTAMP       NOT NULL DEFAULT NOW(),
+  user_id           INTEGER         REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  UNIQUE(provider, subject)
+);
\ No newline at end of file
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS signing_keys (
+  kid               varchar(64)     PRIMARY KEY,
+  alg               varchar(16)     NOT NULL,
+  private_key       TEXT            NOT NULL,
+  created_at        TIMESTAMPTZ     NOT NULL DEFAULT NOW(),
+  activates_at      TIMESTAMPTZ     NOT NULL
+);
\ No newline at end of file
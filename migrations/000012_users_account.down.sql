@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN IF EXISTS purged_at;
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE users DROP COLUMN IF EXISTS pending_email;
\ No newline at end of file
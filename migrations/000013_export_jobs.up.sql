@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS export_jobs (
+  id                serial          PRIMARY KEY,
+  status            varchar(16)     NOT NULL DEFAULT 'pending',
+  error             TEXT,
+  archive           BYTEA,
+  created_at        TIMESTAMP       NOT NULL DEFAULT NOW(),
+  completed_at      TIMESTAMP,
+  expires_at        TIMESTAMP,
+  user_id           INTEGER         REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
\ No newline at end of file
@@ -0,0 +1,13 @@
+DROP INDEX IF EXISTS user_records_workout_exercise_id_idx;
+DROP INDEX IF EXISTS user_records_user_id_date_performed_idx;
+ALTER TABLE user_records DROP COLUMN IF EXISTS workout_exercise_id;
+ALTER TABLE user_records DROP COLUMN IF EXISTS position;
+ALTER TABLE user_records DROP COLUMN IF EXISTS set_type;
+
+-- only the first set of an exercise on a given day fits the old constraint
+DELETE FROM user_records a USING user_records b
+  WHERE a.date_performed = b.date_performed AND a.exercise_id = b.exercise_id AND a.id > b.id;
+ALTER TABLE user_records ADD CONSTRAINT user_records_date_performed_exercise_id_key UNIQUE(date_performed, exercise_id);
+
+DROP TABLE IF EXISTS workout_exercises;
+DROP TABLE IF EXISTS workouts;
\ No newline at end of file
@@ -0,0 +1,44 @@
+CREATE TABLE IF NOT EXISTS workouts (
+  id                serial          PRIMARY KEY,
+  started_at        TIMESTAMP       NOT NULL,
+  ended_at          TIMESTAMP,
+  notes             TEXT            NOT NULL DEFAULT '',
+  user_id           INTEGER         REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS workout_exercises (
+  id                serial          PRIMARY KEY,
+  position          INTEGER         NOT NULL,
+  notes             TEXT            NOT NULL DEFAULT '',
+  workout_id        INTEGER         NOT NULL REFERENCES workouts(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  exercise_id       INTEGER         NOT NULL REFERENCES exercise(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS workouts_user_id_started_at_idx ON workouts(user_id, started_at);
+CREATE INDEX IF NOT EXISTS workout_exercises_workout_id_idx ON workout_exercises(workout_id);
+
+-- user_records rows become the sets of a workout exercise, they keep their date,
+-- exercise and user so records can still be queried without joining workouts
+ALTER TABLE user_records DROP CONSTRAINT IF EXISTS user_records_date_performed_exercise_id_key;
+ALTER TABLE user_records ADD COLUMN IF NOT EXISTS set_type varchar(16) NOT NULL DEFAULT 'working';
+ALTER TABLE user_records ADD COLUMN IF NOT EXISTS position INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE user_records ADD COLUMN IF NOT EXISTS workout_exercise_id INTEGER REFERENCES workout_exercises(id) ON DELETE CASCADE ON UPDATE CASCADE;
+
+-- every existing record becomes a single set workout
+DO $$
+DECLARE
+  r RECORD;
+  wid INTEGER;
+  weid INTEGER;
+BEGIN
+  FOR r IN SELECT id, date_performed, exercise_id, user_id FROM user_records WHERE workout_exercise_id IS NULL ORDER BY id LOOP
+    INSERT INTO workouts(started_at, user_id) VALUES (r.date_performed, r.user_id) RETURNING id INTO wid;
+    INSERT INTO workout_exercises(position, workout_id, exercise_id) VALUES (1, wid, r.exercise_id) RETURNING id INTO weid;
+    UPDATE user_records SET workout_exercise_id = weid WHERE id = r.id;
+  END LOOP;
+END
+$$;
+
+ALTER TABLE user_records ALTER COLUMN workout_exercise_id SET NOT NULL;
+CREATE INDEX IF NOT EXISTS user_records_user_id_date_performed_idx ON user_records(user_id, date_performed);
+CREATE INDEX IF NOT EXISTS user_records_workout_exercise_id_idx ON user_records(workout_exercise_id);
\ No newline at end of file
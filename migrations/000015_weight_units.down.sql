@@ -0,0 +1,5 @@
+ALTER TABLE user_records ALTER COLUMN weight TYPE INTEGER
+  USING ROUND(CASE weight_unit WHEN 'lb' THEN weight / 0.45359237 ELSE weight END);
+ALTER TABLE user_records DROP COLUMN IF EXISTS weight_unit;
+
+ALTER TABLE users DROP COLUMN IF EXISTS weight_unit;
\ No newline at end of file
@@ -0,0 +1,6 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS weight_unit varchar(2) NOT NULL DEFAULT 'kg';
+UPDATE users SET weight_unit = 'lb';
+
+ALTER TABLE user_records ALTER COLUMN weight TYPE NUMERIC USING weight * 0.45359237;
+ALTER TABLE user_records ADD COLUMN IF NOT EXISTS weight_unit varchar(2) NOT NULL DEFAULT 'lb';
+ALTER TABLE user_records ALTER COLUMN weight_unit SET DEFAULT 'kg';
\ No newline at end of file
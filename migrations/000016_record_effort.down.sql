@@ -0,0 +1,3 @@
+ALTER TABLE user_records ALTER COLUMN rpe TYPE INTEGER USING COALESCE(ROUND(COALESCE(rpe, 10 - rir)), 0);
+ALTER TABLE user_records ALTER COLUMN rpe SET NOT NULL;
+ALTER TABLE user_records DROP COLUMN IF EXISTS rir;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE user_records ALTER COLUMN rpe DROP NOT NULL;
+ALTER TABLE user_records ALTER COLUMN rpe TYPE NUMERIC(3,1) USING CASE WHEN rpe BETWEEN 1 AND 10 THEN rpe END;
+ALTER TABLE user_records ADD COLUMN IF NOT EXISTS rir NUMERIC(3,1);
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE user_records DROP COLUMN IF EXISTS distance;
+ALTER TABLE user_records DROP COLUMN IF EXISTS duration;
+ALTER TABLE exercise DROP COLUMN IF EXISTS measurement;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE exercise ADD COLUMN IF NOT EXISTS measurement VARCHAR(24) NOT NULL DEFAULT 'weight_reps';
+ALTER TABLE user_records ADD COLUMN IF NOT EXISTS duration INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE user_records ADD COLUMN IF NOT EXISTS distance NUMERIC NOT NULL DEFAULT 0;
\ No newline at end of file
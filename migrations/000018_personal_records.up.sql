@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS personal_records (
+  id                serial          PRIMARY KEY,
+  type              varchar(24)     NOT NULL,
+  value             NUMERIC         NOT NULL,
+  previous          NUMERIC,
+  weight            NUMERIC         NOT NULL DEFAULT 0,
+  reps              INTEGER         NOT NULL DEFAULT 0,
+  date_performed    DATE            NOT NULL,
+  created_at        TIMESTAMP       NOT NULL DEFAULT NOW(),
+  exercise_id       INTEGER         REFERENCES exercise(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  record_id         INTEGER         REFERENCES user_records(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  user_id           INTEGER         REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX IF NOT EXISTS personal_records_user_exercise ON personal_records(user_id, exercise_id);
\ No newline at end of file
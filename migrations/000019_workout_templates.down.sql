@@ -0,0 +1,3 @@
+ALTER TABLE workouts DROP COLUMN IF EXISTS template_id;
+DROP TABLE IF EXISTS template_exercises;
+DROP TABLE IF EXISTS templates;
\ No newline at end of file
@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS templates (
+  id                serial          PRIMARY KEY,
+  name              varchar(80)     NOT NULL,
+  notes             TEXT            NOT NULL DEFAULT '',
+  created_at        TIMESTAMP       NOT NULL DEFAULT NOW(),
+  updated_at        TIMESTAMP       NOT NULL DEFAULT NOW(),
+  user_id           INTEGER         REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS template_exercises (
+  id                serial          PRIMARY KEY,
+  position          INTEGER         NOT NULL,
+  sets              INTEGER         NOT NULL,
+  reps_min          INTEGER         NOT NULL DEFAULT 0,
+  reps_max          INTEGER         NOT NULL DEFAULT 0,
+  rpe               NUMERIC(3,1),
+  rest              INTEGER         NOT NULL DEFAULT 0,
+  notes             TEXT            NOT NULL DEFAULT '',
+  template_id       INTEGER         NOT NULL REFERENCES templates(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  exercise_id       INTEGER         NOT NULL REFERENCES exercise(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS templates_user_id_idx ON templates(user_id);
+CREATE INDEX IF NOT EXISTS template_exercises_template_id_idx ON template_exercises(template_id);
+
+ALTER TABLE workouts ADD COLUMN IF NOT EXISTS template_id INTEGER REFERENCES templates(id) ON DELETE SET NULL ON UPDATE CASCADE;
\ No newline at end of file
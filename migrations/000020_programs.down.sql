@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS program_results;
+DROP TABLE IF EXISTS scheduled_workouts;
+DROP TABLE IF EXISTS training_maxes;
+DROP TABLE IF EXISTS enrollments;
+DROP TABLE IF EXISTS program_rules;
+DROP TABLE IF EXISTS program_exercises;
+DROP TABLE IF EXISTS programs;
\ No newline at end of file
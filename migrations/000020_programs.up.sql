@@ -0,0 +1,77 @@
+CREATE TABLE IF NOT EXISTS programs (
+  id                serial          PRIMARY KEY,
+  name              varchar(80)     NOT NULL,
+  description       TEXT            NOT NULL DEFAULT '',
+  weeks             INTEGER         NOT NULL,
+  days_per_week     INTEGER         NOT NULL,
+  created_at        TIMESTAMP       NOT NULL DEFAULT NOW(),
+  user_id           INTEGER         REFERENCES users(id) ON DELETE SET NULL ON UPDATE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS program_exercises (
+  id                serial          PRIMARY KEY,
+  week              INTEGER         NOT NULL,
+  day               INTEGER         NOT NULL,
+  position          INTEGER         NOT NULL,
+  sets              INTEGER         NOT NULL,
+  reps              INTEGER         NOT NULL,
+  percent           NUMERIC         NOT NULL,
+  amrap             BOOLEAN         NOT NULL DEFAULT FALSE,
+  program_id        INTEGER         NOT NULL REFERENCES programs(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  exercise_id       INTEGER         NOT NULL REFERENCES exercise(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS program_rules (
+  id                serial          PRIMARY KEY,
+  increment         NUMERIC         NOT NULL,
+  advance_on        varchar(16)     NOT NULL DEFAULT 'session',
+  failures_to_reset INTEGER         NOT NULL DEFAULT 0,
+  reset_percent     NUMERIC         NOT NULL DEFAULT 90,
+  program_id        INTEGER         NOT NULL REFERENCES programs(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  exercise_id       INTEGER         NOT NULL REFERENCES exercise(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  UNIQUE (program_id, exercise_id)
+);
+
+CREATE TABLE IF NOT EXISTS enrollments (
+  id                serial          PRIMARY KEY,
+  started_on        DATE            NOT NULL,
+  cycles            INTEGER         NOT NULL DEFAULT 1,
+  status            varchar(16)     NOT NULL DEFAULT 'active',
+  created_at        TIMESTAMP       NOT NULL DEFAULT NOW(),
+  program_id        INTEGER         NOT NULL REFERENCES programs(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  user_id           INTEGER         REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS training_maxes (
+  id                serial          PRIMARY KEY,
+  weight            NUMERIC         NOT NULL,
+  failures          INTEGER         NOT NULL DEFAULT 0,
+  updated_at        TIMESTAMP       NOT NULL DEFAULT NOW(),
+  enrollment_id     INTEGER         NOT NULL REFERENCES enrollments(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  exercise_id       INTEGER         NOT NULL REFERENCES exercise(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  UNIQUE (enrollment_id, exercise_id)
+);
+
+CREATE TABLE IF NOT EXISTS scheduled_workouts (
+  id                serial          PRIMARY KEY,
+  cycle             INTEGER         NOT NULL,
+  week              INTEGER         NOT NULL,
+  day               INTEGER         NOT NULL,
+  scheduled_on      DATE            NOT NULL,
+  completed_at      TIMESTAMP,
+  enrollment_id     INTEGER         NOT NULL REFERENCES enrollments(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  workout_id        INTEGER         REFERENCES workouts(id) ON DELETE SET NULL ON UPDATE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS program_results (
+  id                    serial          PRIMARY KEY,
+  outcome               varchar(16)     NOT NULL,
+  created_at            TIMESTAMP       NOT NULL DEFAULT NOW(),
+  scheduled_workout_id  INTEGER         NOT NULL REFERENCES scheduled_workouts(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  exercise_id           INTEGER         NOT NULL REFERENCES exercise(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  record_id             INTEGER         REFERENCES user_records(id) ON DELETE SET NULL ON UPDATE CASCADE,
+  UNIQUE (scheduled_workout_id, exercise_id)
+);
+
+CREATE INDEX IF NOT EXISTS enrollments_user_id_idx ON enrollments(user_id);
+CREATE INDEX IF NOT EXISTS scheduled_workouts_enrollment_id_scheduled_on_idx ON scheduled_workouts(enrollment_id, scheduled_on);
\ No newline at end of file
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS calendar_tokens (
+  id                serial          PRIMARY KEY,
+  token_hash        varchar(64)     NOT NULL UNIQUE,
+  created_at        TIMESTAMP       NOT NULL DEFAULT NOW(),
+  user_id           INTEGER         NOT NULL UNIQUE REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE program_results DROP COLUMN IF EXISTS date_performed;
+ALTER TABLE program_results DROP COLUMN IF EXISTS previous_weight;
+ALTER TABLE program_results DROP COLUMN IF EXISTS previous_failures;
\ No newline at end of file
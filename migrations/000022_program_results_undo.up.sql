@@ -0,0 +1,6 @@
+-- results keep the training max they replaced and the day of their sets, so a
+-- result can be undone when its sets are edited or deleted
+ALTER TABLE program_results ADD COLUMN IF NOT EXISTS date_performed DATE;
+ALTER TABLE program_results ADD COLUMN IF NOT EXISTS previous_weight NUMERIC;
+ALTER TABLE program_results ADD COLUMN IF NOT EXISTS previous_failures INTEGER;
+UPDATE program_results pr SET date_performed = r.date_performed FROM user_records r WHERE r.id = pr.record_id;
\ No newline at end of file
@@ -0,0 +1,336 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	netmail "net/mail"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-redis/redis"
+	"github.com/reynld/shinpo/server/mail"
+	"github.com/reynld/shinpo/server/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// changeEmailAudience is the audience of email change confirmation links
+const changeEmailAudience = "change-email"
+
+// accountPurgeInterval is how often deleted accounts past their grace period are purged
+const accountPurgeInterval = time.Hour
+
+// defaultDeletionGrace is how long a deleted account keeps its data
+const defaultDeletionGrace = 30 * 24 * time.Hour
+
+// deletionGrace returns how long a deleted account keeps its data, set by ACCOUNT_DELETION_GRACE
+func deletionGrace() time.Duration {
+	d, err := time.ParseDuration(os.Getenv("ACCOUNT_DELETION_GRACE"))
+	if err != nil || d < 0 {
+		return defaultDeletionGrace
+	}
+	return d
+}
+
+// purgeAccounts purges deleted accounts past their grace period
+func purgeAccounts(db *sql.DB) {
+	count, err := models.PurgeDeletedAccounts(db, deletionGrace())
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	if count > 0 {
+		log.Printf("purged %d deleted accounts", count)
+	}
+}
+
+// StartAccountPurge purges deleted accounts past their grace period in the background
+func StartAccountPurge(db *sql.DB) {
+	go func() {
+		purgeAccounts(db)
+		for range time.Tick(accountPurgeInterval) {
+			purgeAccounts(db)
+		}
+	}()
+}
+
+// sendEmailChangeEmail emails a signed confirmation link to the new address of user
+func sendEmailChangeEmail(m mail.Mailer, r *http.Request, user models.Account, email string) error {
+	claims := &models.VerifyClaims{
+		ID:    user.ID,
+		Email: email,
+		StandardClaims: jwt.StandardClaims{
+			Audience:  changeEmailAudience,
+			ExpiresAt: time.Now().Add(verifyEmailTTL).Unix(),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(getJWTKey())
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/me/email/confirm?token=%s", apiURL(r), url.QueryEscape(token))
+	body := fmt.Sprintf(
+		"Hi %s,\n\nConfirm your new email address by following this link, it expires in %s:\n\n%s\n",
+		user.Username,
+		verifyEmailTTL,
+		link,
+	)
+
+	return m.Send(email, "Confirm your new Shinpo email", body)
+}
+
+// GetMe returns the profile of the logged in user
+func GetMe(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+	account, err := models.GetAccount(db, userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	json.NewEncoder(w).Encode(account)
+}
+
+// UpdateMe changes the username, email or weight unit of the logged in user, a new email
+// only replaces the current one once confirmed from the link sent to it
+func UpdateMe(db *sql.DB, m mail.Mailer, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+	var payload models.AccountUpdate
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	account, err := models.GetAccount(db, userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if payload.Username != nil {
+		username := strings.TrimSpace(*payload.Username)
+		if username == "" || len(username) > 40 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("username must be between 1 and 40 characters"))
+			return
+		}
+
+		err = models.UpdateUsername(db, userID, username)
+		if models.IsUniqueViolation(err) {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte("username is already taken"))
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		account.Username = username
+	}
+
+	if payload.WeightUnit != nil {
+		if !models.Units[*payload.WeightUnit] {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("weight_unit must be kg or lb"))
+			return
+		}
+
+		err = models.UpdateWeightUnit(db, userID, *payload.WeightUnit)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		account.WeightUnit = *payload.WeightUnit
+	}
+
+	if payload.Email != nil {
+		email := strings.TrimSpace(*payload.Email)
+		if addr, err := netmail.ParseAddress(email); err != nil || addr.Address != email || len(email) > 40 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invalid email address"))
+			return
+		}
+
+		if strings.EqualFold(email, account.Email) {
+			// asking for the current email back cancels a pending change
+			err = models.SetPendingEmail(db, userID, nil)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			account.PendingEmail = nil
+		} else {
+			_, err := models.GetByEmail(db, email)
+			if err == nil {
+				w.WriteHeader(http.StatusConflict)
+				w.Write([]byte("email is already taken"))
+				return
+			}
+			if err != sql.ErrNoRows {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(err.Error()))
+				return
+			}
+
+			err = models.SetPendingEmail(db, userID, &email)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			account.PendingEmail = &email
+
+			err = sendEmailChangeEmail(m, r, account, email)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(err.Error()))
+				return
+			}
+
+			// Let the current address know in case someone else is changing it
+			body := fmt.Sprintf(
+				"Hi %s,\n\nA change of your account email to %s was requested. If it wasn't you, change your password.\n",
+				account.Username,
+				email,
+			)
+			if err := m.Send(account.Email, "Your Shinpo email is being changed", body); err != nil {
+				log.Print(err)
+			}
+		}
+	}
+
+	json.NewEncoder(w).Encode(account)
+}
+
+// ConfirmEmailChange replaces the user email with the one of the confirmation link
+func ConfirmEmailChange(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	tknStr := r.URL.Query().Get("token")
+	if tknStr == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("token is required"))
+		return
+	}
+
+	claims := &models.VerifyClaims{}
+	tkn, err := jwt.ParseWithClaims(tknStr, claims, func(token *jwt.Token) (interface{}, error) {
+		return getJWTKey(), nil
+	})
+	if err != nil || tkn == nil || !tkn.Valid || !claims.VerifyAudience(changeEmailAudience, true) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("invalid or expired confirmation link"))
+		return
+	}
+
+	err = models.ConfirmEmail(db, claims.ID, claims.Email)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("invalid or expired confirmation link"))
+		return
+	}
+	if models.IsUniqueViolation(err) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("email is already taken"))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Write([]byte("email changed"))
+}
+
+// ChangePassword sets a new password for the logged in user, the current password
+// is required and every other session is logged out
+func ChangePassword(db *sql.DB, c *redis.Client, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+	session := r.Context().Value("Session").(string)
+	var payload models.PasswordChangeRequest
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if payload.CurrentPassword == "" || payload.Password == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("current_password and password are required"))
+		return
+	}
+
+	current, err := models.GetPasswordHash(db, userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(current), []byte(payload.CurrentPassword))
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("current password is incorrect"))
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(payload.Password), 10)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	err = models.UpdatePassword(db, userID, string(hash))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	sessions, err := models.GetUserSessions(c, userID)
+	if err != nil {
+		log.Print(err)
+	}
+	for _, s := range sessions {
+		if s.ID == session {
+			continue
+		}
+		if err := models.DeleteSession(c, s); err != nil {
+			log.Print(err)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteMe deletes the logged in user account and logs out every session,
+// records are purged once the deletion grace period is over
+func DeleteMe(db *sql.DB, c *redis.Client, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+
+	err := models.DeleteAccount(db, userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if err := models.DeleteUserSessions(c, userID); err != nil {
+		log.Print(err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/reynld/shinpo/server/models"
+)
+
+// apiKeyPrefix tells personal API keys apart from access tokens
+const apiKeyPrefix = "shk_"
+
+// serveAPIKey authenticates a request made with a personal API key,
+// the key must be granted one of the route scopes
+func serveAPIKey(db *sql.DB, w http.ResponseWriter, r *http.Request, key string, scopes []string, next http.HandlerFunc) {
+	apiKey, user, err := models.GetAPIKeyUser(db, hashToken(key))
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if !hasScope(apiKey.Scopes, scopes) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("API key is missing the scope required by this route"))
+		return
+	}
+
+	if err := models.TouchAPIKey(db, apiKey.ID); err != nil {
+		log.Print(err)
+	}
+
+	ctx := r.Context()
+	ctx = context.WithValue(ctx, "Username", user.Username)
+	ctx = context.WithValue(ctx, "ID", user.ID)
+	ctx = context.WithValue(ctx, "Session", "")
+	ctx = context.WithValue(ctx, "Verified", user.Verified)
+	ctx = context.WithValue(ctx, "Role", user.Role)
+
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// hasScope checks if granted contains any of required
+func hasScope(granted []string, required []string) bool {
+	for _, g := range granted {
+		for _, r := range required {
+			if g == r {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GetAPIKeys lists the user active API keys
+func GetAPIKeys(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+	keys, err := models.GetAPIKeys(db, userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	json.NewEncoder(w).Encode(keys)
+}
+
+// CreateAPIKey creates a new API key, the key is only ever shown in this response
+func CreateAPIKey(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+	var payload models.APIKeyRequest
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	payload.Name = strings.TrimSpace(payload.Name)
+	if payload.Name == "" || len(payload.Scopes) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("name and scopes are required"))
+		return
+	}
+	for _, scope := range payload.Scopes {
+		if !models.Scopes[scope] {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("unknown scope " + scope))
+			return
+		}
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	key := apiKeyPrefix + secret
+
+	apiKey, err := models.CreateAPIKey(db, models.APIKey{
+		Name:   payload.Name,
+		Prefix: key[:len(apiKeyPrefix)+6],
+		Scopes: payload.Scopes,
+		UserID: userID,
+	}, hashToken(key))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	apiKey.Key = key
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(apiKey)
+}
+
+// RevokeAPIKey revokes one of the user API keys
+func RevokeAPIKey(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	count, err := models.RevokeAPIKey(db, userID, id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]int{"count": count})
+}
@@ -2,21 +2,30 @@ package auth
 
 import (
 	"context"
+	"database/sql"
+	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-redis/redis"
 	"github.com/reynld/shinpo/server/models"
 )
 
-//getJWTKey returns JWTiKey
+// accessTokenTTL is how long an access token is valid for
+const accessTokenTTL = 15 * time.Minute
+
+// getJWTKey returns JWTiKey, it signs HS256 access tokens and internal tokens
+// such as verification links whatever the access token algorithm is
 func getJWTKey() []byte {
 	return []byte(os.Getenv("JWT_KEY"))
 }
 
-// Protected middleware
-func Protected(next http.HandlerFunc) http.HandlerFunc {
+// Protected middleware, accepts access tokens and personal API keys
+// granted one of scopes. Routes without scopes are only open to access tokens.
+func Protected(db *sql.DB, c *redis.Client, next http.HandlerFunc, scopes ...string) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// We can obtain the session token from the requests cookies, which come with every request
 		// c, err := r.Cookie("token")
@@ -34,12 +43,17 @@ func Protected(next http.HandlerFunc) http.HandlerFunc {
 		// // Get the JWT string from the cookie
 		// tknStr := c.Value
 
-		tknStr := r.Header.Get("Authorization")
+		tknStr := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
 		if len(tknStr) <= 0 {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
 
+		if strings.HasPrefix(tknStr, apiKeyPrefix) {
+			serveAPIKey(db, w, r, tknStr, scopes, next)
+			return
+		}
+
 		// Initialize a new instance of `Claims`
 		claims := &models.Claims{}
 
@@ -47,11 +61,9 @@ func Protected(next http.HandlerFunc) http.HandlerFunc {
 		// Note that we are passing the key in this method as well. This method will return an error
 		// if the token is invalid (if it has expired according to the expiry time we set on sign in),
 		// or if the signature does not match
-		tkn, err := jwt.ParseWithClaims(tknStr, claims, func(token *jwt.Token) (interface{}, error) {
-			return getJWTKey(), nil
-		})
+		tkn, err := jwt.ParseWithClaims(tknStr, claims, accessTokenKey)
 
-		if !tkn.Valid {
+		if tkn == nil || !tkn.Valid {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
@@ -65,93 +77,54 @@ func Protected(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
+		// Tokens of a logged out or kicked session are rejected even if they have not expired yet
+		session, err := models.GetSession(c, claims.Id)
+		if err == redis.Nil || (err == nil && session.UserID != claims.ID) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if err := models.TouchSession(c, session.ID); err != nil {
+			log.Print(err)
+		}
+
 		ctx := r.Context()
 		ctx = context.WithValue(ctx, "Username", claims.Username)
 		ctx = context.WithValue(ctx, "ID", claims.ID)
+		ctx = context.WithValue(ctx, "Session", claims.Id)
+		ctx = context.WithValue(ctx, "Verified", claims.Verified)
+		ctx = context.WithValue(ctx, "Role", claims.Role)
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-// GenerateToken creates JWT
-func GenerateToken(u *models.UserResponse) (models.JWTResponse, error) {
-	// Declare the expiration time of the token
-	// here, we have kept it as 5 minutes
-	expirationTime := time.Now().Add(5 * time.Hour)
+// GenerateToken creates JWT for session
+func GenerateToken(u *models.UserResponse, session string) (models.JWTResponse, error) {
+	// Declare the expiration time of the token, it is kept short lived
+	// since clients renew it with their refresh token
+	expirationTime := time.Now().Add(accessTokenTTL)
 	// Create the JWT claims, which includes the username and expiry time
 	claims := &models.Claims{
 		Username: u.Username,
 		ID:       u.ID,
 		Email:    u.Email,
+		Verified: u.Verified,
+		Role:     u.Role,
 		StandardClaims: jwt.StandardClaims{
+			Id: session,
 			// In JWT, the expiry time is expressed as unix milliseconds
 			ExpiresAt: expirationTime.Unix(),
 		},
 	}
 
-	// Declare the token with the algorithm used for signing, and the claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	// Create the JWT string
-	tokenString, err := token.SignedString(getJWTKey())
+	// Sign the token with the configured algorithm and current key
+	tokenString, err := signAccessToken(claims)
 	if err != nil {
 		return models.JWTResponse{}, err
 	}
 	return models.JWTResponse{Token: tokenString, Time: expirationTime}, nil
 }
-
-// Refresh JWT handler
-func Refresh(w http.ResponseWriter, r *http.Request) {
-	// (BEGIN) The code uptil this point is the same as the first part of the `Welcome` route
-	c, err := r.Cookie("token")
-	if err != nil {
-		if err == http.ErrNoCookie {
-			w.WriteHeader(http.StatusUnauthorized)
-			return
-		}
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-	tknStr := c.Value
-	claims := &models.Claims{}
-	tkn, err := jwt.ParseWithClaims(tknStr, claims, func(token *jwt.Token) (interface{}, error) {
-		return getJWTKey(), nil
-	})
-	if !tkn.Valid {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
-	if err != nil {
-		if err == jwt.ErrSignatureInvalid {
-			w.WriteHeader(http.StatusUnauthorized)
-			return
-		}
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-	// (END) The code up-till this point is the same as the first part of the `Welcome` route
-
-	// We ensure that a new token is not issued until enough time has elapsed
-	// In this case, a new token will only be issued if the old token is within
-	// 30 seconds of expiry. Otherwise, return a bad request status
-	if time.Unix(claims.ExpiresAt, 0).Sub(time.Now()) > 30*time.Second {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	// Now, create a new token for the current use, with a renewed expiration time
-	expirationTime := time.Now().Add(5 * time.Minute)
-	claims.ExpiresAt = expirationTime.Unix()
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(getJWTKey())
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	// Set the new token as the users `token` cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:    "token",
-		Value:   tokenString,
-		Expires: expirationTime,
-	})
-}
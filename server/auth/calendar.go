@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/reynld/shinpo/server/models"
+)
+
+// calendarFeedHistory is how many days of past scheduled workouts the calendar
+// feed keeps
+const calendarFeedHistory = 28
+
+// CreateCalendarToken creates the secret token of the user calendar feed,
+// replacing the previous one. The token is only ever shown in this response
+func CreateCalendarToken(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+
+	token, err := randomToken(32)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err := models.SetCalendarToken(db, userID, hashToken(token)); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{
+		"token": token,
+		"url":   "/calendar.ics?token=" + token,
+	})
+}
+
+// RevokeCalendarToken revokes the user calendar feed token
+func RevokeCalendarToken(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+
+	count, err := models.RevokeCalendarToken(db, userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]int{"count": count})
+}
+
+// CalendarFeed the iCalendar feed handler, authenticated by the token query
+// parameter so calendar clients can subscribe to it. It has the workouts
+// scheduled by the user programs from 4 weeks ago on, in their weight unit
+func CalendarFeed(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	userID, err := models.GetCalendarTokenUser(db, hashToken(token))
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	unit, err := models.GetWeightUnit(db, userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	now := time.Now().UTC()
+	workouts, err := models.GetScheduledWorkouts(db, userID, models.ScheduleFilter{
+		From: now.AddDate(0, 0, -calendarFeedHistory).Format("2006-01-02"),
+	}, unit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Cache-Control", "private, max-age=900")
+	models.WriteICS(w, workouts, now)
+}
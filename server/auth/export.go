@@ -0,0 +1,430 @@
+package auth
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/gorilla/mux"
+	"github.com/reynld/shinpo/server/models"
+)
+
+// exportTTL is how long a built archive can be downloaded for
+const exportTTL = 24 * time.Hour
+
+// exportStaleAfter is how long a job can stay pending before it is considered lost
+const exportStaleAfter = time.Hour
+
+// exportCleanupInterval is how often expired archives are deleted
+const exportCleanupInterval = time.Hour
+
+// exportArchive is the JSON document of an export archive
+type exportArchive struct {
+	ExportedAt      time.Time               `json:"exported_at"`
+	Profile         models.Account          `json:"profile"`
+	Records         []models.ExportRecord   `json:"records"`
+	Workouts        []models.Workout        `json:"workouts"`
+	PersonalRecords []models.PersonalRecord `json:"personal_records"`
+	Templates       []models.Template       `json:"templates"`
+	Enrollments     []models.Enrollment     `json:"enrollments"`
+	ProgramResults  []models.ProgramResult  `json:"program_results"`
+	APIKeys         []models.APIKey         `json:"api_keys"`
+	Identities      []models.Identity       `json:"identities"`
+	Sessions        []models.Session        `json:"sessions"`
+	AuditLog        []models.AuditEntry     `json:"audit_log"`
+}
+
+// exportTable is a CSV file of an export archive
+type exportTable struct {
+	name   string
+	header []string
+	rows   [][]string
+}
+
+// StartExportCleanup deletes expired export archives in the background
+func StartExportCleanup(db *sql.DB) {
+	go func() {
+		for range time.Tick(exportCleanupInterval) {
+			if _, err := models.DeleteExpiredExportJobs(db, exportStaleAfter); err != nil {
+				log.Print(err)
+			}
+		}
+	}()
+}
+
+// writeCSV adds a CSV file made of header and rows to archive
+func writeCSV(archive *zip.Writer, name string, header []string, rows [][]string) error {
+	f, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(f)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// formatTime formats a time of an export CSV file, empty when missing
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// formatID formats an optional ID of an export CSV file, empty when missing
+func formatID(id *int) string {
+	if id == nil {
+		return ""
+	}
+	return strconv.Itoa(*id)
+}
+
+// loadExportArchive gets every piece of data kept about user, weights are in
+// the unit of their profile
+func loadExportArchive(db *sql.DB, c *redis.Client, userID int) (exportArchive, error) {
+	a := exportArchive{ExportedAt: time.Now().UTC()}
+	var err error
+
+	if a.Profile, err = models.GetAccount(db, userID); err != nil {
+		return a, err
+	}
+	unit := a.Profile.WeightUnit
+	if a.Records, err = models.GetExportRecords(db, userID, unit); err != nil {
+		return a, err
+	}
+
+	if a.Workouts, err = models.GetWorkouts(db, userID); err != nil {
+		return a, err
+	}
+	for i := range a.Workouts {
+		a.Workouts[i].ConvertWeights(unit)
+	}
+
+	prs, err := models.GetPersonalRecords(db, userID, 0)
+	if err != nil {
+		return a, err
+	}
+	a.PersonalRecords = []models.PersonalRecord{}
+	for _, exercise := range prs {
+		for _, pr := range exercise.History {
+			pr.ConvertWeight(unit)
+			a.PersonalRecords = append(a.PersonalRecords, pr)
+		}
+	}
+
+	if a.Templates, err = models.GetTemplates(db, userID); err != nil {
+		return a, err
+	}
+	if a.Enrollments, err = models.GetEnrollments(db, userID); err != nil {
+		return a, err
+	}
+	for i := range a.Enrollments {
+		a.Enrollments[i].ConvertWeights(unit)
+	}
+	if a.ProgramResults, err = models.GetProgramResults(db, userID); err != nil {
+		return a, err
+	}
+	if a.APIKeys, err = models.GetAPIKeys(db, userID); err != nil {
+		return a, err
+	}
+	if a.Identities, err = models.GetIdentities(db, userID); err != nil {
+		return a, err
+	}
+	if a.Sessions, err = models.GetUserSessions(c, userID); err != nil {
+		return a, err
+	}
+	if a.AuditLog, err = models.GetAuditEntries(db, userID); err != nil {
+		return a, err
+	}
+
+	return a, nil
+}
+
+// exportTables lays out the data of an archive as one CSV file per table
+func exportTables(a exportArchive) []exportTable {
+	pendingEmail := ""
+	if a.Profile.PendingEmail != nil {
+		pendingEmail = *a.Profile.PendingEmail
+	}
+	profile := exportTable{"profile.csv",
+		[]string{"id", "username", "email", "pending_email", "verified", "role", "two_factor", "weight_unit"},
+		[][]string{{
+			strconv.Itoa(a.Profile.ID),
+			a.Profile.Username,
+			a.Profile.Email,
+			pendingEmail,
+			strconv.FormatBool(a.Profile.Verified),
+			a.Profile.Role,
+			strconv.FormatBool(a.Profile.TwoFactor),
+			a.Profile.WeightUnit,
+		}},
+	}
+
+	records := exportTable{"records.csv", models.ExportRecordColumns, [][]string{}}
+	for _, r := range a.Records {
+		records.rows = append(records.rows, r.CSVRow())
+	}
+
+	workouts := exportTable{"workouts.csv", []string{"id", "started_at", "ended_at", "template_id", "notes"}, [][]string{}}
+	workoutExercises := exportTable{"workout_exercises.csv", []string{"id", "workout_id", "position", "exercise_id", "notes"}, [][]string{}}
+	for _, w := range a.Workouts {
+		workouts.rows = append(workouts.rows, []string{
+			strconv.Itoa(w.ID), formatTime(&w.StartedAt), formatTime(w.EndedAt), formatID(w.TemplateID), w.Notes,
+		})
+		for i, e := range w.Exercises {
+			workoutExercises.rows = append(workoutExercises.rows, []string{
+				strconv.Itoa(e.ID), strconv.Itoa(w.ID), strconv.Itoa(i + 1), strconv.Itoa(e.ExerciseID), e.Notes,
+			})
+		}
+	}
+
+	prs := exportTable{"personal_records.csv",
+		[]string{"id", "type", "value", "previous", "weight", "weight_unit", "reps", "date_performed", "created_at", "exercise_id", "record_id"},
+		[][]string{}}
+	for _, pr := range a.PersonalRecords {
+		previous := ""
+		if pr.Previous != nil {
+			previous = pr.Previous.String()
+		}
+		prs.rows = append(prs.rows, []string{
+			strconv.Itoa(pr.ID), pr.Type, pr.Value.String(), previous, pr.Weight.String(), pr.WeightUnit,
+			strconv.Itoa(pr.Reps), pr.DatePerformed, formatTime(&pr.CreatedAt), strconv.Itoa(pr.ExerciseID), strconv.Itoa(pr.RecordID),
+		})
+	}
+
+	templates := exportTable{"templates.csv", []string{"id", "name", "notes", "created_at", "updated_at"}, [][]string{}}
+	templateExercises := exportTable{"template_exercises.csv",
+		[]string{"id", "template_id", "position", "exercise_id", "sets", "reps_min", "reps_max", "rpe", "rest", "notes"},
+		[][]string{}}
+	for _, t := range a.Templates {
+		templates.rows = append(templates.rows, []string{
+			strconv.Itoa(t.ID), t.Name, t.Notes, formatTime(&t.CreatedAt), formatTime(&t.UpdatedAt),
+		})
+		for i, e := range t.Exercises {
+			rpe := ""
+			if e.RPE != nil {
+				rpe = e.RPE.String()
+			}
+			templateExercises.rows = append(templateExercises.rows, []string{
+				strconv.Itoa(e.ID), strconv.Itoa(t.ID), strconv.Itoa(i + 1), strconv.Itoa(e.ExerciseID), strconv.Itoa(e.Sets),
+				strconv.Itoa(e.RepsMin), strconv.Itoa(e.RepsMax), rpe, strconv.Itoa(e.Rest), e.Notes,
+			})
+		}
+	}
+
+	enrollments := exportTable{"enrollments.csv", []string{"id", "program_id", "started_on", "cycles", "status", "created_at"}, [][]string{}}
+	trainingMaxes := exportTable{"training_maxes.csv",
+		[]string{"enrollment_id", "exercise_id", "weight", "weight_unit", "failures", "updated_at"},
+		[][]string{}}
+	for _, e := range a.Enrollments {
+		enrollments.rows = append(enrollments.rows, []string{
+			strconv.Itoa(e.ID), strconv.Itoa(e.ProgramID), e.StartedOn, strconv.Itoa(e.Cycles), e.Status, formatTime(&e.CreatedAt),
+		})
+		for _, tm := range e.TrainingMaxes {
+			trainingMaxes.rows = append(trainingMaxes.rows, []string{
+				strconv.Itoa(e.ID), strconv.Itoa(tm.ExerciseID), tm.Weight.String(), tm.WeightUnit,
+				strconv.Itoa(tm.Failures), formatTime(&tm.UpdatedAt),
+			})
+		}
+	}
+
+	results := exportTable{"program_results.csv",
+		[]string{"id", "enrollment_id", "scheduled_workout_id", "scheduled_on", "exercise_id", "outcome", "record_id", "created_at"},
+		[][]string{}}
+	for _, r := range a.ProgramResults {
+		results.rows = append(results.rows, []string{
+			strconv.Itoa(r.ID), strconv.Itoa(r.EnrollmentID), strconv.Itoa(r.ScheduledWorkoutID), r.ScheduledOn,
+			strconv.Itoa(r.ExerciseID), r.Outcome, formatID(r.RecordID), formatTime(&r.CreatedAt),
+		})
+	}
+
+	apiKeys := exportTable{"api_keys.csv", []string{"id", "name", "prefix", "scopes", "created_at", "last_used_at"}, [][]string{}}
+	for _, k := range a.APIKeys {
+		apiKeys.rows = append(apiKeys.rows, []string{
+			strconv.Itoa(k.ID), k.Name, k.Prefix, strings.Join(k.Scopes, " "), formatTime(&k.CreatedAt), formatTime(k.LastUsedAt),
+		})
+	}
+
+	identities := exportTable{"identities.csv", []string{"id", "provider", "subject", "created_at"}, [][]string{}}
+	for _, i := range a.Identities {
+		identities.rows = append(identities.rows, []string{strconv.Itoa(i.ID), i.Provider, i.Subject, formatTime(&i.CreatedAt)})
+	}
+
+	sessions := exportTable{"sessions.csv", []string{"id", "user_agent", "created_at", "last_seen"}, [][]string{}}
+	for _, s := range a.Sessions {
+		sessions.rows = append(sessions.rows, []string{s.ID, s.UserAgent, formatTime(&s.CreatedAt), formatTime(&s.LastSeen)})
+	}
+
+	auditLog := exportTable{"audit_log.csv", []string{"id", "event", "ip", "detail", "created_at"}, [][]string{}}
+	for _, e := range a.AuditLog {
+		auditLog.rows = append(auditLog.rows, []string{strconv.Itoa(e.ID), e.Event, e.IP, e.Detail, formatTime(&e.CreatedAt)})
+	}
+
+	return []exportTable{
+		profile, records, workouts, workoutExercises, prs, templates, templateExercises,
+		enrollments, trainingMaxes, results, apiKeys, identities, sessions, auditLog,
+	}
+}
+
+// buildExportArchive zips every piece of data kept about user, as one JSON
+// document and one CSV file per table
+func buildExportArchive(db *sql.DB, c *redis.Client, userID int) ([]byte, error) {
+	data, err := loadExportArchive(db, c, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	archive := zip.NewWriter(buf)
+
+	f, err := archive.Create("export.json")
+	if err != nil {
+		return nil, err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(data); err != nil {
+		return nil, err
+	}
+
+	for _, t := range exportTables(data) {
+		if err := writeCSV(archive, t.name, t.header, t.rows); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// runExportJob builds the archive of job and stores it
+func runExportJob(db *sql.DB, c *redis.Client, job models.ExportJob) {
+	archive, err := buildExportArchive(db, c, job.UserID)
+	if err != nil {
+		log.Print(err)
+		if err := models.FailExportJob(db, job.ID, "could not build the archive"); err != nil {
+			log.Print(err)
+		}
+		return
+	}
+
+	if err := models.CompleteExportJob(db, job.ID, archive, time.Now().Add(exportTTL)); err != nil {
+		log.Print(err)
+	}
+}
+
+// writeExportJob writes job along the URLs to poll it and download its archive
+func writeExportJob(w http.ResponseWriter, r *http.Request, job models.ExportJob) {
+	res := map[string]interface{}{
+		"job":        job,
+		"status_url": fmt.Sprintf("%s/me/export/%d", apiURL(r), job.ID),
+	}
+	if job.Status == models.ExportReady {
+		res["download_url"] = fmt.Sprintf("%s/me/export/%d/download", apiURL(r), job.ID)
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// StartExport starts building an archive of the user data, a job still being
+// built or not expired yet is returned instead of starting a new one
+func StartExport(db *sql.DB, c *redis.Client, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+
+	job, err := models.GetActiveExportJob(db, userID)
+	if err == nil {
+		if job.Status == models.ExportPending {
+			w.WriteHeader(http.StatusAccepted)
+		}
+		writeExportJob(w, r, job)
+		return
+	}
+	if err != sql.ErrNoRows {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	job, err = models.CreateExportJob(db, userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	go runExportJob(db, c, job)
+
+	w.WriteHeader(http.StatusAccepted)
+	writeExportJob(w, r, job)
+}
+
+// GetExport returns the status of one of the user export jobs
+func GetExport(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	job, err := models.GetExportJob(db, userID, id)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	writeExportJob(w, r, job)
+}
+
+// DownloadExport sends the archive of a ready export job
+func DownloadExport(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	archive, err := models.GetExportArchive(db, userID, id)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusGone)
+		w.Write([]byte("export is not ready or has expired"))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="shinpo-export-%d.zip"`, id))
+	w.Header().Set("Content-Length", strconv.Itoa(len(archive)))
+	w.Write(archive)
+}
@@ -0,0 +1,311 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/reynld/shinpo/server/models"
+)
+
+// Supported access token signing algorithms
+const (
+	algHS256 = "HS256"
+	algRS256 = "RS256"
+	algEdDSA = "EdDSA"
+)
+
+// keyRefreshInterval is how often keys are reloaded and checked for rotation
+const keyRefreshInterval = time.Minute
+
+// keyPublishDelay is how long a new key is published in the JWKS before it signs
+// anything, so every server instance and every service caching our JWKS knows it
+const keyPublishDelay = 15 * time.Minute
+
+// defaultKeyRotation is how often a new signing key is created
+const defaultKeyRotation = 30 * 24 * time.Hour
+
+// signingKey is a parsed access token signing key
+type signingKey struct {
+	ID          string
+	Alg         string
+	Private     crypto.Signer
+	ActivatesAt time.Time
+}
+
+// keyring holds the signing keys loaded from the database
+var keyring struct {
+	sync.RWMutex
+	keys []signingKey
+}
+
+// signingAlg returns the access token algorithm set in JWT_SIGNING_ALG,
+// HS256 signs with JWT_KEY and needs no key management
+func signingAlg() string {
+	switch alg := os.Getenv("JWT_SIGNING_ALG"); alg {
+	case algRS256, algEdDSA:
+		return alg
+	}
+	return algHS256
+}
+
+// keyRotation returns how often a new signing key is created, set by JWT_KEY_ROTATION
+func keyRotation() time.Duration {
+	d, err := time.ParseDuration(os.Getenv("JWT_KEY_ROTATION"))
+	if err != nil || d <= 0 {
+		return defaultKeyRotation
+	}
+	return d
+}
+
+// generateSigningKey creates a new PEM encoded private key for alg
+func generateSigningKey(alg string, activatesAt time.Time) (models.SigningKey, error) {
+	var private interface{}
+	var err error
+	switch alg {
+	case algRS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case algEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		err = fmt.Errorf("can't generate keys for %s", alg)
+	}
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return models.SigningKey{}, err
+	}
+
+	return models.SigningKey{
+		ID:          hex.EncodeToString(id),
+		Alg:         alg,
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		ActivatesAt: activatesAt,
+	}, nil
+}
+
+// parseSigningKey parses a stored signing key
+func parseSigningKey(k models.SigningKey) (signingKey, error) {
+	block, _ := pem.Decode([]byte(k.PrivateKey))
+	if block == nil {
+		return signingKey{}, fmt.Errorf("signing key %s is not PEM encoded", k.ID)
+	}
+
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return signingKey{}, err
+	}
+
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return signingKey{}, fmt.Errorf("signing key %s has an unsupported type", k.ID)
+	}
+
+	return signingKey{ID: k.ID, Alg: k.Alg, Private: signer, ActivatesAt: k.ActivatesAt}, nil
+}
+
+// rotateKeys creates a signing key when the current one is too old, deletes keys
+// no unexpired token can be signed with anymore, then reloads the keyring
+func rotateKeys(db *sql.DB) error {
+	alg := signingAlg()
+	if alg == algHS256 {
+		return nil
+	}
+
+	stored, err := models.GetSigningKeys(db)
+	if err != nil {
+		return err
+	}
+
+	var newest *models.SigningKey
+	for i := range stored {
+		if stored[i].Alg == alg && (newest == nil || stored[i].CreatedAt.After(newest.CreatedAt)) {
+			newest = &stored[i]
+		}
+	}
+
+	if newest == nil || time.Since(newest.CreatedAt) >= keyRotation() {
+		// the very first key has nothing to replace so it signs right away
+		activatesAt := time.Now().Add(keyPublishDelay)
+		if newest == nil {
+			activatesAt = time.Now()
+		}
+
+		key, err := generateSigningKey(alg, activatesAt)
+		if err != nil {
+			return err
+		}
+		created, err := models.CreateSigningKeyIfStale(db, key, keyRotation())
+		if err != nil {
+			return err
+		}
+		if created {
+			log.Printf("created %s signing key %s", key.Alg, key.ID)
+		}
+
+		// another instance may have created one instead of us, reload either way
+		stored, err = models.GetSigningKeys(db)
+		if err != nil {
+			return err
+		}
+	}
+
+	// A key is retired once a newer key activates, and can be deleted when every
+	// access token it signed has expired
+	now := time.Now()
+	keys := []signingKey{}
+	for i, k := range stored {
+		retired := false
+		for _, newer := range stored[i+1:] {
+			if newer.Alg == alg && newer.ActivatesAt.Before(now.Add(-accessTokenTTL-keyRefreshInterval)) {
+				retired = true
+			}
+		}
+		if retired {
+			if err := models.DeleteSigningKey(db, k.ID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		parsed, err := parseSigningKey(k)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, parsed)
+	}
+
+	keyring.Lock()
+	keyring.keys = keys
+	keyring.Unlock()
+	return nil
+}
+
+// StartKeyRotation loads signing keys, creating one if needed, and keeps
+// rotating them in the background
+func StartKeyRotation(db *sql.DB) error {
+	if err := rotateKeys(db); err != nil {
+		return err
+	}
+
+	go func() {
+		for range time.Tick(keyRefreshInterval) {
+			if err := rotateKeys(db); err != nil {
+				log.Print(err)
+			}
+		}
+	}()
+	return nil
+}
+
+// currentSigningKey returns the newest active key of the configured algorithm
+func currentSigningKey() (signingKey, error) {
+	alg := signingAlg()
+	now := time.Now()
+
+	keyring.RLock()
+	defer keyring.RUnlock()
+
+	for i := len(keyring.keys) - 1; i >= 0; i-- {
+		k := keyring.keys[i]
+		if k.Alg == alg && !k.ActivatesAt.After(now) {
+			return k, nil
+		}
+	}
+	return signingKey{}, fmt.Errorf("no active %s signing key", alg)
+}
+
+// signAccessToken signs claims with the current key, adding its kid header
+func signAccessToken(claims jwt.Claims) (string, error) {
+	if signingAlg() == algHS256 {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(getJWTKey())
+	}
+
+	key, err := currentSigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Alg), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+// accessTokenKey is the jwt.Keyfunc of access tokens, it only accepts the
+// configured algorithm and keys from the keyring
+func accessTokenKey(token *jwt.Token) (interface{}, error) {
+	alg := signingAlg()
+	if token.Method.Alg() != alg {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	if alg == algHS256 {
+		return getJWTKey(), nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+
+	keyring.RLock()
+	defer keyring.RUnlock()
+
+	for _, k := range keyring.keys {
+		if k.ID == kid && k.Alg == alg {
+			return k.Private.Public(), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %s", kid)
+}
+
+// encodeBigInt base64url encodes a big endian integer
+func encodeBigInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+// JWKS publishes the public keys access tokens can be verified with
+func JWKS(w http.ResponseWriter, r *http.Request) {
+	keyring.RLock()
+	keys := []map[string]string{}
+	for _, k := range keyring.keys {
+		jwk := map[string]string{"kid": k.ID, "alg": k.Alg, "use": "sig"}
+		switch public := k.Private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk["kty"] = "RSA"
+			jwk["n"] = encodeBigInt(public.N)
+			jwk["e"] = encodeBigInt(big.NewInt(int64(public.E)))
+		case ed25519.PublicKey:
+			jwk["kty"] = "OKP"
+			jwk["crv"] = "Ed25519"
+			jwk["x"] = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		keys = append(keys, jwk)
+	}
+	keyring.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(keyRefreshInterval.Seconds())*5))
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
@@ -0,0 +1,249 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/reynld/shinpo/server/mail"
+	"github.com/reynld/shinpo/server/models"
+)
+
+// loginMaxUserFailures is how many failed logins a username gets before being locked out
+const loginMaxUserFailures = 5
+
+// loginMaxIPFailures is how many failed logins an IP gets before being locked out,
+// higher than loginMaxUserFailures since many users can share an IP
+const loginMaxIPFailures = 20
+
+// loginFailureWindow is how long failed logins are remembered for
+const loginFailureWindow = time.Hour
+
+// loginLockBase is the first lockout duration, it doubles on each further failure
+const loginLockBase = time.Minute
+
+// loginLockMax caps the lockout duration
+const loginLockMax = 24 * time.Hour
+
+// loginUnlockTTL is how long an unlock link is valid for
+const loginUnlockTTL = 24 * time.Hour
+
+// loginUnlockKind is the one time token kind of unlock links
+const loginUnlockKind = "login_unlock"
+
+// clientIP returns the IP of the request, X-Forwarded-For is only trusted
+// when running behind a proxy with TRUST_PROXY set to true
+func clientIP(r *http.Request) string {
+	if os.Getenv("TRUST_PROXY") == "true" {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// userSubject is the lockout subject of a username
+func userSubject(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+// ipSubject is the lockout subject of an IP
+func ipSubject(ip string) string {
+	return "ip:" + ip
+}
+
+// lockDuration returns the exponential lockout duration after failures
+func lockDuration(failures int64, max int64) time.Duration {
+	d := loginLockBase
+	for i := max; i < failures && d < loginLockMax; i++ {
+		d *= 2
+	}
+	if d > loginLockMax {
+		d = loginLockMax
+	}
+	return d
+}
+
+// loginLockedFor returns how long the longest lock of subjects lasts
+func loginLockedFor(c *redis.Client, subjects ...string) (time.Duration, error) {
+	var longest time.Duration
+	for _, subject := range subjects {
+		d, err := models.GetLoginLock(c, subject)
+		if err != nil {
+			return 0, err
+		}
+		if d > longest {
+			longest = d
+		}
+	}
+	return longest, nil
+}
+
+// writeLocked responds that login is locked out for d
+func writeLocked(w http.ResponseWriter, d time.Duration) {
+	seconds := int(d.Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write([]byte("too many failed login attempts, try again later"))
+}
+
+// lockIfNeeded counts a failure of subject and locks it out once over max,
+// returns the lock duration or 0 if it was not locked
+func lockIfNeeded(c *redis.Client, subject string, max int64) (time.Duration, error) {
+	failures, err := models.IncrLoginFailures(c, subject, loginFailureWindow)
+	if err != nil {
+		return 0, err
+	}
+	if failures < max {
+		return 0, nil
+	}
+
+	d := lockDuration(failures, max)
+	return d, models.LockLogin(c, subject, d)
+}
+
+// registerLoginFailure counts a failed login for the request IP and username,
+// locking them out when needed. user is nil when the username does not exist.
+func registerLoginFailure(db *sql.DB, c *redis.Client, m mail.Mailer, r *http.Request, username string, user *models.UserResponse) {
+	ip := clientIP(r)
+
+	d, err := lockIfNeeded(c, ipSubject(ip), loginMaxIPFailures)
+	if err != nil {
+		log.Print(err)
+	}
+	if d > 0 {
+		audit(db, models.AuditEntry{
+			Event:  models.AuditLoginLockout,
+			IP:     ip,
+			Detail: fmt.Sprintf("ip locked out for %s", d),
+		})
+	}
+
+	d, err = lockIfNeeded(c, userSubject(username), loginMaxUserFailures)
+	if err != nil {
+		log.Print(err)
+	}
+	if d == 0 || user == nil {
+		return
+	}
+
+	audit(db, models.AuditEntry{
+		Event:  models.AuditLoginLockout,
+		IP:     ip,
+		Detail: fmt.Sprintf("account locked out for %s", d),
+		UserID: user.ID,
+	})
+
+	if err := sendUnlockEmail(c, m, r, *user, d); err != nil {
+		log.Print(err)
+	}
+}
+
+// audit saves an audit entry, failures are only logged
+func audit(db *sql.DB, e models.AuditEntry) {
+	if err := models.CreateAuditEntry(db, e); err != nil {
+		log.Print(err)
+	}
+}
+
+// sendUnlockEmail emails a link letting the account owner lift a lockout early,
+// along with the lockout of the IP of the failed login that caused it
+func sendUnlockEmail(c *redis.Client, m mail.Mailer, r *http.Request, user models.UserResponse, d time.Duration) error {
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	err = models.SaveOneTimeToken(c, loginUnlockKind, hashToken(token), user.ID, loginUnlockTTL)
+	if err != nil {
+		return err
+	}
+	err = models.SaveUnlockIP(c, hashToken(token), clientIP(r), loginUnlockTTL)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/login/unlock?token=%s", apiURL(r), url.QueryEscape(token))
+	body := fmt.Sprintf(
+		"Hi %s,\n\nYour account was locked for %s after too many failed login attempts.\n"+
+			"If this was you, follow this link to unlock it now:\n\n%s\n\n"+
+			"If it wasn't you, consider resetting your password.\n",
+		user.Username,
+		d,
+		link,
+	)
+
+	return m.Send(user.Email, "Your Shinpo account was locked", body)
+}
+
+// UnlockLogin lifts the lockout of the account of an unlock link and of the IP
+// it was locked from
+func UnlockLogin(db *sql.DB, c *redis.Client, w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("token is required"))
+		return
+	}
+
+	userID, err := models.ConsumeOneTimeToken(c, loginUnlockKind, hashToken(token))
+	if err == redis.Nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("invalid or expired unlock link"))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	user, err := models.GetUserByID(db, userID)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	err = models.ResetLoginFailures(c, userSubject(user.Username))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	ip, err := models.ConsumeUnlockIP(c, hashToken(token))
+	if err == nil {
+		err = models.ResetLoginFailures(c, ipSubject(ip))
+	}
+	if err != nil && err != redis.Nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	audit(db, models.AuditEntry{
+		Event:  models.AuditLoginUnlock,
+		IP:     clientIP(r),
+		Detail: "account unlocked by email link",
+		UserID: user.ID,
+	})
+
+	w.Write([]byte("account unlocked"))
+}
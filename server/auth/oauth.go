@@ -0,0 +1,560 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/gorilla/mux"
+	"github.com/reynld/shinpo/server/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// oauthStateTTL is how long a user has to come back from the provider
+const oauthStateTTL = 10 * time.Minute
+
+// oauthStateCookie binds an authorization request to the browser that started
+// it, so a callback can't sign a victim into the attacker's account
+const oauthStateCookie = "oauth_state"
+
+// Provider kinds
+const (
+	providerOIDC   = "oidc"
+	providerGitHub = "github"
+)
+
+// oauthProvider is a configured social login provider
+type oauthProvider struct {
+	Name         string
+	Kind         string
+	ClientID     string
+	ClientSecret string
+	Issuer       string
+	Scopes       string
+	AuthURL      string
+	TokenURL     string
+	APIURL       string
+}
+
+// oauthProviderDefaults fills in well known providers so only credentials are needed
+var oauthProviderDefaults = map[string]oauthProvider{
+	"google": {
+		Kind:   providerOIDC,
+		Issuer: "https://accounts.google.com",
+	},
+	"github": {
+		Kind:     providerGitHub,
+		Scopes:   "read:user user:email",
+		AuthURL:  "https://github.com/login/oauth/authorize",
+		TokenURL: "https://github.com/login/oauth/access_token",
+		APIURL:   "https://api.github.com",
+	},
+}
+
+// getOAuthProviders reads providers listed in OAUTH_PROVIDERS, each configured
+// by OAUTH_<NAME>_CLIENT_ID, OAUTH_<NAME>_CLIENT_SECRET and for OIDC providers
+// other than google OAUTH_<NAME>_ISSUER
+func getOAuthProviders() map[string]oauthProvider {
+	providers := map[string]oauthProvider{}
+	for _, name := range strings.Split(os.Getenv("OAUTH_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		env := func(key string) string {
+			return os.Getenv("OAUTH_" + strings.ToUpper(name) + "_" + key)
+		}
+
+		p, ok := oauthProviderDefaults[name]
+		if !ok {
+			p = oauthProvider{Kind: providerOIDC}
+		}
+		p.Name = name
+		p.ClientID = env("CLIENT_ID")
+		p.ClientSecret = env("CLIENT_SECRET")
+		if issuer := env("ISSUER"); issuer != "" {
+			p.Issuer = issuer
+		}
+		if scopes := env("SCOPES"); scopes != "" {
+			p.Scopes = scopes
+		}
+		if p.Scopes == "" {
+			p.Scopes = "openid email profile"
+		}
+
+		if p.ClientID == "" || (p.Kind == providerOIDC && p.Issuer == "") {
+			continue
+		}
+		providers[name] = p
+	}
+	return providers
+}
+
+// endpoints returns provider authorization and token endpoints,
+// discovering them for OIDC providers
+func (p oauthProvider) endpoints() (string, string, error) {
+	if p.Kind != providerOIDC {
+		return p.AuthURL, p.TokenURL, nil
+	}
+	issuer, err := discoverIssuer(p.Issuer, false)
+	if err != nil {
+		return "", "", err
+	}
+	return issuer.config.AuthorizationEndpoint, issuer.config.TokenEndpoint, nil
+}
+
+// oauthRedirectURI is where the provider sends the user back to
+func oauthRedirectURI(r *http.Request, p oauthProvider) string {
+	return fmt.Sprintf("%s/oauth/%s/callback", apiURL(r), p.Name)
+}
+
+// pkceChallenge returns the S256 code challenge of verifier
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// oauthTokenResponse is the token endpoint response
+type oauthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	Error       string `json:"error"`
+}
+
+// exchangeCode trades an authorization code for tokens
+func exchangeCode(p oauthProvider, tokenURL string, redirectURI string, code string, verifier string) (oauthTokenResponse, error) {
+	var tokens oauthTokenResponse
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("client_id", p.ClientID)
+	form.Set("client_secret", p.ClientSecret)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequest("POST", tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return tokens, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	res, err := oauthClient.Do(req)
+	if err != nil {
+		return tokens, err
+	}
+	defer res.Body.Close()
+
+	err = json.NewDecoder(res.Body).Decode(&tokens)
+	if err != nil {
+		return tokens, err
+	}
+	if res.StatusCode != http.StatusOK || tokens.Error != "" {
+		return tokens, fmt.Errorf("token exchange failed: %s %s", res.Status, tokens.Error)
+	}
+	return tokens, nil
+}
+
+// githubIdentity reads the account ID and primary verified email of a GitHub user
+func githubIdentity(p oauthProvider, accessToken string) (oidcIdentity, error) {
+	var identity oidcIdentity
+
+	get := func(path string, v interface{}) error {
+		req, err := http.NewRequest("GET", p.APIURL+path, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		req.Header.Set("Accept", "application/vnd.github+json")
+
+		res, err := oauthClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return fmt.Errorf("GET %s: unexpected status %s", path, res.Status)
+		}
+		return json.NewDecoder(res.Body).Decode(v)
+	}
+
+	var user struct {
+		ID int64 `json:"id"`
+	}
+	if err := get("/user", &user); err != nil {
+		return identity, err
+	}
+	identity.Subject = strconv.FormatInt(user.ID, 10)
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := get("/user/emails", &emails); err != nil {
+		return identity, err
+	}
+	for _, e := range emails {
+		if e.Primary {
+			identity.Email = e.Email
+			identity.EmailVerified = e.Verified
+		}
+	}
+
+	return identity, nil
+}
+
+// errEmailNotVerified is returned when a provider identity can't be linked
+var errEmailNotVerified = fmt.Errorf("provider did not return a verified email")
+
+// usernameCleaner strips characters we don't want in generated usernames
+var usernameCleaner = regexp.MustCompile(`[^a-z0-9_.]`)
+
+// createOAuthUser creates a verified account for a provider identity,
+// it has an unusable password until the user sets one with a reset
+func createOAuthUser(db *sql.DB, email string) (models.UserResponse, error) {
+	base := usernameCleaner.ReplaceAllString(strings.ToLower(strings.Split(email, "@")[0]), "")
+	if len(base) > 30 {
+		base = base[:30]
+	}
+	if base == "" {
+		base = "user"
+	}
+	// a random suffix keeps generated usernames from clashing with existing ones
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return models.UserResponse{}, err
+	}
+	username := base + "_" + hex.EncodeToString(suffix)
+
+	password, err := randomToken(32)
+	if err != nil {
+		return models.UserResponse{}, err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), 10)
+	if err != nil {
+		return models.UserResponse{}, err
+	}
+
+	user, err := models.CreateUser(db, username, string(hash), email)
+	if err != nil {
+		return user, err
+	}
+
+	err = models.VerifyUser(db, user.ID, user.Email)
+	user.Verified = err == nil
+	return user, err
+}
+
+// errAccountNotVerified is returned when the email of a provider identity
+// belongs to an account that never proved owning it
+var errAccountNotVerified = fmt.Errorf("an unverified account already uses this email, verify it or reset its password first")
+
+// oauthUsers finds the accounts of provider identities and creates them
+type oauthUsers interface {
+	identityUser(provider string, subject string) (models.UserResponse, error)
+	userByEmail(email string) (models.UserResponse, error)
+	createUser(email string) (models.UserResponse, error)
+	link(provider string, subject string, userID int) error
+}
+
+// dbOAuthUsers keeps accounts and their identities in the database
+type dbOAuthUsers struct {
+	db *sql.DB
+}
+
+func (u dbOAuthUsers) identityUser(provider string, subject string) (models.UserResponse, error) {
+	return models.GetIdentityUser(u.db, provider, subject)
+}
+
+func (u dbOAuthUsers) userByEmail(email string) (models.UserResponse, error) {
+	user, err := models.GetByEmail(u.db, email)
+	return models.UserResponse{
+		ID:       user.ID,
+		Username: user.Username,
+		Email:    user.Email,
+		Verified: user.Verified,
+		Role:     user.Role,
+	}, err
+}
+
+func (u dbOAuthUsers) createUser(email string) (models.UserResponse, error) {
+	return createOAuthUser(u.db, email)
+}
+
+func (u dbOAuthUsers) link(provider string, subject string, userID int) error {
+	return models.CreateIdentity(u.db, provider, subject, userID)
+}
+
+// resolveOAuthUser finds the user of a provider identity, linking it by verified
+// email to an existing account or creating a new account the first time
+func resolveOAuthUser(users oauthUsers, provider string, identity oidcIdentity) (models.UserResponse, error) {
+	user, err := users.identityUser(provider, identity.Subject)
+	if err != sql.ErrNoRows {
+		return user, err
+	}
+
+	// Linking by an unverified email would let anyone claim an account
+	// by registering its email with the provider
+	if identity.Email == "" || !identity.EmailVerified {
+		return user, errEmailNotVerified
+	}
+
+	user, err = users.userByEmail(identity.Email)
+	if err == sql.ErrNoRows {
+		user, err = users.createUser(identity.Email)
+	} else if err == nil && !user.Verified {
+		// nor to an account registered with someone else's email, which would
+		// hand the provider account to whoever knows its password
+		return models.UserResponse{}, errAccountNotVerified
+	}
+	if err != nil {
+		return user, err
+	}
+
+	err = users.link(provider, identity.Subject, user.ID)
+	return user, err
+}
+
+// oauthStates keeps authorization requests until the provider redirects back
+type oauthStates interface {
+	save(state string, s models.OAuthState) error
+	// consume returns redis.Nil for unknown or already used states
+	consume(state string) (models.OAuthState, error)
+}
+
+// redisOAuthStates keeps authorization requests in redis
+type redisOAuthStates struct {
+	c *redis.Client
+}
+
+func (s redisOAuthStates) save(state string, value models.OAuthState) error {
+	return models.SaveOAuthState(s.c, state, value, oauthStateTTL)
+}
+
+func (s redisOAuthStates) consume(state string) (models.OAuthState, error) {
+	return models.ConsumeOAuthState(s.c, state)
+}
+
+// oauthError is a failed social login and the status it is answered with
+type oauthError struct {
+	status int
+	err    error
+}
+
+func (e *oauthError) Error() string {
+	return e.err.Error()
+}
+
+// startOAuth saves a new authorization request and returns the provider
+// authorization URL to redirect the user to, and the hash of its state kept by
+// the browser until the callback
+func startOAuth(states oauthStates, p oauthProvider, authURL string, redirectURI string) (string, string, error) {
+	state, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomToken(16)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = states.save(state, models.OAuthState{
+		Provider: p.Name,
+		Verifier: verifier,
+		Nonce:    nonce,
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.ClientID)
+	params.Set("redirect_uri", redirectURI)
+	params.Set("scope", p.Scopes)
+	params.Set("state", state)
+	params.Set("code_challenge", pkceChallenge(verifier))
+	params.Set("code_challenge_method", "S256")
+	if p.Kind == providerOIDC {
+		params.Set("nonce", nonce)
+	}
+
+	separator := "?"
+	if strings.Contains(authURL, "?") {
+		separator = "&"
+	}
+	return authURL + separator + params.Encode(), hashToken(state), nil
+}
+
+// finishOAuth checks the state of a provider redirect against the state hash
+// kept by the browser, trades its code for the provider identity and returns
+// the user of that identity. Failures the user can cause are *oauthError
+func finishOAuth(states oauthStates, users oauthUsers, p oauthProvider, redirectURI string, stateHash string, query url.Values) (models.UserResponse, error) {
+	var user models.UserResponse
+	if e := query.Get("error"); e != "" {
+		return user, &oauthError{http.StatusUnauthorized, fmt.Errorf("%s", e)}
+	}
+	if subtle.ConstantTimeCompare([]byte(stateHash), []byte(hashToken(query.Get("state")))) != 1 {
+		return user, &oauthError{http.StatusBadRequest, fmt.Errorf("state wasn't started by this browser")}
+	}
+
+	state, err := states.consume(query.Get("state"))
+	if err == redis.Nil || (err == nil && state.Provider != p.Name) {
+		return user, &oauthError{http.StatusBadRequest, fmt.Errorf("invalid or expired state")}
+	}
+	if err != nil {
+		return user, err
+	}
+
+	_, tokenURL, err := p.endpoints()
+	if err != nil {
+		return user, &oauthError{http.StatusBadGateway, err}
+	}
+
+	tokens, err := exchangeCode(p, tokenURL, redirectURI, query.Get("code"), state.Verifier)
+	if err != nil {
+		return user, &oauthError{http.StatusUnauthorized, err}
+	}
+
+	var identity oidcIdentity
+	if p.Kind == providerGitHub {
+		identity, err = githubIdentity(p, tokens.AccessToken)
+	} else {
+		identity, err = verifyIDToken(p.Issuer, p.ClientID, state.Nonce, tokens.IDToken)
+	}
+	if err != nil {
+		return user, &oauthError{http.StatusUnauthorized, err}
+	}
+
+	user, err = resolveOAuthUser(users, p.Name, identity)
+	switch err {
+	case errEmailNotVerified:
+		return user, &oauthError{http.StatusForbidden, err}
+	case errAccountNotVerified:
+		return user, &oauthError{http.StatusConflict, err}
+	}
+	return user, err
+}
+
+// GetOAuthProviders lists configured social login providers
+func GetOAuthProviders(w http.ResponseWriter, r *http.Request) {
+	names := []string{}
+	for name := range getOAuthProviders() {
+		names = append(names, name)
+	}
+	json.NewEncoder(w).Encode(names)
+}
+
+// OAuthLogin redirects the user to the provider authorization page
+func OAuthLogin(c *redis.Client, w http.ResponseWriter, r *http.Request) {
+	p, ok := getOAuthProviders()[mux.Vars(r)["provider"]]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("unknown provider"))
+		return
+	}
+
+	authURL, _, err := p.endpoints()
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	redirect, stateHash, err := startOAuth(redisOAuthStates{c}, p, authURL, oauthRedirectURI(r, p))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    stateHash,
+		Path:     "/",
+		MaxAge:   int(oauthStateTTL.Seconds()),
+		Secure:   strings.HasPrefix(apiURL(r), "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// OAuthCallback finishes a social login, responding with tokens or with a
+// challenge token when the account has two factor enabled. When CLIENT_URL is set
+// the user is redirected to CLIENT_URL/oauth/callback with the response in the fragment.
+func OAuthCallback(db *sql.DB, c *redis.Client, w http.ResponseWriter, r *http.Request) {
+	p, ok := getOAuthProviders()[mux.Vars(r)["provider"]]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("unknown provider"))
+		return
+	}
+
+	stateHash := ""
+	if cookie, err := r.Cookie(oauthStateCookie); err == nil {
+		stateHash = cookie.Value
+	}
+	http.SetCookie(w, &http.Cookie{Name: oauthStateCookie, Path: "/", MaxAge: -1})
+
+	user, err := finishOAuth(redisOAuthStates{c}, dbOAuthUsers{db}, p, oauthRedirectURI(r, p), stateHash, r.URL.Query())
+	if e, ok := err.(*oauthError); ok {
+		w.WriteHeader(e.status)
+		w.Write([]byte(e.Error()))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	totp, err := models.GetTOTP(db, user.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	var response map[string]string
+	if totp.Enabled {
+		response, err = challengeResponse(user.ID)
+	} else {
+		response, err = issueTokens(c, r, user, "")
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if clientURL := os.Getenv("CLIENT_URL"); clientURL != "" {
+		fragment := url.Values{}
+		for key, value := range response {
+			fragment.Set(key, value)
+		}
+		http.Redirect(w, r, clientURL+"/oauth/callback#"+fragment.Encode(), http.StatusFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
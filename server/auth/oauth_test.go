@@ -0,0 +1,429 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-redis/redis"
+	"github.com/reynld/shinpo/server/models"
+)
+
+const (
+	testClientID    = "shinpo-test"
+	testRedirectURI = "https://api.example.com/oauth/test/callback"
+	testKeyID       = "test-key"
+)
+
+// fakeIssuer is an OpenID provider serving discovery, JWKS, authorization and
+// token endpoints. The token endpoint enforces PKCE like a real provider
+type fakeIssuer struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	// authorization requests by code
+	challenges map[string]string
+	nonces     map[string]string
+
+	// claims and signing of the next ID token
+	subject       string
+	email         string
+	emailVerified bool
+	audience      string
+	nonce         string
+	signingKey    interface{}
+	method        jwt.SigningMethod
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeIssuer{
+		t:             t,
+		key:           key,
+		challenges:    map[string]string{},
+		nonces:        map[string]string{},
+		subject:       "provider-user-1",
+		email:         "lifter@example.com",
+		emailVerified: true,
+		audience:      testClientID,
+		signingKey:    key,
+		method:        jwt.SigningMethodRS256,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", f.discovery)
+	mux.HandleFunc("/jwks", f.jwks)
+	mux.HandleFunc("/authorize", f.authorize)
+	mux.HandleFunc("/token", f.token)
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeIssuer) provider() oauthProvider {
+	return oauthProvider{
+		Name:     "test",
+		Kind:     providerOIDC,
+		ClientID: testClientID,
+		Issuer:   f.server.URL,
+		Scopes:   "openid email",
+	}
+}
+
+func (f *fakeIssuer) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(oidcConfig{
+		Issuer:                f.server.URL,
+		AuthorizationEndpoint: f.server.URL + "/authorize",
+		TokenEndpoint:         f.server.URL + "/token",
+		JWKSURI:               f.server.URL + "/jwks",
+	})
+}
+
+func (f *fakeIssuer) jwks(w http.ResponseWriter, r *http.Request) {
+	encode := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	json.NewEncoder(w).Encode(map[string][]jsonWebKey{
+		"keys": {{
+			Kid: testKeyID,
+			Kty: "RSA",
+			N:   encode(f.key.N.Bytes()),
+			E:   encode(big.NewInt(int64(f.key.E)).Bytes()),
+		}},
+	})
+}
+
+// authorize logs the user in right away and redirects back with a code
+func (f *fakeIssuer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != testClientID || q.Get("code_challenge_method") != "S256" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	code := "code-" + q.Get("state")
+	f.challenges[code] = q.Get("code_challenge")
+	f.nonces[code] = q.Get("nonce")
+
+	back := url.Values{}
+	back.Set("code", code)
+	back.Set("state", q.Get("state"))
+	http.Redirect(w, r, q.Get("redirect_uri")+"?"+back.Encode(), http.StatusFound)
+}
+
+func (f *fakeIssuer) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	code := r.PostForm.Get("code")
+	challenge, ok := f.challenges[code]
+	if !ok || r.PostForm.Get("client_id") != testClientID || r.PostForm.Get("redirect_uri") != testRedirectURI {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	if pkceChallenge(r.PostForm.Get("code_verifier")) != challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	delete(f.challenges, code)
+
+	nonce := f.nonces[code]
+	if f.nonce != "" {
+		nonce = f.nonce
+	}
+	token := jwt.NewWithClaims(f.method, jwt.MapClaims{
+		"iss":            f.server.URL,
+		"sub":            f.subject,
+		"aud":            f.audience,
+		"exp":            time.Now().Add(time.Minute).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          nonce,
+		"email":          f.email,
+		"email_verified": f.emailVerified,
+	})
+	token.Header["kid"] = testKeyID
+	idToken, err := token.SignedString(f.signingKey)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	json.NewEncoder(w).Encode(oauthTokenResponse{AccessToken: "access", IDToken: idToken})
+}
+
+// memoryStates keeps authorization requests in memory
+type memoryStates map[string]models.OAuthState
+
+func (s memoryStates) save(state string, value models.OAuthState) error {
+	s[state] = value
+	return nil
+}
+
+func (s memoryStates) consume(state string) (models.OAuthState, error) {
+	value, ok := s[state]
+	if !ok {
+		return value, redis.Nil
+	}
+	delete(s, state)
+	return value, nil
+}
+
+// memoryUsers keeps accounts and identities in memory
+type memoryUsers struct {
+	users      []models.UserResponse
+	identities map[string]int
+}
+
+func newMemoryUsers(users ...models.UserResponse) *memoryUsers {
+	return &memoryUsers{users: users, identities: map[string]int{}}
+}
+
+func (u *memoryUsers) user(id int) (models.UserResponse, error) {
+	for _, user := range u.users {
+		if user.ID == id {
+			return user, nil
+		}
+	}
+	return models.UserResponse{}, sql.ErrNoRows
+}
+
+func (u *memoryUsers) identityUser(provider string, subject string) (models.UserResponse, error) {
+	id, ok := u.identities[provider+"/"+subject]
+	if !ok {
+		return models.UserResponse{}, sql.ErrNoRows
+	}
+	return u.user(id)
+}
+
+func (u *memoryUsers) userByEmail(email string) (models.UserResponse, error) {
+	for _, user := range u.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return models.UserResponse{}, sql.ErrNoRows
+}
+
+func (u *memoryUsers) createUser(email string) (models.UserResponse, error) {
+	user := models.UserResponse{ID: len(u.users) + 1, Username: email, Email: email, Verified: true, Role: models.RoleUser}
+	u.users = append(u.users, user)
+	return user, nil
+}
+
+func (u *memoryUsers) link(provider string, subject string, userID int) error {
+	u.identities[provider+"/"+subject] = userID
+	return nil
+}
+
+// login runs the authorization flow against the fake issuer and returns the
+// query the provider redirects back with and the state hash of the browser
+func login(t *testing.T, f *fakeIssuer, states oauthStates) (url.Values, string) {
+	p := f.provider()
+	authURL, _, err := p.endpoints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirect, stateHash, err := startOAuth(states, p, authURL, testRedirectURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err := client.Get(redirect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("authorize answered %s", res.Status)
+	}
+
+	back, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return back.Query(), stateHash
+}
+
+// expectStatus checks err is an oauthError answered with status
+func expectStatus(t *testing.T, err error, status int) {
+	t.Helper()
+	e, ok := err.(*oauthError)
+	if !ok {
+		t.Fatalf("expected a %d oauthError, got %v", status, err)
+	}
+	if e.status != status {
+		t.Fatalf("expected status %d, got %d: %v", status, e.status, e.err)
+	}
+}
+
+func TestOAuthCreatesUser(t *testing.T) {
+	f := newFakeIssuer(t)
+	states := memoryStates{}
+	users := newMemoryUsers()
+
+	query, stateHash := login(t, f, states)
+	user, err := finishOAuth(states, users, f.provider(), testRedirectURI, stateHash, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != f.email || len(users.users) != 1 {
+		t.Fatalf("expected a new user for %s, got %+v", f.email, users.users)
+	}
+	if users.identities["test/"+f.subject] != user.ID {
+		t.Fatal("identity was not linked to the new user")
+	}
+
+	// signing in again finds the linked identity
+	query, stateHash = login(t, f, states)
+	again, err := finishOAuth(states, users, f.provider(), testRedirectURI, stateHash, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != user.ID || len(users.users) != 1 {
+		t.Fatalf("expected user %d again, got %d", user.ID, again.ID)
+	}
+}
+
+func TestOAuthLinksVerifiedUser(t *testing.T) {
+	f := newFakeIssuer(t)
+	states := memoryStates{}
+	users := newMemoryUsers(models.UserResponse{ID: 7, Username: "lifter", Email: f.email, Verified: true})
+
+	query, stateHash := login(t, f, states)
+	user, err := finishOAuth(states, users, f.provider(), testRedirectURI, stateHash, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != 7 || len(users.users) != 1 {
+		t.Fatalf("expected existing user 7, got %+v", user)
+	}
+	if users.identities["test/"+f.subject] != 7 {
+		t.Fatal("identity was not linked to the existing user")
+	}
+}
+
+func TestOAuthRefusesUnverifiedUser(t *testing.T) {
+	f := newFakeIssuer(t)
+	states := memoryStates{}
+	users := newMemoryUsers(models.UserResponse{ID: 7, Username: "squatter", Email: f.email})
+
+	query, stateHash := login(t, f, states)
+	_, err := finishOAuth(states, users, f.provider(), testRedirectURI, stateHash, query)
+	expectStatus(t, err, http.StatusConflict)
+	if len(users.identities) != 0 {
+		t.Fatal("identity was linked to an unverified account")
+	}
+}
+
+func TestOAuthRefusesUnverifiedEmail(t *testing.T) {
+	f := newFakeIssuer(t)
+	f.emailVerified = false
+	states := memoryStates{}
+	users := newMemoryUsers()
+
+	query, stateHash := login(t, f, states)
+	_, err := finishOAuth(states, users, f.provider(), testRedirectURI, stateHash, query)
+	expectStatus(t, err, http.StatusForbidden)
+	if len(users.users) != 0 {
+		t.Fatal("user was created for an unverified email")
+	}
+}
+
+func TestOAuthState(t *testing.T) {
+	f := newFakeIssuer(t)
+	states := memoryStates{}
+	users := newMemoryUsers()
+
+	query, stateHash := login(t, f, states)
+	forged := url.Values{"code": {query.Get("code")}, "state": {"forged"}}
+	_, err := finishOAuth(states, users, f.provider(), testRedirectURI, hashToken("forged"), forged)
+	expectStatus(t, err, http.StatusBadRequest)
+
+	other := f.provider()
+	other.Name = "other"
+	_, err = finishOAuth(states, users, other, testRedirectURI, stateHash, query)
+	expectStatus(t, err, http.StatusBadRequest)
+
+	// the state was consumed by the attempt above
+	_, err = finishOAuth(states, users, f.provider(), testRedirectURI, stateHash, query)
+	expectStatus(t, err, http.StatusBadRequest)
+}
+
+func TestOAuthStateCookie(t *testing.T) {
+	f := newFakeIssuer(t)
+	states := memoryStates{}
+	users := newMemoryUsers()
+
+	// the victim's browser comes back with the attacker's callback
+	query, _ := login(t, f, states)
+	_, victim := login(t, f, states)
+	for _, stateHash := range []string{"", victim} {
+		_, err := finishOAuth(states, users, f.provider(), testRedirectURI, stateHash, query)
+		expectStatus(t, err, http.StatusBadRequest)
+	}
+	if len(users.users) != 0 {
+		t.Fatal("user was signed in from another browser's state")
+	}
+	if _, ok := states[query.Get("state")]; !ok {
+		t.Fatal("state was consumed by another browser")
+	}
+}
+
+func TestOAuthPKCE(t *testing.T) {
+	f := newFakeIssuer(t)
+	states := memoryStates{}
+	users := newMemoryUsers()
+
+	query, stateHash := login(t, f, states)
+	state := states[query.Get("state")]
+	state.Verifier = "not-the-verifier"
+	states[query.Get("state")] = state
+
+	_, err := finishOAuth(states, users, f.provider(), testRedirectURI, stateHash, query)
+	expectStatus(t, err, http.StatusUnauthorized)
+}
+
+func TestOAuthIDToken(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]func(f *fakeIssuer){
+		"nonce mismatch": func(f *fakeIssuer) { f.nonce = "replayed" },
+		"wrong audience": func(f *fakeIssuer) { f.audience = "another-client" },
+		"wrong key":      func(f *fakeIssuer) { f.signingKey = otherKey },
+		"hmac": func(f *fakeIssuer) {
+			f.method = jwt.SigningMethodHS256
+			f.signingKey = f.key.N.Bytes()
+		},
+	}
+	for name, tamper := range cases {
+		t.Run(name, func(t *testing.T) {
+			f := newFakeIssuer(t)
+			tamper(f)
+			states := memoryStates{}
+			users := newMemoryUsers()
+
+			query, stateHash := login(t, f, states)
+			_, err := finishOAuth(states, users, f.provider(), testRedirectURI, stateHash, query)
+			expectStatus(t, err, http.StatusUnauthorized)
+			if len(users.users) != 0 {
+				t.Fatal("user was created from a rejected token")
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// oidcCacheTTL is how long discovery documents and signing keys are cached for
+const oidcCacheTTL = time.Hour
+
+// oauthClient is used for every request made to providers
+var oauthClient = &http.Client{Timeout: 10 * time.Second}
+
+// oidcConfig is the part of an OpenID provider discovery document we use
+type oidcConfig struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// jsonWebKey is a public key of a JWKS document
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// oidcIssuer caches the discovery document and signing keys of an issuer
+type oidcIssuer struct {
+	config    oidcConfig
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+var (
+	oidcIssuersMu sync.Mutex
+	oidcIssuers   = map[string]*oidcIssuer{}
+)
+
+// getJSON fetches url and decodes its JSON body into v
+func getJSON(url string, v interface{}) error {
+	res, err := oauthClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %s", url, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// discoverIssuer returns the cached issuer, fetching it again when stale or when
+// refresh is set, which happens when a token is signed with an unknown key
+func discoverIssuer(issuer string, refresh bool) (*oidcIssuer, error) {
+	oidcIssuersMu.Lock()
+	defer oidcIssuersMu.Unlock()
+
+	cached, ok := oidcIssuers[issuer]
+	if ok && !refresh && time.Since(cached.fetchedAt) < oidcCacheTTL {
+		return cached, nil
+	}
+
+	var config oidcConfig
+	err := getJSON(strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &config)
+	if err != nil {
+		return nil, err
+	}
+	if config.Issuer != issuer {
+		return nil, fmt.Errorf("discovery issuer %s does not match %s", config.Issuer, issuer)
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	err = getJSON(config.JWKSURI, &jwks)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]interface{}{}
+	for _, k := range jwks.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			// providers may publish key types we don't support, skip them
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	cached = &oidcIssuer{config: config, keys: keys, fetchedAt: time.Now()}
+	oidcIssuers[issuer] = cached
+	return cached, nil
+}
+
+// decodeBigInt decodes a base64url encoded big endian integer
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// publicKey converts a JWK to an RSA or ECDSA public key
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+// oidcIdentity is what we keep from a verified ID token
+type oidcIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// verifyIDToken checks ID token signature, issuer, audience, expiry and nonce
+func verifyIDToken(issuer string, clientID string, nonce string, idToken string) (oidcIdentity, error) {
+	var identity oidcIdentity
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		// only asymmetric algorithms, so a public key can never be used as an HMAC secret
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		default:
+			return nil, fmt.Errorf("unexpected signing method %s", token.Header["alg"])
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		cached, err := discoverIssuer(issuer, false)
+		if err != nil {
+			return nil, err
+		}
+		if key, ok := cached.keys[kid]; ok {
+			return key, nil
+		}
+
+		// the provider may have rotated its keys since we cached them
+		cached, err = discoverIssuer(issuer, true)
+		if err != nil {
+			return nil, err
+		}
+		if key, ok := cached.keys[kid]; ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("unknown signing key %s", kid)
+	})
+	if err != nil {
+		return identity, err
+	}
+
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return identity, fmt.Errorf("token has no expiry")
+	}
+	if iss, _ := claims["iss"].(string); iss != issuer {
+		return identity, fmt.Errorf("unexpected issuer %s", iss)
+	}
+	if !audienceContains(claims["aud"], clientID) {
+		return identity, fmt.Errorf("token was not issued for this client")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return identity, fmt.Errorf("nonce mismatch")
+	}
+
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = v
+	case string:
+		identity.EmailVerified = v == "true"
+	}
+
+	if identity.Subject == "" {
+		return identity, fmt.Errorf("token has no subject")
+	}
+	return identity, nil
+}
+
+// audienceContains checks the aud claim, which can be a string or a list
+func audienceContains(aud interface{}, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/reynld/shinpo/server/mail"
+	"github.com/reynld/shinpo/server/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordResetTTL is how long a password reset token can be used for
+const passwordResetTTL = time.Hour
+
+// passwordResetKind is the one time token kind of password resets
+const passwordResetKind = "password_reset"
+
+// ForgotPassword emails a single use password reset token to the user
+func ForgotPassword(db *sql.DB, c *redis.Client, m mail.Mailer, w http.ResponseWriter, r *http.Request) {
+	var payload models.PasswordForgotRequest
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Unknown emails get the same response so the endpoint can't be used
+	// to find out who has an account
+	user, err := models.GetByEmail(db, payload.Email)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	err = models.SaveOneTimeToken(c, passwordResetKind, hashToken(token), user.ID, passwordResetTTL)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	body := fmt.Sprintf(
+		"Hi %s,\n\nUse the following token to reset your password, it expires in %s:\n\n%s\n",
+		user.Username,
+		passwordResetTTL,
+		token,
+	)
+	if url := os.Getenv("CLIENT_URL"); url != "" {
+		body += fmt.Sprintf("\nOr follow this link: %s/reset-password?token=%s\n", url, token)
+	}
+
+	// a failed send is only logged, answering differently would tell the
+	// email has an account
+	if err := m.Send(user.Email, "Reset your Shinpo password", body); err != nil {
+		log.Print(err)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ResetPassword sets a new password using a password reset token
+func ResetPassword(db *sql.DB, c *redis.Client, w http.ResponseWriter, r *http.Request) {
+	var payload models.PasswordResetRequest
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if payload.Token == "" || payload.Password == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("token and password are required"))
+		return
+	}
+
+	userID, err := models.ConsumeOneTimeToken(c, passwordResetKind, hashToken(payload.Token))
+	if err == redis.Nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("invalid or expired reset token"))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(payload.Password), 10)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	err = models.UpdatePassword(db, userID, string(hash))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Whoever knew the old password should not stay logged in
+	if err := models.DeleteUserSessions(c, userID); err != nil {
+		log.Print(err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/reynld/shinpo/server/models"
+)
+
+// refreshTokenTTL is how long a refresh token can be exchanged for a new one
+const refreshTokenTTL = 30 * 24 * time.Hour
+
+// randomToken returns a url safe random string made of n bytes
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken hashes opaque tokens so they are never stored in plain text
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueRefreshToken creates and stores a new refresh token for session
+func issueRefreshToken(c *redis.Client, userID int, session string) (string, time.Time, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	err = models.SaveRefreshToken(c, hashToken(token), models.RefreshToken{
+		UserID: userID,
+		Family: session,
+	}, refreshTokenTTL)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, time.Now().Add(refreshTokenTTL), nil
+}
+
+// startSession registers a new session for the device making the request
+func startSession(c *redis.Client, r *http.Request, userID int) (string, error) {
+	id, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	err = models.CreateSession(c, models.Session{
+		ID:        id,
+		UserID:    userID,
+		UserAgent: r.UserAgent(),
+		CreatedAt: now,
+		LastSeen:  now,
+	}, refreshTokenTTL)
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// issueTokens creates a new access token and refresh token for user,
+// an empty session starts a new one
+func issueTokens(c *redis.Client, r *http.Request, user models.UserResponse, session string) (map[string]string, error) {
+	var err error
+	if session == "" {
+		session, err = startSession(c, r, user.ID)
+	} else {
+		err = models.ExtendSession(c, session, refreshTokenTTL)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	jwtToken, err := GenerateToken(&user, session)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, refreshExpires, err := issueRefreshToken(c, user.ID, session)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"token":           jwtToken.Token,
+		"expires":         jwtToken.Time.String(),
+		"refresh_token":   refreshToken,
+		"refresh_expires": refreshExpires.String(),
+	}, nil
+}
+
+// writeTokens responds with a new access token and refresh token for user,
+// an empty session starts a new one
+func writeTokens(c *redis.Client, w http.ResponseWriter, r *http.Request, user models.UserResponse, session string) {
+	tokens, err := issueTokens(c, r, user, session)
+	if err != nil {
+		// If there is an error in creating the tokens return an internal server error
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// Refresh rotates the refresh token and issues a new access token
+func Refresh(db *sql.DB, c *redis.Client, w http.ResponseWriter, r *http.Request) {
+	var payload models.RefreshRequest
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if payload.RefreshToken == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("refresh_token is required"))
+		return
+	}
+
+	hash := hashToken(payload.RefreshToken)
+	token, err := models.GetRefreshToken(c, hash)
+	if err == redis.Nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("invalid refresh token"))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	fresh, err := models.MarkRefreshTokenUsed(c, hash)
+	if err == redis.Nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("invalid refresh token"))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	session, err := models.GetSession(c, token.Family)
+	if err == redis.Nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("session revoked"))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// An already rotated token was replayed, so either the client or an attacker
+	// holds a stolen copy. Revoke the whole session to log both of them out.
+	if !fresh {
+		if err := models.DeleteSession(c, session); err != nil {
+			log.Print(err)
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("refresh token reuse detected"))
+		return
+	}
+
+	user, err := models.GetUserByID(db, token.UserID)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	writeTokens(c, w, r, user, session.ID)
+}
@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/reynld/shinpo/server/models"
+)
+
+// roleRanks orders roles, a role is granted everything lower ranked roles are
+var roleRanks = map[string]int{
+	models.RoleUser:  1,
+	models.RoleCoach: 2,
+	models.RoleAdmin: 3,
+}
+
+// hasRole checks if role is granted required
+func hasRole(role string, required string) bool {
+	return roleRanks[role] >= roleRanks[required]
+}
+
+// RequireRole middleware only lets users with at least role through,
+// it must be wrapped by Protected
+func RequireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current, _ := r.Context().Value("Role").(string)
+		if !hasRole(current, role) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("insufficient role"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -5,13 +5,21 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	netmail "net/mail"
+	"strings"
 
+	"github.com/go-redis/redis"
+	"github.com/reynld/shinpo/server/mail"
 	"github.com/reynld/shinpo/server/models"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// dummyPasswordHash is a bcrypt hash of a random password with the cost of
+// stored ones, compared against when logging in with an unknown username
+var dummyPasswordHash = []byte("$2a$10$z4BgMWGGA7pUcPBFF/G5EO6J78KdQKSXCvRc25WcFZU4.aoh2DmIy")
+
 // Login the login handler
-func Login(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+func Login(db *sql.DB, c *redis.Client, m mail.Mailer, w http.ResponseWriter, r *http.Request) {
 	var creds models.Credentials
 	// Get the JSON body and decode into credentials
 	err := json.NewDecoder(r.Body).Decode(&creds)
@@ -22,16 +30,29 @@ func Login(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := models.GetByUsername(db, creds.Username)
+	wait, err := loginLockedFor(c, userSubject(creds.Username), ipSubject(clientIP(r)))
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
 		return
 	}
+	if wait > 0 {
+		writeLocked(w, wait)
+		return
+	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(creds.Password))
-	if err != nil {
+	user, err := models.GetByUsername(db, creds.Username)
+	if err == sql.ErrNoRows {
+		// Unknown usernames still pay for a bcrypt comparison so response
+		// times don't tell which usernames exist
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(creds.Password))
+		registerLoginFailure(db, c, m, r, creds.Username, nil)
 		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("invalid username or password"))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
 		return
 	}
@@ -40,28 +61,40 @@ func Login(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		ID:       user.ID,
 		Username: user.Username,
 		Email:    user.Email,
+		Verified: user.Verified,
+		Role:     user.Role,
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(creds.Password))
+	if err != nil {
+		registerLoginFailure(db, c, m, r, user.Username, &userRes)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("invalid username or password"))
+		return
 	}
 
-	jwtToken, err := GenerateToken(&userRes)
+	totp, err := models.GetTOTP(db, user.ID)
 	if err != nil {
-		// If there is an error in creating the JWT return an internal server error
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
 		return
 	}
 
-	// Finally, we set the client cookie for "token" as the JWT we just generated
-	// we also set an expiry time which is the same as the token itself
-	// http.SetCookie(w, &http.Cookie{
-	// 	Name:    "token",
-	// 	Value:   jwtToken.Token,
-	// 	Expires: jwtToken.Time,
-	// })
-	json.NewEncoder(w).Encode(map[string]string{"token": jwtToken.Token, "expires": jwtToken.Time.String()})
+	// Two factor accounts only get a challenge here, tokens are issued by LoginTwoFactor
+	if totp.Enabled {
+		writeChallenge(w, user.ID)
+		return
+	}
+
+	if err := models.ResetLoginFailures(c, userSubject(user.Username)); err != nil {
+		log.Print(err)
+	}
+
+	writeTokens(c, w, r, userRes, "")
 }
 
 // Register the Signin handler
-func Register(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+func Register(db *sql.DB, c *redis.Client, m mail.Mailer, w http.ResponseWriter, r *http.Request) {
 	var creds models.Credentials
 	// Get the JSON body and decode into credentials
 	err := json.NewDecoder(r.Body).Decode(&creds)
@@ -72,6 +105,13 @@ func Register(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	creds.Email = strings.TrimSpace(creds.Email)
+	if addr, err := netmail.ParseAddress(creds.Email); err != nil || addr.Address != creds.Email || len(creds.Email) > 40 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid email address"))
+		return
+	}
+
 	hash, err := bcrypt.GenerateFromPassword([]byte(creds.Password), 10)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -86,20 +126,10 @@ func Register(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	jwtToken, err := GenerateToken(&user)
-	if err != nil {
-		// If there is an error in creating the JWT return an internal server error
-		w.WriteHeader(http.StatusInternalServerError)
+	// The account is usable right away, a failed email can be resent later
+	if err := sendVerificationEmail(m, r, user); err != nil {
 		log.Print(err)
-		return
 	}
 
-	// Finally, we set the client cookie for "token" as the JWT we just generated
-	// we also set an expiry time which is the same as the token itself
-	// http.SetCookie(w, &http.Cookie{
-	// 	Name:    "token",
-	// 	Value:   jwtToken.Token,
-	// 	Expires: jwtToken.Time,
-	// })
-	json.NewEncoder(w).Encode(map[string]string{"token": jwtToken.Token, "expires": jwtToken.Time.String()})
+	writeTokens(c, w, r, user, "")
 }
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-redis/redis"
+	"github.com/gorilla/mux"
+	"github.com/reynld/shinpo/server/models"
+)
+
+// Logout revokes the session of the token making the request
+func Logout(c *redis.Client, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+	sessionID := r.Context().Value("Session").(string)
+
+	err := models.DeleteSession(c, models.Session{ID: sessionID, UserID: userID})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetSessions lists every device the user is logged in from
+func GetSessions(c *redis.Client, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+	sessionID := r.Context().Value("Session").(string)
+
+	sessions, err := models.GetUserSessions(c, userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == sessionID
+	}
+
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// DeleteSession revokes one of the user sessions
+func DeleteSession(c *redis.Client, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+
+	params := mux.Vars(r)
+	session, err := models.GetSession(c, params["id"])
+	if err == redis.Nil || (err == nil && session.UserID != userID) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("session not found"))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	err = models.DeleteSession(c, session)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,331 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-redis/redis"
+	"github.com/reynld/shinpo/server/mail"
+	"github.com/reynld/shinpo/server/models"
+)
+
+// totpPeriod is the time step of RFC 6238 codes
+const totpPeriod = 30 * time.Second
+
+// totpSkew is how many time steps before and after now a code is accepted for
+const totpSkew = 1
+
+// totpIssuer is the account issuer shown by authenticator apps
+const totpIssuer = "Shinpo"
+
+// challengeTTL is how long the password step of a two factor login is valid for
+const challengeTTL = 5 * time.Minute
+
+// challengeAudience is the audience of two factor challenge tokens
+const challengeAudience = "2fa-challenge"
+
+// recoveryCodeCount is how many recovery codes are issued when enabling two factor
+const recoveryCodeCount = 10
+
+// totpEncoding is the base32 encoding authenticator apps expect secrets in
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateTOTPSecret returns a new random base32 secret
+func generateTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// totpCode computes the RFC 6238 code of secret for counter
+func totpCode(secret []byte, counter uint64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+// validateTOTP checks code against secret around t, returns the matching counter
+func validateTOTP(secret string, code string, t time.Time) (uint64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != 6 {
+		return 0, false
+	}
+
+	current := uint64(t.Unix()) / uint64(totpPeriod.Seconds())
+	for i := -totpSkew; i <= totpSkew; i++ {
+		counter := current + uint64(i)
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, counter)), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+// checkTOTP validates code and makes sure it was not used already
+func checkTOTP(c *redis.Client, userID int, secret string, code string) (bool, error) {
+	counter, ok := validateTOTP(secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	// a code stays valid for a few steps, remember it so it can't be replayed
+	key := fmt.Sprintf("totp_used:%d:%d", userID, counter)
+	return c.SetNX(key, 1, (2*totpSkew+1)*totpPeriod).Result()
+}
+
+// normalizeRecoveryCode strips formatting users may type along recovery codes
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+}
+
+// generateRecoveryCodes returns new recovery codes and their hashes
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := []string{}
+	hashes := []string{}
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := hex.EncodeToString(b)
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashToken(code))
+	}
+	return codes, hashes, nil
+}
+
+// challengeResponse creates a challenge token to exchange along a TOTP code
+func challengeResponse(userID int) (map[string]string, error) {
+	expirationTime := time.Now().Add(challengeTTL)
+	claims := &models.ChallengeClaims{
+		ID: userID,
+		StandardClaims: jwt.StandardClaims{
+			Audience:  challengeAudience,
+			ExpiresAt: expirationTime.Unix(),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(getJWTKey())
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"two_factor":      "required",
+		"challenge_token": token,
+		"expires":         expirationTime.String(),
+	}, nil
+}
+
+// writeChallenge responds with a challenge token to exchange along a TOTP code
+func writeChallenge(w http.ResponseWriter, userID int) {
+	challenge, err := challengeResponse(userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	json.NewEncoder(w).Encode(challenge)
+}
+
+// EnrollTOTP creates a pending TOTP secret and returns its otpauth:// URI
+func EnrollTOTP(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+	username := r.Context().Value("Username").(string)
+
+	totp, err := models.GetTOTP(db, userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if totp.Enabled {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("two factor is already enabled"))
+		return
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	err = models.SetTOTPSecret(db, userID, secret)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", totpIssuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", "6")
+	params.Set("period", strconv.Itoa(int(totpPeriod.Seconds())))
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + totpIssuer + ":" + username,
+		RawQuery: params.Encode(),
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"secret": secret, "uri": uri.String()})
+}
+
+// ConfirmTOTP enables two factor once the user proves the secret was saved,
+// responds with one time recovery codes
+func ConfirmTOTP(db *sql.DB, c *redis.Client, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+
+	var payload models.TOTPCodeRequest
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	totp, err := models.GetTOTP(db, userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if totp.Enabled || totp.Secret == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("no pending two factor enrollment"))
+		return
+	}
+
+	ok, err := checkTOTP(c, userID, totp.Secret, payload.Code)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("invalid code"))
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	err = models.EnableTOTP(db, userID, hashes)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes})
+}
+
+// LoginTwoFactor exchanges a challenge token and a TOTP or recovery code for tokens
+func LoginTwoFactor(db *sql.DB, c *redis.Client, m mail.Mailer, w http.ResponseWriter, r *http.Request) {
+	var payload models.TwoFactorLoginRequest
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	claims := &models.ChallengeClaims{}
+	tkn, err := jwt.ParseWithClaims(payload.ChallengeToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return getJWTKey(), nil
+	})
+	if err != nil || tkn == nil || !tkn.Valid || !claims.VerifyAudience(challengeAudience, true) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("invalid or expired challenge token"))
+		return
+	}
+
+	user, err := models.GetUserByID(db, claims.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// a stolen password should not allow guessing codes forever either
+	wait, err := loginLockedFor(c, userSubject(user.Username), ipSubject(clientIP(r)))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if wait > 0 {
+		writeLocked(w, wait)
+		return
+	}
+
+	totp, err := models.GetTOTP(db, claims.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	var ok bool
+	if payload.Code != "" {
+		ok, err = checkTOTP(c, claims.ID, totp.Secret, payload.Code)
+	} else if payload.RecoveryCode != "" {
+		ok, err = models.UseRecoveryCode(db, claims.ID, hashToken(normalizeRecoveryCode(payload.RecoveryCode)))
+	} else {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("code or recovery_code is required"))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if !ok {
+		registerLoginFailure(db, c, m, r, user.Username, &user)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("invalid code"))
+		return
+	}
+
+	if err := models.ResetLoginFailures(c, userSubject(user.Username)); err != nil {
+		log.Print(err)
+	}
+
+	writeTokens(c, w, r, user, "")
+}
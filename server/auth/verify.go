@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-redis/redis"
+	"github.com/reynld/shinpo/server/mail"
+	"github.com/reynld/shinpo/server/models"
+)
+
+// verifyEmailTTL is how long an email verification link is valid for
+const verifyEmailTTL = 24 * time.Hour
+
+// verifyResendInterval is how often a user can ask for a new verification link
+const verifyResendInterval = time.Minute
+
+// verifyEmailAudience is the audience of verification link tokens, so they can't
+// be mistaken with access tokens signed with the same key
+const verifyEmailAudience = "verify-email"
+
+// requireVerifiedEmail reports if unverified accounts are blocked from write endpoints
+func requireVerifiedEmail() bool {
+	return os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true"
+}
+
+// Verified middleware blocks unverified accounts when REQUIRE_VERIFIED_EMAIL is true,
+// it must be wrapped by Protected
+func Verified(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requireVerifiedEmail() && !r.Context().Value("Verified").(bool) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("email address is not verified"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiURL returns the public URL of the API, used to build links back to it
+func apiURL(r *http.Request) string {
+	if u := os.Getenv("API_URL"); u != "" {
+		return u
+	}
+
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s", scheme, r.Host)
+}
+
+// sendVerificationEmail emails a signed verification link to user
+func sendVerificationEmail(m mail.Mailer, r *http.Request, user models.UserResponse) error {
+	claims := &models.VerifyClaims{
+		ID:    user.ID,
+		Email: user.Email,
+		StandardClaims: jwt.StandardClaims{
+			Audience:  verifyEmailAudience,
+			ExpiresAt: time.Now().Add(verifyEmailTTL).Unix(),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(getJWTKey())
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/verify-email?token=%s", apiURL(r), url.QueryEscape(token))
+	body := fmt.Sprintf(
+		"Hi %s,\n\nConfirm your email address by following this link, it expires in %s:\n\n%s\n",
+		user.Username,
+		verifyEmailTTL,
+		link,
+	)
+
+	return m.Send(user.Email, "Verify your Shinpo email", body)
+}
+
+// VerifyEmail marks the email of the verification link as verified
+func VerifyEmail(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	tknStr := r.URL.Query().Get("token")
+	if tknStr == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("token is required"))
+		return
+	}
+
+	claims := &models.VerifyClaims{}
+	tkn, err := jwt.ParseWithClaims(tknStr, claims, func(token *jwt.Token) (interface{}, error) {
+		return getJWTKey(), nil
+	})
+	if err != nil || tkn == nil || !tkn.Valid || !claims.VerifyAudience(verifyEmailAudience, true) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("invalid or expired verification link"))
+		return
+	}
+
+	err = models.VerifyUser(db, claims.ID, claims.Email)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("invalid or expired verification link"))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Write([]byte("email verified"))
+}
+
+// ResendVerification emails a new verification link, at most once per verifyResendInterval
+func ResendVerification(db *sql.DB, c *redis.Client, m mail.Mailer, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+
+	user, err := models.GetUserByID(db, userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if user.Verified {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("email already verified"))
+		return
+	}
+
+	key := "verify_resend:" + strconv.Itoa(user.ID)
+	ok, err := c.SetNX(key, 1, verifyResendInterval).Result()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if !ok {
+		retry, _ := c.TTL(key).Result()
+		if retry < time.Second {
+			retry = time.Second
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())))
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("verification email sent recently, try again later"))
+		return
+	}
+
+	err = sendVerificationEmail(m, r, user)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,115 @@
+package exercise
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/reynld/shinpo/server/models"
+)
+
+// analyticsBuckets is how many periods the volume covers without from
+const analyticsBuckets = 12
+
+// dateLayout is the layout of dates in query parameters
+const dateLayout = "2006-01-02"
+
+// volumeRange returns the from and to dates of a volume report, to defaults to
+// today in tz and from to the start of the 12th period before it
+func volumeRange(r *http.Request, period string, tz *time.Location) (string, string, error) {
+	query := r.URL.Query()
+
+	today := time.Now().In(tz)
+	to := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+	if param := query.Get("to"); param != "" {
+		t, err := time.Parse(dateLayout, param)
+		if err != nil {
+			return "", "", fmt.Errorf("to must be a YYYY-MM-DD date")
+		}
+		to = t
+	}
+
+	// the first period is a whole one, weeks start on monday
+	from := to.AddDate(0, 0, -7*(analyticsBuckets-1)-(int(to.Weekday())+6)%7)
+	if period == models.PeriodMonth {
+		from = time.Date(to.Year(), to.Month()-(analyticsBuckets-1), 1, 0, 0, 0, 0, time.UTC)
+	}
+	if param := query.Get("from"); param != "" {
+		t, err := time.Parse(dateLayout, param)
+		if err != nil {
+			return "", "", fmt.Errorf("from must be a YYYY-MM-DD date")
+		}
+		from = t
+	}
+
+	if from.Format(dateLayout) > to.Format(dateLayout) {
+		return "", "", fmt.Errorf("from can't be after to")
+	}
+	return from.Format(dateLayout), to.Format(dateLayout), nil
+}
+
+// GetVolume the training volume analytics handler, period is week or month and
+// group exercise or category. Sets are bucketed by the day they were logged on,
+// which is already the local day of the user, tz only picks what today is
+func GetVolume(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+	query := r.URL.Query()
+
+	report := models.VolumeReport{
+		Period:   query.Get("period"),
+		Group:    query.Get("group"),
+		Timezone: query.Get("tz"),
+	}
+	if report.Period == "" {
+		report.Period = models.PeriodWeek
+	}
+	if report.Group == "" {
+		report.Group = models.GroupExercise
+	}
+	if report.Timezone == "" {
+		report.Timezone = "UTC"
+	}
+
+	tz, err := time.LoadLocation(report.Timezone)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("unknown time zone " + report.Timezone))
+		return
+	}
+	if !models.Periods[report.Period] {
+		err = fmt.Errorf("unknown period %s", report.Period)
+	} else if !models.Groups[report.Group] {
+		err = fmt.Errorf("unknown group %s", report.Group)
+	}
+	if err == nil {
+		report.From, report.To, err = volumeRange(r, report.Period, tz)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	report.WeightUnit, err = weightUnit(db, r)
+	if err == errUnknownUnit {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	report, err = models.GetVolume(db, userID, report)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	json.NewEncoder(w).Encode(report)
+}
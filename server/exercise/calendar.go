@@ -0,0 +1,94 @@
+package exercise
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/reynld/shinpo/server/models"
+)
+
+// Calendar ranges in days, around today without from and to
+const (
+	calendarDefaultDays = 28
+	calendarMaxDays     = 366
+)
+
+// calendarRange returns the from and to dates of a calendar, from defaults to
+// 4 weeks before today in tz and to 4 weeks after
+func calendarRange(r *http.Request, tz *time.Location) (string, string, error) {
+	today := time.Now().In(tz)
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+	from, to := today.AddDate(0, 0, -calendarDefaultDays), today.AddDate(0, 0, calendarDefaultDays)
+
+	query := r.URL.Query()
+	if param := query.Get("from"); param != "" {
+		t, err := time.Parse(dateLayout, param)
+		if err != nil {
+			return "", "", fmt.Errorf("from must be a YYYY-MM-DD date")
+		}
+		from = t
+	}
+	if param := query.Get("to"); param != "" {
+		t, err := time.Parse(dateLayout, param)
+		if err != nil {
+			return "", "", fmt.Errorf("to must be a YYYY-MM-DD date")
+		}
+		to = t
+	}
+
+	if from.After(to) {
+		return "", "", fmt.Errorf("from can't be after to")
+	}
+	if to.Sub(from) >= calendarMaxDays*24*time.Hour {
+		return "", "", fmt.Errorf("a calendar can't span more than %d days", calendarMaxDays)
+	}
+	return from.Format(dateLayout), to.Format(dateLayout), nil
+}
+
+// GetCalendar the training calendar handler, the days with logged sets or
+// workouts scheduled by programs from and to optional dates
+func GetCalendar(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+
+	timezone := r.URL.Query().Get("tz")
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	tz, err := time.LoadLocation(timezone)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("unknown time zone " + timezone))
+		return
+	}
+
+	var cal models.Calendar
+	cal.From, cal.To, err = calendarRange(r, tz)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	cal.WeightUnit, err = weightUnit(db, r)
+	if err == errUnknownUnit {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	cal, err = models.GetCalendar(db, userID, cal)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	json.NewEncoder(w).Encode(cal)
+}
@@ -10,15 +10,29 @@ import (
 	"github.com/reynld/shinpo/server/models"
 )
 
-// GetAllCategories the user Categories handler
+// GetAllCategories the user Categories handler, sorted by name or id and
+// filtered by part of their name
 func GetAllCategories(db *sql.DB, w http.ResponseWriter, r *http.Request) {
-	categories, err := models.GetAllCategories(db)
+	opts, err := listOptions(r, "name")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	filter := models.CategoryFilter{Name: r.URL.Query().Get("name")}
+	categories, next, err := models.GetAllCategories(db, filter, opts)
+	if _, ok := err.(models.ListError); ok {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
 		return
 	}
-	json.NewEncoder(w).Encode(categories)
+	json.NewEncoder(w).Encode(models.Page{Items: categories, NextCursor: next})
 }
 
 // AddCategory the add new user record handler
@@ -80,7 +94,7 @@ func EditCategory(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(category)
 }
 
-// DeleteCategory the delete record handler
+// DeleteCategory the delete category handler, 409 while the category is in use
 func DeleteCategory(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	idParam := params["id"]
@@ -92,6 +106,11 @@ func DeleteCategory(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 	}
 
 	count, err := models.DeleteCategory(db, id)
+	if err == models.ErrCategoryInUse {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(err.Error()))
+		return
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
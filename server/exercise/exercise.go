@@ -10,15 +10,37 @@ import (
 	"github.com/reynld/shinpo/server/models"
 )
 
-// GetAllExercises the user Exercises handler
+// GetAllExercises the user Exercises handler, sorted by name or id and
+// filtered by category, measurement and part of their name
 func GetAllExercises(db *sql.DB, w http.ResponseWriter, r *http.Request) {
-	exercises, err := models.GetAllExercises(db)
+	filter := models.ExerciseFilter{
+		Measurement: r.URL.Query().Get("measurement"),
+		Name:        r.URL.Query().Get("name"),
+	}
+	categoryID, err := intParam(r, "category_id")
+	var opts models.ListOptions
+	if err == nil {
+		opts, err = listOptions(r, "name")
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	filter.CategoryID = categoryID
+
+	exercises, next, err := models.GetAllExercises(db, filter, opts)
+	if _, ok := err.(models.ListError); ok {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
 		return
 	}
-	json.NewEncoder(w).Encode(exercises)
+	json.NewEncoder(w).Encode(models.Page{Items: exercises, NextCursor: next})
 }
 
 // AddExercise the add new user record handler
@@ -31,7 +53,16 @@ func AddExercise(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	exercise, err := models.CreateExercise(db, payload.Name, payload.CategoryID)
+	if payload.Measurement == "" {
+		payload.Measurement = models.MeasureWeightReps
+	}
+	if !models.Measurements[payload.Measurement] {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("unknown measurement " + payload.Measurement))
+		return
+	}
+
+	exercise, err := models.CreateExercise(db, payload.Name, payload.CategoryID, payload.Measurement)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
@@ -76,6 +107,12 @@ func EditExercise(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if payload.Measurement != "" && !models.Measurements[payload.Measurement] {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("unknown measurement " + payload.Measurement))
+		return
+	}
+
 	exercise, err := models.EditExercise(db, payload)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -86,7 +123,7 @@ func EditExercise(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(exercise)
 }
 
-// DeleteExercise the delete record handler
+// DeleteExercise the delete exercise handler, 409 while the exercise is in use
 func DeleteExercise(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	idParam := params["id"]
@@ -98,6 +135,11 @@ func DeleteExercise(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 	}
 
 	count, err := models.DeleteExercise(db, id)
+	if err == models.ErrExerciseInUse {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(err.Error()))
+		return
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
@@ -0,0 +1,136 @@
+package exercise
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/reynld/shinpo/server/models"
+)
+
+// exportFlushEvery is how many records are written between flushes to the client
+const exportFlushEvery = 500
+
+// exportContentTypes are the content types of each export format
+var exportContentTypes = map[string]string{
+	"csv":    "text/csv",
+	"json":   "application/json",
+	"ndjson": "application/x-ndjson",
+}
+
+// ExportRecords the records export handler, streams the records of the user as
+// csv, a json array or ndjson in their preferred weight unit, filtered by
+// exercise, category and date
+func ExportRecords(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("format must be csv, json or ndjson"))
+		return
+	}
+
+	unit, err := weightUnit(db, r)
+	filter := models.RecordFilter{WeightUnit: unit}
+	if err == nil {
+		filter.ExerciseID, err = intParam(r, "exercise_id")
+	}
+	if err == nil {
+		filter.CategoryID, err = intParam(r, "category_id")
+	}
+	if err == nil {
+		filter.From, err = dateParam(r, "from")
+	}
+	if err == nil {
+		filter.To, err = dateParam(r, "to")
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	csvWriter := csv.NewWriter(w)
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	started, written := false, 0
+
+	// the response starts with the first record, so errors before it can
+	// still be reported with a status
+	start := func() error {
+		started = true
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", `attachment; filename="records.`+format+`"`)
+		switch format {
+		case "csv":
+			return csvWriter.Write(models.ExportRecordColumns)
+		case "json":
+			_, err := w.Write([]byte("["))
+			return err
+		}
+		return nil
+	}
+
+	err = models.StreamExportRecords(db, userID, filter, unit, func(record models.ExportRecord) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		switch format {
+		case "csv":
+			err = csvWriter.Write(record.CSVRow())
+		case "json":
+			if written > 0 {
+				if _, err := w.Write([]byte(",")); err != nil {
+					return err
+				}
+			}
+			err = encoder.Encode(record)
+		case "ndjson":
+			err = encoder.Encode(record)
+		}
+		if err != nil {
+			return err
+		}
+
+		written++
+		if written%exportFlushEvery == 0 {
+			csvWriter.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return nil
+	})
+	if err != nil && !started {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		// the status was already sent, the client gets a truncated file
+		log.Print(err)
+		return
+	}
+
+	if !started {
+		if err := start(); err != nil {
+			log.Print(err)
+			return
+		}
+	}
+	if format == "json" {
+		w.Write([]byte("]"))
+	}
+	csvWriter.Flush()
+}
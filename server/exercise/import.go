@@ -0,0 +1,560 @@
+package exercise
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/reynld/shinpo/server/models"
+)
+
+// maxImportSize is the largest file that can be imported
+const maxImportSize = 10 << 20
+
+// Import file formats
+const (
+	formatStrong   = "strong"
+	formatHevy     = "hevy"
+	formatFitNotes = "fitnotes"
+	formatGeneric  = "generic"
+)
+
+// importDateLayouts are the date layouts import files write dates with
+var importDateLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	time.RFC3339,
+	"2006-01-02",
+	"2 Jan 2006, 15:04",
+	"Jan 2, 2006, 15:04",
+	"2006/01/02",
+}
+
+// importSetTypes maps the set types of import files to ours
+var importSetTypes = map[string]string{
+	"":        models.SetWorking,
+	"normal":  models.SetWorking,
+	"working": models.SetWorking,
+	"w":       models.SetWarmUp,
+	"warmup":  models.SetWarmUp,
+	"warm-up": models.SetWarmUp,
+	"d":       models.SetDrop,
+	"dropset": models.SetDrop,
+	"drop":    models.SetDrop,
+	"f":       models.SetFailure,
+	"failure": models.SetFailure,
+}
+
+// metersIn are the meters in each distance unit, in thousandths
+var metersIn = map[string]int64{
+	"m":     1000,
+	"km":    1000000,
+	"mi":    1609344,
+	"miles": 1609344,
+}
+
+// importError is a line of an import file that can't be imported
+type importError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// importMatch is an exercise name of an import file and the exercise it matched
+type importMatch struct {
+	Name         string `json:"name"`
+	ExerciseID   int    `json:"exercise_id"`
+	ExerciseName string `json:"exercise_name"`
+}
+
+// importUnmatched is an exercise name of an import file no exercise matched
+type importUnmatched struct {
+	Name string `json:"name"`
+	Sets int    `json:"sets"`
+}
+
+// importPreview is what an import file holds and what importing it does
+type importPreview struct {
+	Format     string            `json:"format"`
+	DryRun     bool              `json:"dry_run"`
+	Sets       int               `json:"sets"`
+	Matched    []importMatch     `json:"matched"`
+	Unmatched  []importUnmatched `json:"unmatched"`
+	Errors     []importError     `json:"errors"`
+	Duplicates []int             `json:"duplicates"`
+	Importable int               `json:"importable"`
+	Imported   int               `json:"imported"`
+	Workouts   int               `json:"workouts"`
+}
+
+// importRow is a line of an import file with its columns by lowercased header
+type importRow struct {
+	line    int
+	columns map[string]string
+}
+
+// get returns the value of the first of columns the row has
+func (r importRow) get(columns ...string) string {
+	for _, c := range columns {
+		if v, ok := r.columns[strings.ToLower(c)]; ok {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// has tells if the row has column
+func (r importRow) has(column string) bool {
+	_, ok := r.columns[column]
+	return ok
+}
+
+// setFields are the raw values of a set in an import file, distance in
+// distanceUnit and duration in seconds or h:mm:ss
+type setFields struct {
+	date         string
+	session      string
+	exercise     string
+	weight       string
+	weightUnit   string
+	reps         string
+	rpe          string
+	setType      string
+	duration     string
+	distance     string
+	distanceUnit string
+}
+
+// readImportRows reads a CSV file separated by commas or semicolons
+func readImportRows(file io.Reader) ([]importRow, error) {
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	content = []byte(strings.TrimPrefix(string(content), "\ufeff"))
+
+	reader := csv.NewReader(strings.NewReader(string(content)))
+	firstLine := strings.SplitN(string(content), "\n", 2)[0]
+	if strings.Count(firstLine, ";") > strings.Count(firstLine, ",") {
+		reader.Comma = ';'
+	}
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("the file is empty")
+	}
+
+	header := records[0]
+	rows := []importRow{}
+	for i, record := range records[1:] {
+		row := importRow{line: i + 2, columns: map[string]string{}}
+		for j, name := range header {
+			if j < len(record) {
+				row.columns[strings.ToLower(strings.TrimSpace(name))] = record[j]
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// detectImportFormat guesses the app a file was exported from by its columns
+func detectImportFormat(row importRow) string {
+	switch {
+	case row.has("exercise_title"):
+		return formatHevy
+	case row.has("exercise name") && row.has("set order"):
+		return formatStrong
+	case row.has("exercise") && row.has("category"):
+		return formatFitNotes
+	}
+	return ""
+}
+
+// strongFields reads a set of a Strong export, its weights are in unit and
+// distances in kilometers
+func strongFields(row importRow, unit string) setFields {
+	return setFields{
+		date:         row.get("date"),
+		session:      row.get("date") + row.get("workout name"),
+		exercise:     row.get("exercise name"),
+		weight:       row.get("weight"),
+		weightUnit:   unit,
+		reps:         row.get("reps"),
+		rpe:          row.get("rpe"),
+		setType:      strongSetType(row.get("set order")),
+		duration:     row.get("seconds"),
+		distance:     row.get("distance"),
+		distanceUnit: "km",
+	}
+}
+
+// strongSetType returns the set type of a Strong set order, which is a number
+// for working sets
+func strongSetType(order string) string {
+	if _, err := strconv.Atoi(order); err == nil {
+		return ""
+	}
+	return order
+}
+
+// hevyFields reads a set of a Hevy export
+func hevyFields(row importRow) setFields {
+	f := setFields{
+		date:         row.get("start_time"),
+		session:      row.get("start_time") + row.get("title"),
+		exercise:     row.get("exercise_title"),
+		weight:       row.get("weight_kg"),
+		weightUnit:   models.UnitKg,
+		reps:         row.get("reps"),
+		rpe:          row.get("rpe"),
+		setType:      row.get("set_type"),
+		duration:     row.get("duration_seconds"),
+		distance:     row.get("distance_km"),
+		distanceUnit: "km",
+	}
+	if row.has("weight_lbs") {
+		f.weight, f.weightUnit = row.get("weight_lbs"), models.UnitLb
+	}
+	if row.has("distance_miles") {
+		f.distance, f.distanceUnit = row.get("distance_miles"), "mi"
+	}
+	return f
+}
+
+// fitNotesFields reads a set of a FitNotes export, its weight column names the unit
+func fitNotesFields(row importRow) setFields {
+	f := setFields{
+		date:         row.get("date"),
+		session:      row.get("date"),
+		exercise:     row.get("exercise"),
+		reps:         row.get("reps"),
+		duration:     row.get("time"),
+		distance:     row.get("distance"),
+		distanceUnit: strings.ToLower(row.get("distance unit")),
+	}
+	for column := range row.columns {
+		if strings.HasPrefix(column, "weight") {
+			f.weight, f.weightUnit = row.get(column), models.UnitKg
+			if strings.Contains(column, "lb") {
+				f.weightUnit = models.UnitLb
+			}
+		}
+	}
+	return f
+}
+
+// genericFields reads a set of a file with the columns given by the *_column
+// form values, weights are in unit, distances in meters and durations in seconds
+func genericFields(r *http.Request, row importRow, unit string) setFields {
+	column := func(name string) string {
+		if c := r.FormValue(name + "_column"); c != "" {
+			return row.get(c)
+		}
+		return ""
+	}
+	return setFields{
+		date:         column("date"),
+		session:      column("date") + column("workout"),
+		exercise:     column("exercise"),
+		weight:       column("weight"),
+		weightUnit:   unit,
+		reps:         column("reps"),
+		rpe:          column("rpe"),
+		setType:      column("set_type"),
+		duration:     column("duration"),
+		distance:     column("distance"),
+		distanceUnit: "m",
+	}
+}
+
+// parseImportNumber parses a number of an import file, which can use a decimal comma
+func parseImportNumber(s string) (models.Decimal, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if !strings.Contains(s, ".") {
+		s = strings.Replace(s, ",", ".", 1)
+	}
+	return models.ParseRoundedDecimal(s)
+}
+
+// parseImportDuration parses seconds or a h:mm:ss or mm:ss duration
+func parseImportDuration(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	seconds := 0
+	for _, part := range strings.Split(s, ":") {
+		n, err := parseImportNumber(part)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %s", s)
+		}
+		seconds = seconds*60 + int(n.Int())
+	}
+	return seconds, nil
+}
+
+// parseSetFields builds the set of an import file line
+func parseSetFields(line int, f setFields) (models.ImportSet, error) {
+	set := models.ImportSet{Line: line, Session: f.session, ExerciseName: f.exercise}
+	if f.exercise == "" {
+		return set, errors.New("exercise is required")
+	}
+
+	var err error
+	for _, layout := range importDateLayouts {
+		if set.StartedAt, err = time.Parse(layout, f.date); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return set, fmt.Errorf("invalid date %s", f.date)
+	}
+
+	record := models.Record{
+		WeightUnit:    f.weightUnit,
+		DatePerformed: set.StartedAt.Format(dateLayout),
+	}
+	setType, ok := importSetTypes[strings.ToLower(f.setType)]
+	if !ok {
+		return set, fmt.Errorf("unknown set type %s", f.setType)
+	}
+	record.SetType = setType
+
+	if record.Weight, err = parseImportNumber(f.weight); err != nil {
+		return set, err
+	}
+	reps, err := parseImportNumber(f.reps)
+	if err != nil {
+		return set, err
+	}
+	record.Reps = int(reps.Int())
+	if f.rpe != "" {
+		rpe, err := parseImportNumber(f.rpe)
+		if err != nil {
+			return set, err
+		}
+		record.RPE = &rpe
+	}
+	if record.Duration, err = parseImportDuration(f.duration); err != nil {
+		return set, err
+	}
+
+	distance, err := parseImportNumber(f.distance)
+	if err != nil {
+		return set, err
+	}
+	meters, ok := metersIn[f.distanceUnit]
+	if !ok && distance != 0 {
+		return set, fmt.Errorf("unknown distance unit %s", f.distanceUnit)
+	}
+	record.Distance = models.Decimal((int64(distance)*meters + 500) / 1000)
+
+	set.Record = record
+	return set, nil
+}
+
+// ImportRecords the training history import handler. It reads a CSV file from
+// Strong, Hevy, FitNotes or with generic columns, matches its exercise names to
+// the catalog and previews the import unless dry_run is false. Nothing is
+// imported while names are unmatched or lines invalid, mapping names exercises
+// IDs by hand, and sets already logged are skipped. Imported sets update
+// personal records and program results like logged ones
+func ImportRecords(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+
+	var file io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(maxImportSize); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		part, _, err := r.FormFile("file")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("file is required"))
+			return
+		}
+		defer part.Close()
+		file = part
+	}
+
+	preview := importPreview{
+		Format:     r.FormValue("format"),
+		DryRun:     r.FormValue("dry_run") != "false",
+		Matched:    []importMatch{},
+		Unmatched:  []importUnmatched{},
+		Errors:     []importError{},
+		Duplicates: []int{},
+	}
+	mapping := map[string]int{}
+	if m := r.FormValue("mapping"); m != "" {
+		if err := json.Unmarshal([]byte(m), &mapping); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("mapping must be a JSON object of exercise IDs by name"))
+			return
+		}
+	}
+
+	unit, err := weightUnit(db, r)
+	if err == errUnknownUnit {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	rows, err := readImportRows(file)
+	if err == nil && preview.Format == "" && len(rows) > 0 {
+		preview.Format = detectImportFormat(rows[0])
+	}
+	if err == nil && preview.Format == formatGeneric && (r.FormValue("date_column") == "" || r.FormValue("exercise_column") == "") {
+		err = errors.New("date_column and exercise_column are required for generic files")
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	sets := []models.ImportSet{}
+	for _, row := range rows {
+		var f setFields
+		switch preview.Format {
+		case formatStrong:
+			f = strongFields(row, unit)
+		case formatHevy:
+			f = hevyFields(row)
+		case formatFitNotes:
+			f = fitNotesFields(row)
+		case formatGeneric:
+			f = genericFields(r, row, unit)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("format must be strong, hevy, fitnotes or generic"))
+			return
+		}
+
+		set, err := parseSetFields(row.line, f)
+		if err != nil {
+			preview.Errors = append(preview.Errors, importError{row.line, err.Error()})
+			continue
+		}
+		sets = append(sets, set)
+	}
+	preview.Sets = len(sets)
+
+	catalog, err := models.GetExerciseCatalog(db)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	byID := map[int]models.Exercise{}
+	for _, e := range catalog {
+		byID[e.ID] = e
+	}
+
+	// names are matched once, in the order they first appear
+	matches := map[string]*models.Exercise{}
+	unmatched := map[string]int{}
+	matched := []models.ImportSet{}
+	for _, set := range sets {
+		exercise, seen := matches[set.ExerciseName]
+		if !seen {
+			if id, ok := mapping[set.ExerciseName]; ok {
+				if e, ok := byID[id]; ok {
+					exercise = &e
+				}
+			} else if e, ok := models.MatchExercise(set.ExerciseName, catalog); ok {
+				exercise = &e
+			}
+			matches[set.ExerciseName] = exercise
+			if exercise != nil {
+				preview.Matched = append(preview.Matched, importMatch{set.ExerciseName, exercise.ID, exercise.Name})
+			}
+		}
+		if exercise == nil {
+			if unmatched[set.ExerciseName] == 0 {
+				preview.Unmatched = append(preview.Unmatched, importUnmatched{Name: set.ExerciseName})
+			}
+			unmatched[set.ExerciseName]++
+			continue
+		}
+
+		set.Record.ExerciseID = exercise.ID
+		err := models.ValidateEffort(set.Record.RPE, set.Record.RIR)
+		if err == nil {
+			err = exercise.ValidateRecord(set.Record)
+		}
+		if err != nil {
+			preview.Errors = append(preview.Errors, importError{set.Line, err.Error()})
+			continue
+		}
+		matched = append(matched, set)
+	}
+	for i := range preview.Unmatched {
+		preview.Unmatched[i].Sets = unmatched[preview.Unmatched[i].Name]
+	}
+
+	preview.Duplicates, err = models.FindDuplicates(db, userID, matched)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	duplicates := map[int]bool{}
+	for _, line := range preview.Duplicates {
+		duplicates[line] = true
+	}
+	importable := []models.ImportSet{}
+	for _, set := range matched {
+		if !duplicates[set.Line] {
+			importable = append(importable, set)
+		}
+	}
+	preview.Importable = len(importable)
+
+	if preview.DryRun {
+		json.NewEncoder(w).Encode(preview)
+		return
+	}
+	if len(preview.Unmatched) > 0 || len(preview.Errors) > 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(preview)
+		return
+	}
+
+	preview.Workouts, err = models.ImportSets(db, userID, importable)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	days := []setDay{}
+	for _, set := range importable {
+		days = append(days, setDay{set.Record.ExerciseID, set.Record.DatePerformed})
+	}
+	refreshSetDays(db, userID, days)
+	preview.Imported = len(importable)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(preview)
+}
@@ -0,0 +1,88 @@
+package exercise
+
+import (
+	"testing"
+
+	"github.com/reynld/shinpo/server/models"
+)
+
+func TestDetectImportFormat(t *testing.T) {
+	row := func(columns ...string) importRow {
+		r := importRow{columns: map[string]string{}}
+		for _, c := range columns {
+			r.columns[c] = ""
+		}
+		return r
+	}
+	tests := []struct {
+		name string
+		row  importRow
+		want string
+	}{
+		{"strong", row("date", "workout name", "exercise name", "set order", "weight", "reps"), formatStrong},
+		{"hevy", row("title", "start_time", "exercise_title", "set_type", "weight_kg", "reps"), formatHevy},
+		{"fitnotes", row("date", "exercise", "category", "weight (kgs)", "reps"), formatFitNotes},
+		{"unknown", row("day", "lift", "kg", "reps"), ""},
+	}
+	for _, tt := range tests {
+		if got := detectImportFormat(tt.row); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseSetFieldsDates(t *testing.T) {
+	tests := []struct {
+		date string
+		want string
+		ok   bool
+	}{
+		{"2024-03-05 18:30:00", "2024-03-05", true},
+		{"2024-03-05 18:30", "2024-03-05", true},
+		{"2024-03-05T18:30:00Z", "2024-03-05", true},
+		{"2024-03-05", "2024-03-05", true},
+		{"5 Mar 2024, 18:30", "2024-03-05", true},
+		{"Mar 5, 2024, 18:30", "2024-03-05", true},
+		{"2024/03/05", "2024-03-05", true},
+		{"05.03.2024", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		set, err := parseSetFields(1, setFields{date: tt.date, exercise: "Squat", weightUnit: models.UnitKg})
+		if (err == nil) != tt.ok || (tt.ok && set.Record.DatePerformed != tt.want) {
+			t.Errorf("date %q: got %q, %v, want %q", tt.date, set.Record.DatePerformed, err, tt.want)
+		}
+	}
+}
+
+func TestParseSetFieldsNumbers(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields setFields
+		check  func(models.Record) bool
+		ok     bool
+	}{
+		{"decimal point", setFields{weight: "102.5", reps: "5"}, func(r models.Record) bool {
+			return r.Weight == 102500 && r.Reps == 5
+		}, true},
+		{"decimal comma", setFields{weight: "102,5", rpe: "8,5"}, func(r models.Record) bool {
+			return r.Weight == 102500 && r.RPE != nil && *r.RPE == 8500
+		}, true},
+		{"duration", setFields{duration: "1:02:03"}, func(r models.Record) bool {
+			return r.Duration == 3723
+		}, true},
+		{"distance", setFields{distance: "1,5", distanceUnit: "km"}, func(r models.Record) bool {
+			return r.Distance == 1500000
+		}, true},
+		{"thousands separator", setFields{weight: "1,000.5"}, nil, false},
+		{"unknown distance unit", setFields{distance: "3", distanceUnit: "yd"}, nil, false},
+		{"unknown set type", setFields{setType: "giant"}, nil, false},
+	}
+	for _, tt := range tests {
+		tt.fields.date, tt.fields.exercise, tt.fields.weightUnit = "2024-03-05", "Squat", models.UnitKg
+		set, err := parseSetFields(1, tt.fields)
+		if (err == nil) != tt.ok || (tt.ok && !tt.check(set.Record)) {
+			t.Errorf("%s: got %+v, %v", tt.name, set.Record, err)
+		}
+	}
+}
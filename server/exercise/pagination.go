@@ -0,0 +1,69 @@
+package exercise
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/reynld/shinpo/server/models"
+)
+
+// listOptions reads the sort, cursor and limit query parameters of a listing
+func listOptions(r *http.Request, defaultSort string) (models.ListOptions, error) {
+	query := r.URL.Query()
+	opts := models.ListOptions{
+		Sort:   query.Get("sort"),
+		Cursor: query.Get("cursor"),
+		Limit:  models.DefaultPageSize,
+	}
+	if opts.Sort == "" {
+		opts.Sort = defaultSort
+	}
+	if param := query.Get("limit"); param != "" {
+		limit, err := strconv.Atoi(param)
+		if err != nil {
+			return opts, fmt.Errorf("limit must be a number")
+		}
+		opts.Limit = limit
+	}
+	return opts, nil
+}
+
+// intParam reads an optional integer query parameter, 0 when left out
+func intParam(r *http.Request, name string) (int, error) {
+	param := r.URL.Query().Get(name)
+	if param == "" {
+		return 0, nil
+	}
+	v, err := strconv.Atoi(param)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a number", name)
+	}
+	return v, nil
+}
+
+// dateParam reads an optional YYYY-MM-DD date query parameter
+func dateParam(r *http.Request, name string) (string, error) {
+	param := r.URL.Query().Get(name)
+	if param == "" {
+		return "", nil
+	}
+	if _, err := time.Parse(dateLayout, param); err != nil {
+		return "", fmt.Errorf("%s must be a YYYY-MM-DD date", name)
+	}
+	return param, nil
+}
+
+// weightParam reads an optional weight query parameter
+func weightParam(r *http.Request, name string) (*models.Decimal, error) {
+	param := r.URL.Query().Get(name)
+	if param == "" {
+		return nil, nil
+	}
+	w, err := models.ParseDecimal(param)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	return &w, nil
+}
@@ -0,0 +1,470 @@
+package exercise
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/reynld/shinpo/server/models"
+)
+
+// Program limits, percents are of the training max
+const (
+	maxProgramName  = 80
+	maxProgramWeeks = 52
+	maxProgramSets  = 20
+	maxCycles       = 12
+	maxPercent      = models.Decimal(150000)
+	maxResetPercent = models.Decimal(100000)
+)
+
+// defaultResetPercent is what a training max resets to after too many failures
+const defaultResetPercent = models.Decimal(90000)
+
+// validateProgram checks a program payload. Rule increments default to unit,
+// rules to advancing every session and resetting to 90%
+func validateProgram(db *sql.DB, p *models.Program, unit string) error {
+	if p.Name == "" || len(p.Name) > maxProgramName {
+		return fmt.Errorf("name is required and can't be longer than %d characters", maxProgramName)
+	}
+	if p.Weeks < 1 || p.Weeks > maxProgramWeeks {
+		return fmt.Errorf("weeks must be between 1 and %d", maxProgramWeeks)
+	}
+	if p.DaysPerWeek < 1 || p.DaysPerWeek > 7 {
+		return errors.New("days_per_week must be between 1 and 7")
+	}
+	if len(p.Exercises) == 0 {
+		return errors.New("a program needs at least one exercise")
+	}
+
+	exercises := map[int]bool{}
+	for i, e := range p.Exercises {
+		if _, err := models.GetExercise(db, e.ExerciseID); err == sql.ErrNoRows {
+			return fmt.Errorf("exercise %d: unknown exercise %d", i+1, e.ExerciseID)
+		} else if err != nil {
+			return err
+		}
+		if e.Week < 1 || e.Week > p.Weeks || e.Day < 1 || e.Day > p.DaysPerWeek {
+			return fmt.Errorf("exercise %d: week and day must be within the program", i+1)
+		}
+		if e.Sets < 1 || e.Sets > maxProgramSets {
+			return fmt.Errorf("exercise %d: sets must be between 1 and %d", i+1, maxProgramSets)
+		}
+		if e.Reps < 1 {
+			return fmt.Errorf("exercise %d: reps must be at least 1", i+1)
+		}
+		if e.Percent <= 0 || e.Percent > maxPercent {
+			return fmt.Errorf("exercise %d: percent must be above 0 and at most %s", i+1, maxPercent)
+		}
+		exercises[e.ExerciseID] = true
+	}
+
+	ruled := map[int]bool{}
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if !exercises[rule.ExerciseID] {
+			return fmt.Errorf("rule %d: exercise %d isn't in the program", i+1, rule.ExerciseID)
+		}
+		if ruled[rule.ExerciseID] {
+			return fmt.Errorf("rule %d: exercise %d already has a rule", i+1, rule.ExerciseID)
+		}
+		ruled[rule.ExerciseID] = true
+		if rule.Increment < 0 {
+			return fmt.Errorf("rule %d: increment can't be negative", i+1)
+		}
+		if rule.WeightUnit == "" {
+			rule.WeightUnit = unit
+		}
+		if !models.Units[rule.WeightUnit] {
+			return fmt.Errorf("rule %d: unknown weight unit %s", i+1, rule.WeightUnit)
+		}
+		if rule.AdvanceOn == "" {
+			rule.AdvanceOn = models.AdvanceSession
+		}
+		if !models.AdvanceOn[rule.AdvanceOn] {
+			return fmt.Errorf("rule %d: advance_on must be %s or %s", i+1, models.AdvanceSession, models.AdvanceCycle)
+		}
+		if rule.FailuresToReset < 0 {
+			return fmt.Errorf("rule %d: failures_to_reset can't be negative", i+1)
+		}
+		if rule.ResetPercent == 0 {
+			rule.ResetPercent = defaultResetPercent
+		}
+		if rule.ResetPercent < 0 || rule.ResetPercent > maxResetPercent {
+			return fmt.Errorf("rule %d: reset_percent must be above 0 and at most %s", i+1, maxResetPercent)
+		}
+	}
+
+	return nil
+}
+
+// validateEnrollment checks an enrollment payload in program p. It starts today
+// by default for one cycle and needs a training max for every exercise of p,
+// in unit unless given
+func validateEnrollment(p models.Program, e *models.Enrollment, unit string) error {
+	if e.StartedOn == "" {
+		e.StartedOn = time.Now().UTC().Format(dateLayout)
+	}
+	if _, err := time.Parse(dateLayout, e.StartedOn); err != nil {
+		return errors.New("started_on must be a YYYY-MM-DD date")
+	}
+	if e.Cycles == 0 {
+		e.Cycles = 1
+	}
+	if e.Cycles < 1 || e.Cycles > maxCycles {
+		return fmt.Errorf("cycles must be between 1 and %d", maxCycles)
+	}
+
+	maxes := map[int]bool{}
+	for i := range e.TrainingMaxes {
+		tm := &e.TrainingMaxes[i]
+		if maxes[tm.ExerciseID] {
+			return fmt.Errorf("training max %d: exercise %d already has one", i+1, tm.ExerciseID)
+		}
+		maxes[tm.ExerciseID] = true
+		if tm.Weight <= 0 {
+			return fmt.Errorf("training max %d: weight must be above 0", i+1)
+		}
+		if tm.WeightUnit == "" {
+			tm.WeightUnit = unit
+		}
+		if !models.Units[tm.WeightUnit] {
+			return fmt.Errorf("training max %d: unknown weight unit %s", i+1, tm.WeightUnit)
+		}
+	}
+
+	exercises := map[int]bool{}
+	for _, pe := range p.Exercises {
+		if !maxes[pe.ExerciseID] {
+			return fmt.Errorf("exercise %d needs a training max", pe.ExerciseID)
+		}
+		exercises[pe.ExerciseID] = true
+	}
+	for _, tm := range e.TrainingMaxes {
+		if !exercises[tm.ExerciseID] {
+			return fmt.Errorf("exercise %d isn't in the program", tm.ExerciseID)
+		}
+	}
+
+	return nil
+}
+
+// programID reads the program ID route parameter
+func programID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return 0, errors.New("invalid program id")
+	}
+	return id, nil
+}
+
+// enrollmentID reads the enrollment ID route parameter
+func enrollmentID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return 0, errors.New("invalid enrollment id")
+	}
+	return id, nil
+}
+
+// GetPrograms the programs handler
+func GetPrograms(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	unit, err := weightUnit(db, r)
+	if err == errUnknownUnit {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	programs, err := models.GetPrograms(db)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	for i := range programs {
+		programs[i].ConvertWeights(unit)
+	}
+	json.NewEncoder(w).Encode(programs)
+}
+
+// GetProgram the single program handler
+func GetProgram(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	id, err := programID(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	unit, err := weightUnit(db, r)
+	if err == errUnknownUnit {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	program, err := models.GetProgram(db, id)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	program.ConvertWeights(unit)
+	json.NewEncoder(w).Encode(program)
+}
+
+// AddProgram the add new program handler
+func AddProgram(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+	var payload models.Program
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	unit, err := weightUnit(db, r)
+	if err == nil {
+		err = validateProgram(db, &payload, unit)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	payload.UserID = &userID
+
+	program, err := models.CreateProgram(db, payload)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	program.ConvertWeights(unit)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(program)
+}
+
+// DeleteProgram the delete program handler, enrollments in it are deleted too
+func DeleteProgram(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	id, err := programID(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	count, err := models.DeleteProgram(db, id)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]int{"count": count})
+}
+
+// EnrollProgram the enroll in program handler, schedules the workouts of the
+// program from the day the enrollment starts on
+func EnrollProgram(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+	id, err := programID(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	var payload models.Enrollment
+	err = json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	unit, err := weightUnit(db, r)
+	if err == errUnknownUnit {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	program, err := models.GetProgram(db, id)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err := validateEnrollment(program, &payload, unit); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	payload.UserID = userID
+
+	enrollment, err := models.Enroll(db, program, payload)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	enrollment.ConvertWeights(unit)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+// GetEnrollments the user enrollments handler
+func GetEnrollments(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+	unit, err := weightUnit(db, r)
+	if err == errUnknownUnit {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	enrollments, err := models.GetEnrollments(db, userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	for i := range enrollments {
+		enrollments[i].ConvertWeights(unit)
+	}
+	json.NewEncoder(w).Encode(enrollments)
+}
+
+// GetEnrollment the single enrollment handler
+func GetEnrollment(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+	id, err := enrollmentID(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	unit, err := weightUnit(db, r)
+	if err == errUnknownUnit {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	enrollment, err := models.GetEnrollment(db, userID, id)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	enrollment.ConvertWeights(unit)
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+// CancelEnrollment the cancel enrollment handler, its scheduled workouts are no
+// longer planned
+func CancelEnrollment(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+	id, err := enrollmentID(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	count, err := models.CancelEnrollment(db, userID, id)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]int{"count": count})
+}
+
+// GetEnrollmentSchedule the enrollment schedule handler, the scheduled workouts
+// from and to optional dates with weights from the current training maxes
+func GetEnrollmentSchedule(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+	id, err := enrollmentID(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	filter := models.ScheduleFilter{EnrollmentID: id}
+	unit, err := weightUnit(db, r)
+	if err == nil {
+		filter.From, err = dateParam(r, "from")
+	}
+	if err == nil {
+		filter.To, err = dateParam(r, "to")
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if _, err := models.GetEnrollment(db, userID, id); err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	schedule, err := models.GetScheduledWorkouts(db, userID, filter, unit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	json.NewEncoder(w).Encode(schedule)
+}
@@ -3,26 +3,167 @@ package exercise
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
+	"sort"
 	"strconv"
 
 	"github.com/gorilla/mux"
 	"github.com/reynld/shinpo/server/models"
 )
 
-// GetUserRecords the user Records handler
+// validateRecord checks a record logged for exerciseID, defaulting its set type
+// to a working set and its weight unit to unit
+func validateRecord(db *sql.DB, exerciseID int, record *models.Record, unit string) error {
+	if record.SetType == "" {
+		record.SetType = models.SetWorking
+	}
+	if !models.SetTypes[record.SetType] {
+		return fmt.Errorf("unknown set type %s", record.SetType)
+	}
+	if err := checkWeightUnit(&record.WeightUnit, unit); err != nil {
+		return err
+	}
+	if err := models.ValidateEffort(record.RPE, record.RIR); err != nil {
+		return err
+	}
+
+	exercise, err := models.GetExercise(db, exerciseID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("unknown exercise %d", exerciseID)
+	}
+	if err != nil {
+		return err
+	}
+	return exercise.ValidateRecord(*record)
+}
+
+// GetUserRecords the user Records handler, newest first unless sorted by date,
+// weight or reps, and filtered by exercise, category, date and weight
 func GetUserRecords(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 	id := r.Context().Value("ID").(int)
-	records, err := models.GetAllRecords(db, id)
+	unit, err := weightUnit(db, r)
+	if err == errUnknownUnit {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
 		return
 	}
-	json.NewEncoder(w).Encode(records)
+
+	filter := models.RecordFilter{WeightUnit: unit}
+	filter.ExerciseID, err = intParam(r, "exercise_id")
+	if err == nil {
+		filter.CategoryID, err = intParam(r, "category_id")
+	}
+	if err == nil {
+		filter.From, err = dateParam(r, "from")
+	}
+	if err == nil {
+		filter.To, err = dateParam(r, "to")
+	}
+	if err == nil {
+		filter.MinWeight, err = weightParam(r, "min_weight")
+	}
+	if err == nil {
+		filter.MaxWeight, err = weightParam(r, "max_weight")
+	}
+	var opts models.ListOptions
+	if err == nil {
+		opts, err = listOptions(r, "-date")
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	records, next, err := models.GetAllRecords(db, id, filter, opts)
+	if _, ok := err.(models.ListError); ok {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	for i := range records {
+		records[i].ConvertWeight(unit)
+	}
+	json.NewEncoder(w).Encode(models.Page{Items: records, NextCursor: next})
 }
 
-// AddUserRecord the add new user record handler
+// setDay is an exercise a user logged sets of on a day
+type setDay struct {
+	exerciseID int
+	date       string
+}
+
+// setDays lists the exercise days of records
+func setDays(records ...models.Record) []setDay {
+	days := []setDay{}
+	for _, r := range records {
+		days = append(days, setDay{r.ExerciseID, r.DatePerformed})
+	}
+	return days
+}
+
+// workoutSetDays lists the exercise days of the sets of workouts
+func workoutSetDays(workouts ...models.Workout) []setDay {
+	days := []setDay{}
+	for _, w := range workouts {
+		for _, e := range w.Exercises {
+			days = append(days, setDays(e.Sets...)...)
+		}
+	}
+	return days
+}
+
+// refreshSetDays brings the personal records and program results of user up to
+// date once sets of days were saved, edited or deleted. Personal records of each
+// exercise are replayed from its earliest day and the results of each day are
+// decided again. Failures are only logged, the sets are saved either way
+func refreshSetDays(db *sql.DB, userID int, days []setDay) {
+	from := map[int]string{}
+	seen := map[setDay]bool{}
+	unique := []setDay{}
+	for _, d := range days {
+		if f, ok := from[d.exerciseID]; !ok || d.date < f {
+			from[d.exerciseID] = d.date
+		}
+		if !seen[d] {
+			seen[d] = true
+			unique = append(unique, d)
+		}
+	}
+
+	// earlier days first, as results are decided in the order of their workouts
+	sort.SliceStable(unique, func(i, j int) bool { return unique[i].date < unique[j].date })
+	for _, d := range unique {
+		if _, err := models.RedecideProgression(db, userID, d.exerciseID, d.date); err != nil {
+			log.Print(err)
+		}
+	}
+
+	for exerciseID, date := range from {
+		exercise, err := models.GetExercise(db, exerciseID)
+		if err == nil {
+			_, err = models.RebuildPersonalRecords(db, exercise, userID, date)
+		}
+		if err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// AddUserRecord the add new user record handler, the set joins workout_id when
+// given, or else the latest workout of its day
 func AddUserRecord(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("ID").(int)
 	var payload models.Record
@@ -32,25 +173,74 @@ func AddUserRecord(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(err.Error()))
 		return
 	}
+	unit, err := weightUnit(db, r)
+	if err == nil {
+		err = validateRecord(db, payload.ExerciseID, &payload, unit)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
 	record, err := models.CreateRecord(
 		db,
 		models.Record{
 			ID:            0,
 			Weight:        payload.Weight,
+			WeightUnit:    payload.WeightUnit,
 			Reps:          payload.Reps,
 			RPE:           payload.RPE,
+			RIR:           payload.RIR,
+			Duration:      payload.Duration,
+			Distance:      payload.Distance,
+			SetType:       payload.SetType,
 			DatePerformed: payload.DatePerformed,
 			ExerciseID:    payload.ExerciseID,
+			WorkoutID:     payload.WorkoutID,
 			UserID:        userID,
 		},
 	)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("unknown workout %d", payload.WorkoutID)))
+		return
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
 		return
 	}
 
-	json.NewEncoder(w).Encode(record)
+	// the set is saved even if its personal records can't be
+	prs := []models.PersonalRecord{}
+	exercise, err := models.GetExercise(db, record.ExerciseID)
+	if err == nil {
+		prs, err = models.DetectPersonalRecords(db, exercise, record)
+	}
+	if err != nil {
+		log.Print(err)
+		prs = []models.PersonalRecord{}
+	}
+	for i := range prs {
+		prs[i].ConvertWeight(unit)
+	}
+
+	// and even if the program it was prescribed by can't progress
+	progression, err := models.ApplyProgression(db, record)
+	if err != nil {
+		log.Print(err)
+		progression = nil
+	}
+	if progression != nil {
+		progression.ConvertWeights(unit)
+	}
+
+	record.ConvertWeight(unit)
+	json.NewEncoder(w).Encode(struct {
+		models.Record
+		PRs         []models.PersonalRecord  `json:"prs"`
+		Progression *models.ProgressionEvent `json:"progression"`
+	}{record, prs, progression})
 }
 
 // EditUserRecord the edit record handler
@@ -93,13 +283,36 @@ func EditUserRecord(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	existing, err := models.GetRecord(db, payload.ID)
+	if err == sql.ErrNoRows || (err == nil && existing.UserID != userID) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	unit, err := weightUnit(db, r)
+	if err == nil {
+		err = validateRecord(db, existing.ExerciseID, &payload, unit)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
 	record, err := models.EditRecord(db, userID, payload)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
 		return
 	}
+	refreshSetDays(db, userID, setDays(record))
 
+	record.ConvertWeight(unit)
 	json.NewEncoder(w).Encode(record)
 }
 
@@ -116,12 +329,22 @@ func DeleteUserRecord(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	existing, err := models.GetRecord(db, id)
+	if err != nil && err != sql.ErrNoRows {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
 	count, err := models.DeleteRecord(db, userID, id)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
 		return
 	}
+	if count > 0 {
+		refreshSetDays(db, userID, setDays(existing))
+	}
 
 	json.NewEncoder(w).Encode(map[string]int{"count": count})
 
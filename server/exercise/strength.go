@@ -0,0 +1,112 @@
+package exercise
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/reynld/shinpo/server/models"
+)
+
+// GetOneRepMax the estimated one-rep max handler, formula defaults to epley
+func GetOneRepMax(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+	query := r.URL.Query()
+
+	exerciseID, err := strconv.Atoi(query.Get("exercise_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("exercise_id is required"))
+		return
+	}
+	formula := query.Get("formula")
+	if formula == "" {
+		formula = models.FormulaEpley
+	}
+
+	unit, err := weightUnit(db, r)
+	if err == errUnknownUnit {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	exercise, err := models.GetExercise(db, exerciseID)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	records, err := models.GetExerciseRecords(db, userID, exerciseID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	report, err := models.EstimateOneRepMax(exercise, records, formula, unit)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	json.NewEncoder(w).Encode(report)
+}
+
+// GetPersonalRecords the personal records history handler, of every exercise
+// unless exercise_id is given
+func GetPersonalRecords(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+
+	exerciseID := 0
+	if param := r.URL.Query().Get("exercise_id"); param != "" {
+		id, err := strconv.Atoi(param)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		exerciseID = id
+	}
+
+	unit, err := weightUnit(db, r)
+	if err == errUnknownUnit {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	exercises, err := models.GetPersonalRecords(db, userID, exerciseID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	for i := range exercises {
+		for j := range exercises[i].Current {
+			exercises[i].Current[j].ConvertWeight(unit)
+		}
+		for j := range exercises[i].History {
+			exercises[i].History[j].ConvertWeight(unit)
+		}
+	}
+
+	json.NewEncoder(w).Encode(exercises)
+}
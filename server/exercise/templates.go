@@ -0,0 +1,229 @@
+package exercise
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/reynld/shinpo/server/models"
+)
+
+// Template limits
+const (
+	maxTemplateName = 80
+	maxTemplateSets = 20
+)
+
+// validateTemplate checks a template payload, reps max defaults to reps min
+func validateTemplate(db *sql.DB, t *models.Template) error {
+	if t.Name == "" || len(t.Name) > maxTemplateName {
+		return fmt.Errorf("name is required and can't be longer than %d characters", maxTemplateName)
+	}
+
+	for i := range t.Exercises {
+		e := &t.Exercises[i]
+		if _, err := models.GetExercise(db, e.ExerciseID); err == sql.ErrNoRows {
+			return fmt.Errorf("exercise %d: unknown exercise %d", i+1, e.ExerciseID)
+		} else if err != nil {
+			return err
+		}
+		if e.Sets < 1 || e.Sets > maxTemplateSets {
+			return fmt.Errorf("exercise %d: sets must be between 1 and %d", i+1, maxTemplateSets)
+		}
+		if e.RepsMax == 0 {
+			e.RepsMax = e.RepsMin
+		}
+		if e.RepsMin < 0 || e.RepsMax < e.RepsMin {
+			return fmt.Errorf("exercise %d: reps_min can't be negative or above reps_max", i+1)
+		}
+		if e.Rest < 0 {
+			return fmt.Errorf("exercise %d: rest can't be negative", i+1)
+		}
+		if err := models.ValidateEffort(e.RPE, nil); err != nil {
+			return fmt.Errorf("exercise %d: %s", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+// templateID reads the template ID route parameter
+func templateID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return 0, errors.New("invalid template id")
+	}
+	return id, nil
+}
+
+// GetTemplates the user templates handler
+func GetTemplates(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+
+	templates, err := models.GetTemplates(db, userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	json.NewEncoder(w).Encode(templates)
+}
+
+// GetTemplate the single template handler
+func GetTemplate(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+	id, err := templateID(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	template, err := models.GetTemplate(db, userID, id)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	json.NewEncoder(w).Encode(template)
+}
+
+// AddTemplate the add new template handler
+func AddTemplate(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+	var payload models.Template
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err == nil {
+		err = validateTemplate(db, &payload)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	payload.UserID = userID
+
+	template, err := models.CreateTemplate(db, payload)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(template)
+}
+
+// EditTemplate the edit template handler, the payload exercises replace the
+// template ones
+func EditTemplate(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+	id, err := templateID(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	var payload models.Template
+	err = json.NewDecoder(r.Body).Decode(&payload)
+	if err == nil {
+		err = validateTemplate(db, &payload)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	payload.ID = id
+	payload.UserID = userID
+
+	template, err := models.UpdateTemplate(db, payload)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	json.NewEncoder(w).Encode(template)
+}
+
+// DeleteTemplate the delete template handler
+func DeleteTemplate(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+	id, err := templateID(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	count, err := models.DeleteTemplate(db, userID, id)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]int{"count": count})
+}
+
+// StartTemplate the start template handler, creates a workout from the template
+// starting now and returns it with its target sets pre-filled. The sets aren't
+// saved until the workout is edited with the actual ones
+func StartTemplate(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+	id, err := templateID(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	unit, err := weightUnit(db, r)
+	if err == errUnknownUnit {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	template, err := models.GetTemplate(db, userID, id)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	workout, err := models.StartTemplate(db, template, time.Now().UTC())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	workout.ConvertWeights(unit)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(workout)
+}
@@ -0,0 +1,38 @@
+package exercise
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/reynld/shinpo/server/models"
+)
+
+// errUnknownUnit is returned for a unit query parameter other than kg or lb
+var errUnknownUnit = errors.New("unit must be kg or lb")
+
+// weightUnit returns the unit weights are read and written in for this request,
+// the unit query parameter or else the user preferred unit
+func weightUnit(db *sql.DB, r *http.Request) (string, error) {
+	if unit := r.URL.Query().Get("unit"); unit != "" {
+		if !models.Units[unit] {
+			return "", errUnknownUnit
+		}
+		return unit, nil
+	}
+
+	userID := r.Context().Value("ID").(int)
+	return models.GetWeightUnit(db, userID)
+}
+
+// checkWeightUnit defaults a logged weight unit to unit and validates it
+func checkWeightUnit(logged *string, unit string) error {
+	if *logged == "" {
+		*logged = unit
+	}
+	if !models.Units[*logged] {
+		return fmt.Errorf("weight_unit must be kg or lb")
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package exercise
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/reynld/shinpo/server/models"
+)
+
+// validateWorkout checks a workout payload, defaulting set types to working sets
+// and weight units to unit
+func validateWorkout(db *sql.DB, w *models.Workout, unit string) error {
+	if w.StartedAt.IsZero() {
+		return errors.New("started_at is required")
+	}
+	if w.EndedAt != nil && w.EndedAt.Before(w.StartedAt) {
+		return errors.New("ended_at can't be before started_at")
+	}
+
+	for i := range w.Exercises {
+		exercise := &w.Exercises[i]
+		if exercise.ExerciseID == 0 {
+			return fmt.Errorf("exercise %d: exercise_id is required", i+1)
+		}
+		for j := range exercise.Sets {
+			if err := validateRecord(db, exercise.ExerciseID, &exercise.Sets[j], unit); err != nil {
+				return fmt.Errorf("exercise %d set %d: %s", i+1, j+1, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetWorkouts the user workouts handler
+func GetWorkouts(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+	unit, err := weightUnit(db, r)
+	if err == errUnknownUnit {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	workouts, err := models.GetWorkouts(db, userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	for i := range workouts {
+		workouts[i].ConvertWeights(unit)
+	}
+	json.NewEncoder(w).Encode(workouts)
+}
+
+// GetWorkout the single workout handler
+func GetWorkout(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	unit, err := weightUnit(db, r)
+	if err == errUnknownUnit {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	workout, err := models.GetWorkout(db, userID, id)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	workout.ConvertWeights(unit)
+	json.NewEncoder(w).Encode(workout)
+}
+
+// AddWorkout the add new workout handler
+func AddWorkout(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+	var payload models.Workout
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	unit, err := weightUnit(db, r)
+	if err == nil {
+		err = validateWorkout(db, &payload, unit)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	payload.UserID = userID
+	// workouts are linked to templates by starting them
+	payload.TemplateID = nil
+
+	workout, err := models.CreateWorkout(db, payload)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	refreshSetDays(db, userID, workoutSetDays(workout))
+
+	workout.ConvertWeights(unit)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(workout)
+}
+
+// EditWorkout the edit workout handler, exercises and sets left out of the
+// payload are deleted
+func EditWorkout(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	var payload models.Workout
+	err = json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	unit, err := weightUnit(db, r)
+	if err == nil {
+		err = validateWorkout(db, &payload, unit)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	payload.ID = id
+	payload.UserID = userID
+
+	// sets moved or left out count again on the days they were on
+	previous, err := models.GetWorkout(db, userID, id)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	workout, err := models.UpdateWorkout(db, payload)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	refreshSetDays(db, userID, workoutSetDays(previous, workout))
+
+	workout.ConvertWeights(unit)
+	json.NewEncoder(w).Encode(workout)
+}
+
+// DeleteWorkout the delete workout handler
+func DeleteWorkout(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("ID").(int)
+
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	previous, err := models.GetWorkout(db, userID, id)
+	if err != nil && err != sql.ErrNoRows {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	count, err := models.DeleteWorkout(db, userID, id)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if count > 0 {
+		refreshSetDays(db, userID, workoutSetDays(previous))
+	}
+
+	json.NewEncoder(w).Encode(map[string]int{"count": count})
+}
@@ -0,0 +1,38 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// LogMailer writes emails to a file instead of sending them,
+// an empty Path writes them to the standard logger
+type LogMailer struct {
+	Path string
+	mu   sync.Mutex
+}
+
+// Send appends email to the log
+func (m *LogMailer) Send(to string, subject string, body string) error {
+	msg := fmt.Sprintf("To: %s\nSubject: %s\n\n%s\n", to, subject, body)
+
+	if m.Path == "" {
+		log.Print(msg)
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "Date: %s\n%s\n", time.Now().Format(time.RFC1123Z), msg)
+	return err
+}
@@ -0,0 +1,26 @@
+package mail
+
+import (
+	"os"
+)
+
+// Mailer sends emails to users
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+// FromEnviroment returns an SMTP mailer when SMTP_HOST is set,
+// otherwise a LogMailer writing to MAIL_LOG_PATH for local development
+func FromEnviroment() Mailer {
+	if os.Getenv("SMTP_HOST") == "" {
+		return &LogMailer{Path: os.Getenv("MAIL_LOG_PATH")}
+	}
+
+	return &SMTPMailer{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     os.Getenv("SMTP_PORT"),
+		Username: os.Getenv("SMTP_USER"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("MAIL_FROM"),
+	}
+}
@@ -0,0 +1,39 @@
+package mail
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+)
+
+// SMTPMailer sends emails through an SMTP server
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// Send sends a plain text email
+func (m *SMTPMailer) Send(to string, subject string, body string) error {
+	port := m.Port
+	if port == "" {
+		port = "587"
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	msg := fmt.Sprintf(
+		"From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		m.From,
+		to,
+		subject,
+		body,
+	)
+
+	return smtp.SendMail(net.JoinHostPort(m.Host, port), auth, m.From, []string{to}, []byte(msg))
+}
@@ -0,0 +1,207 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Account is the profile of the logged in user
+type Account struct {
+	ID           int     `json:"id"`
+	Username     string  `json:"username"`
+	Email        string  `json:"email"`
+	PendingEmail *string `json:"pending_email"`
+	Verified     bool    `json:"verified"`
+	Role         string  `json:"role"`
+	TwoFactor    bool    `json:"two_factor"`
+	WeightUnit   string  `json:"weight_unit"`
+}
+
+// AccountUpdate a struct to read profile changes from the request body,
+// fields left out are not changed
+type AccountUpdate struct {
+	Username   *string `json:"username"`
+	Email      *string `json:"email"`
+	WeightUnit *string `json:"weight_unit"`
+}
+
+// PasswordChangeRequest a struct to read the current and new password
+type PasswordChangeRequest struct {
+	CurrentPassword string `json:"current_password"`
+	Password        string `json:"password"`
+}
+
+// IsUniqueViolation reports if err was caused by a UNIQUE constraint
+func IsUniqueViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23505"
+}
+
+// GetAccount gets the profile of user
+func GetAccount(db *sql.DB, id int) (Account, error) {
+	var account Account
+	err := db.QueryRow(
+		`SELECT u.id, u.username, u.email, u.pending_email, u.verified_at IS NOT NULL, u.role, u.totp_enabled_at IS NOT NULL, u.weight_unit
+		FROM users u
+		WHERE id = $1 AND u.deleted_at IS NULL`,
+		id).Scan(
+		&account.ID,
+		&account.Username,
+		&account.Email,
+		&account.PendingEmail,
+		&account.Verified,
+		&account.Role,
+		&account.TwoFactor,
+		&account.WeightUnit,
+	)
+	if err != nil {
+		return account, err
+	}
+	return account, nil
+}
+
+// GetPasswordHash gets the password hash of user
+func GetPasswordHash(db *sql.DB, id int) (string, error) {
+	var hash string
+	err := db.QueryRow(`SELECT u.password FROM users u WHERE id = $1 AND u.deleted_at IS NULL`, id).Scan(&hash)
+	return hash, err
+}
+
+// UpdateUsername changes username of user
+func UpdateUsername(db *sql.DB, id int, username string) error {
+	_, err := db.Exec(`UPDATE users SET username = $1 WHERE id = $2 AND deleted_at IS NULL`, username, id)
+	return err
+}
+
+// GetWeightUnit gets the unit weights are shown to user in
+func GetWeightUnit(db *sql.DB, id int) (string, error) {
+	var unit string
+	err := db.QueryRow(`SELECT u.weight_unit FROM users u WHERE id = $1`, id).Scan(&unit)
+	return unit, err
+}
+
+// UpdateWeightUnit changes the unit weights are shown to user in
+func UpdateWeightUnit(db *sql.DB, id int, unit string) error {
+	_, err := db.Exec(`UPDATE users SET weight_unit = $1 WHERE id = $2 AND deleted_at IS NULL`, unit, id)
+	return err
+}
+
+// SetPendingEmail keeps the new email of user until it is confirmed,
+// nil cancels a pending change
+func SetPendingEmail(db *sql.DB, id int, email *string) error {
+	_, err := db.Exec(`UPDATE users SET pending_email = $1 WHERE id = $2 AND deleted_at IS NULL`, email, id)
+	return err
+}
+
+// ConfirmEmail replaces user email with its pending email, which is verified
+// since the confirmation link was sent to it
+func ConfirmEmail(db *sql.DB, id int, email string) error {
+	res, err := db.Exec(`UPDATE users
+		SET email = pending_email, pending_email = NULL, verified_at = NOW()
+		WHERE id = $1 AND pending_email = $2 AND deleted_at IS NULL`, id, email)
+	if err != nil {
+		return err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteAccount soft deletes user, revoking its API keys, social logins and data
+// exports, its records are purged by PurgeDeletedAccounts after a grace period
+func DeleteAccount(db *sql.DB, id int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE users SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.Exec(`UPDATE api_keys SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM user_identities WHERE user_id = $1`, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM export_jobs WHERE user_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// PurgeDeletedAccounts deletes the data of accounts deleted more than grace ago
+// and scrubs their profile, the users row is kept so the audit log still points
+// somewhere. Returns the number of purged accounts
+func PurgeDeletedAccounts(db *sql.DB, grace time.Duration) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT u.id FROM users u
+		WHERE u.deleted_at < NOW() - $1 * INTERVAL '1 second' AND u.purged_at IS NULL
+		FOR UPDATE SKIP LOCKED`, int64(grace.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	for _, query := range []string{
+		`DELETE FROM user_records WHERE user_id = ANY($1)`,
+		`DELETE FROM workouts WHERE user_id = ANY($1)`,
+		`DELETE FROM templates WHERE user_id = ANY($1)`,
+		`DELETE FROM enrollments WHERE user_id = ANY($1)`,
+		`DELETE FROM calendar_tokens WHERE user_id = ANY($1)`,
+		`DELETE FROM user_recovery_codes WHERE user_id = ANY($1)`,
+		`DELETE FROM api_keys WHERE user_id = ANY($1)`,
+		`DELETE FROM export_jobs WHERE user_id = ANY($1)`,
+		`UPDATE users
+			SET username = 'deleted-' || id, email = 'deleted-' || id, pending_email = NULL,
+			password = '', totp_secret = NULL, totp_enabled_at = NULL, purged_at = NOW()
+			WHERE id = ANY($1)`,
+	} {
+		if _, err := tx.Exec(query, pq.Array(ids)); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(ids), tx.Commit()
+}
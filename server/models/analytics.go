@@ -0,0 +1,151 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Periods training volume can be bucketed by, weeks start on monday
+const (
+	PeriodWeek  = "week"
+	PeriodMonth = "month"
+)
+
+// Periods lists every valid analytics period
+var Periods = map[string]bool{
+	PeriodWeek:  true,
+	PeriodMonth: true,
+}
+
+// Groups training volume can be split by
+const (
+	GroupExercise = "exercise"
+	GroupCategory = "category"
+)
+
+// Groups lists every valid analytics group
+var Groups = map[string]bool{
+	GroupExercise: true,
+	GroupCategory: true,
+}
+
+// volumeGroupColumns are the id and name columns of each volume group
+var volumeGroupColumns = map[string]string{
+	GroupExercise: "e.id, e.name",
+	GroupCategory: "c.id, c.name",
+}
+
+// tonnageSQL sums the load lifted by the working sets of user_records r of
+// exercise e, the weight times reps of weight×reps sets and the added load of
+// bodyweight×reps sets. The weight of assisted sets is help rather than load and
+// timed sets have no reps, so neither counts
+const tonnageSQL = `COALESCE(SUM(r.weight * r.reps) FILTER (WHERE r.set_type <> '` + SetWarmUp + `'
+	AND e.measurement IN ('` + MeasureWeightReps + `', '` + MeasureBodyweightReps + `')), 0)`
+
+// timedSQL tells whether the sets of user_records r of exercise e are timed
+// rather than counted in reps
+const timedSQL = `e.measurement IN ('` + MeasureDuration + `', '` + MeasureDistanceDuration + `')`
+
+// VolumeGroup is the training volume of an exercise or category in a period.
+// Tonnage is the load lifted, see tonnageSQL. Sets are the sets done for reps and
+// timed sets the duration and distance ones. Frequency is the number of days it
+// was trained
+type VolumeGroup struct {
+	ID        int     `json:"id"`
+	Name      string  `json:"name"`
+	Tonnage   Decimal `json:"tonnage"`
+	Sets      int     `json:"sets"`
+	TimedSets int     `json:"timed_sets"`
+	Frequency int     `json:"frequency"`
+}
+
+// VolumeBucket is the training volume of a period along its split by group
+type VolumeBucket struct {
+	Start     string        `json:"start"`
+	Tonnage   Decimal       `json:"tonnage"`
+	Sets      int           `json:"sets"`
+	TimedSets int           `json:"timed_sets"`
+	Frequency int           `json:"frequency"`
+	Groups    []VolumeGroup `json:"groups"`
+}
+
+// VolumeReport is the training volume of a user between two dates, the days
+// sets were logged on which are already local to the user. Timezone is the zone
+// today was taken in when to was left out
+type VolumeReport struct {
+	Period     string         `json:"period"`
+	Group      string         `json:"group"`
+	From       string         `json:"from"`
+	To         string         `json:"to"`
+	Timezone   string         `json:"timezone"`
+	WeightUnit string         `json:"weight_unit"`
+	Buckets    []VolumeBucket `json:"buckets"`
+}
+
+// GetVolume fills the buckets of report with the working sets of user from
+// report.From to report.To, both included. Sets are bucketed by the day they
+// were logged on, which is already the local day of the user
+func GetVolume(db *sql.DB, userID int, report VolumeReport) (VolumeReport, error) {
+	report.Buckets = []VolumeBucket{}
+	if !Periods[report.Period] {
+		return report, fmt.Errorf("unknown period %s", report.Period)
+	}
+	columns, ok := volumeGroupColumns[report.Group]
+	if !ok {
+		return report, fmt.Errorf("unknown group %s", report.Group)
+	}
+
+	// the bucket totals come first, with a null group
+	bucket := `date_trunc($1::text, r.date_performed::timestamp)`
+	rows, err := db.Query(`SELECT to_char(`+bucket+`, 'YYYY-MM-DD'), `+columns+`,
+		`+tonnageSQL+`, COUNT(*) FILTER (WHERE NOT `+timedSQL+`), COUNT(*) FILTER (WHERE `+timedSQL+`),
+		COUNT(DISTINCT r.date_performed)
+		FROM user_records r
+		JOIN exercise e ON e.id = r.exercise_id
+		JOIN category c ON c.id = e.category_id
+		WHERE r.user_id = $2 AND r.set_type <> $3 AND r.date_performed BETWEEN $4::date AND $5::date
+		GROUP BY GROUPING SETS ((`+bucket+`, `+columns+`), (`+bucket+`))
+		ORDER BY 1, 2 NULLS FIRST`,
+		report.Period, userID, SetWarmUp, report.From, report.To)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var start, kg string
+		var id sql.NullInt64
+		var name sql.NullString
+		var sets, timed, frequency int
+		if err := rows.Scan(&start, &id, &name, &kg, &sets, &timed, &frequency); err != nil {
+			return report, err
+		}
+		tonnage, err := fromKg(kg, report.WeightUnit)
+		if err != nil {
+			return report, err
+		}
+
+		if !id.Valid {
+			report.Buckets = append(report.Buckets, VolumeBucket{
+				Start:     start,
+				Tonnage:   tonnage,
+				Sets:      sets,
+				TimedSets: timed,
+				Frequency: frequency,
+				Groups:    []VolumeGroup{},
+			})
+			continue
+		}
+		last := &report.Buckets[len(report.Buckets)-1]
+		last.Groups = append(last.Groups, VolumeGroup{
+			ID:        int(id.Int64),
+			Name:      name.String,
+			Tonnage:   tonnage,
+			Sets:      sets,
+			TimedSets: timed,
+			Frequency: frequency,
+		})
+	}
+
+	return report, rows.Err()
+}
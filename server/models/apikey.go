@@ -0,0 +1,140 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Scopes an API key can be granted
+const (
+	ScopeRecordsRead  = "records:read"
+	ScopeRecordsWrite = "records:write"
+	ScopeCatalogRead  = "catalog:read"
+)
+
+// Scopes lists every valid API key scope
+var Scopes = map[string]bool{
+	ScopeRecordsRead:  true,
+	ScopeRecordsWrite: true,
+	ScopeCatalogRead:  true,
+}
+
+// APIKeyRequest a struct to read a new API key from the request body
+type APIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// APIKey is the DB response struct from api_keys table,
+// the key itself is only known when it is created
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	UserID     int        `json:"user_id"`
+	Key        string     `json:"key,omitempty"`
+}
+
+// GetAPIKeys gets every active API key of user
+func GetAPIKeys(db *sql.DB, userID int) ([]APIKey, error) {
+	rows, err := db.Query(`SELECT k.id, k.name, k.prefix, k.scopes, k.created_at, k.last_used_at, k.user_id
+		FROM api_keys k
+		WHERE k.user_id = $1 AND k.revoked_at IS NULL
+		ORDER BY k.created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var key APIKey
+		err := rows.Scan(
+			&key.ID,
+			&key.Name,
+			&key.Prefix,
+			pq.Array(&key.Scopes),
+			&key.CreatedAt,
+			&key.LastUsedAt,
+			&key.UserID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// CreateAPIKey creates new API key stored by hash
+func CreateAPIKey(db *sql.DB, k APIKey, hash string) (APIKey, error) {
+	var key APIKey
+	err := db.QueryRow(`INSERT INTO api_keys(name, prefix, key_hash, scopes, user_id)
+		VALUES
+		($1, $2, $3, $4, $5)
+		RETURNING id, name, prefix, scopes, created_at, last_used_at, user_id`,
+		k.Name, k.Prefix, hash, pq.Array(k.Scopes), k.UserID,
+	).Scan(
+		&key.ID,
+		&key.Name,
+		&key.Prefix,
+		pq.Array(&key.Scopes),
+		&key.CreatedAt,
+		&key.LastUsedAt,
+		&key.UserID,
+	)
+
+	if err != nil {
+		return key, err
+	}
+
+	return key, nil
+}
+
+// RevokeAPIKey revokes API key by ID
+func RevokeAPIKey(db *sql.DB, userID int, id int) (int, error) {
+	res, err := db.Exec(`UPDATE api_keys
+		SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`, id, userID)
+	if err != nil {
+		return 0, err
+	}
+	count, err := res.RowsAffected()
+	return int(count), err
+}
+
+// GetAPIKeyUser gets an active API key by hash along its owner
+func GetAPIKeyUser(db *sql.DB, hash string) (APIKey, UserResponse, error) {
+	var key APIKey
+	var user UserResponse
+	err := db.QueryRow(`SELECT k.id, k.scopes, u.id, u.username, u.email, u.verified_at IS NOT NULL, u.role
+		FROM api_keys k
+		JOIN users u ON u.id = k.user_id
+		WHERE k.key_hash = $1 AND k.revoked_at IS NULL AND u.deleted_at IS NULL`, hash,
+	).Scan(
+		&key.ID,
+		pq.Array(&key.Scopes),
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.Verified,
+		&user.Role,
+	)
+	key.UserID = user.ID
+
+	return key, user, err
+}
+
+// TouchAPIKey updates API key last used time, at most once a minute
+func TouchAPIKey(db *sql.DB, id int) error {
+	_, err := db.Exec(`UPDATE api_keys
+		SET last_used_at = NOW()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`, id)
+	return err
+}
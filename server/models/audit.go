@@ -0,0 +1,55 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Audit events
+const (
+	AuditLoginLockout = "login_lockout"
+	AuditLoginUnlock  = "login_unlock"
+)
+
+// AuditEntry is the DB response struct from audit_log table,
+// UserID is 0 for events not tied to an account
+type AuditEntry struct {
+	ID        int       `json:"id"`
+	Event     string    `json:"event"`
+	IP        string    `json:"ip"`
+	Detail    string    `json:"detail"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    int       `json:"user_id"`
+}
+
+// CreateAuditEntry creates new audit entry
+func CreateAuditEntry(db *sql.DB, e AuditEntry) error {
+	userID := sql.NullInt64{Int64: int64(e.UserID), Valid: e.UserID != 0}
+	_, err := db.Exec(`INSERT INTO audit_log(event, ip, detail, user_id)
+		VALUES
+		($1, $2, $3, $4)`, e.Event, e.IP, e.Detail, userID)
+	return err
+}
+
+// GetAuditEntries gets every audit entry of user, oldest first
+func GetAuditEntries(db *sql.DB, userID int) ([]AuditEntry, error) {
+	rows, err := db.Query(`SELECT a.id, a.event, a.ip, a.detail, a.created_at, a.user_id
+		FROM audit_log a
+		WHERE a.user_id = $1
+		ORDER BY a.created_at, a.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.ID, &e.Event, &e.IP, &e.Detail, &e.CreatedAt, &e.UserID); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
@@ -7,6 +7,13 @@ import (
 	jwt "github.com/dgrijalva/jwt-go"
 )
 
+// Roles a user can have, each role is granted everything the previous one is
+const (
+	RoleUser  = "user"
+	RoleCoach = "coach"
+	RoleAdmin = "admin"
+)
+
 // Credentials a struct to read the username and password from the request body
 type Credentials struct {
 	Password string `json:"password"`
@@ -14,12 +21,30 @@ type Credentials struct {
 	Email    string `json:"email"`
 }
 
+// RefreshRequest a struct to read the refresh token from the request body
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// PasswordForgotRequest a struct to read the email asking for a password reset
+type PasswordForgotRequest struct {
+	Email string `json:"email"`
+}
+
+// PasswordResetRequest a struct to read the reset token and new password
+type PasswordResetRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
 // User response from database
 type User struct {
 	ID       int    `json:"id"`
 	Username string `json:"username"`
 	Password string `json:"password"`
 	Email    string `json:"email"`
+	Verified bool   `json:"verified"`
+	Role     string `json:"role"`
 }
 
 // UserResponse for login
@@ -27,14 +52,27 @@ type UserResponse struct {
 	ID       int    `json:"id"`
 	Username string `json:"username"`
 	Email    string `json:"email"`
+	Verified bool   `json:"verified"`
+	Role     string `json:"role"`
 }
 
 // Claims a struct that will be encoded to a JWT.
 // We add jwt.StandardClaims as an embedded type, to provide fields like expiry time
+// and the jti claim (Id) which holds the ID of the session the token belongs to
 type Claims struct {
 	Username string `json:"username"`
 	ID       int    `json:"id"`
 	Email    string `json:"email"`
+	Verified bool   `json:"verified"`
+	Role     string `json:"role"`
+	jwt.StandardClaims
+}
+
+// VerifyClaims a struct encoded in email verification links,
+// the email is kept so links stop working once the email changes
+type VerifyClaims struct {
+	ID    int    `json:"id"`
+	Email string `json:"email"`
 	jwt.StandardClaims
 }
 
@@ -48,8 +86,32 @@ type JWTResponse struct {
 func GetByUsername(db *sql.DB, username string) (User, error) {
 	var user User
 	err := db.QueryRow(
-		`SELECT u.id, u.username, u.password, u.email FROM users u WHERE username = $1`,
-		username).Scan(&user.ID, &user.Username, &user.Password, &user.Email)
+		`SELECT u.id, u.username, u.password, u.email, u.verified_at IS NOT NULL, u.role FROM users u WHERE username = $1 AND u.deleted_at IS NULL`,
+		username).Scan(&user.ID, &user.Username, &user.Password, &user.Email, &user.Verified, &user.Role)
+	if err != nil {
+		return user, err
+	}
+	return user, nil
+}
+
+// GetByEmail gets User by email
+func GetByEmail(db *sql.DB, email string) (User, error) {
+	var user User
+	err := db.QueryRow(
+		`SELECT u.id, u.username, u.password, u.email, u.verified_at IS NOT NULL, u.role FROM users u WHERE LOWER(email) = LOWER($1) AND u.deleted_at IS NULL`,
+		email).Scan(&user.ID, &user.Username, &user.Password, &user.Email, &user.Verified, &user.Role)
+	if err != nil {
+		return user, err
+	}
+	return user, nil
+}
+
+// GetUserByID gets User by ID
+func GetUserByID(db *sql.DB, id int) (UserResponse, error) {
+	var user UserResponse
+	err := db.QueryRow(
+		`SELECT u.id, u.username, u.email, u.verified_at IS NOT NULL, u.role FROM users u WHERE id = $1 AND u.deleted_at IS NULL`,
+		id).Scan(&user.ID, &user.Username, &user.Email, &user.Verified, &user.Role)
 	if err != nil {
 		return user, err
 	}
@@ -62,9 +124,49 @@ func CreateUser(db *sql.DB, username string, hash string, email string) (UserRes
 	err := db.QueryRow(`INSERT INTO users(username, password, email)
 		VALUES
 		($1, $2, $3)
-		RETURNING id, username, email`, username, hash, email).Scan(&user.ID, &user.Username, &user.Email)
+		RETURNING id, username, email, role`, username, hash, email).Scan(&user.ID, &user.Username, &user.Email, &user.Role)
 	if err != nil {
 		return user, err
 	}
 	return user, nil
 }
+
+// UpdatePassword sets a new password hash for user
+func UpdatePassword(db *sql.DB, id int, hash string) error {
+	res, err := db.Exec(`UPDATE users SET password = $1 WHERE id = $2`, hash, id)
+	if err != nil {
+		return err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// VerifyUser marks user email as verified if it still matches email
+func VerifyUser(db *sql.DB, id int, email string) error {
+	res, err := db.Exec(`UPDATE users
+		SET verified_at = COALESCE(verified_at, NOW())
+		WHERE id = $1 AND email = $2 AND deleted_at IS NULL`, id, email)
+	if err != nil {
+		return err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SetUserRole changes role of user
+func SetUserRole(db *sql.DB, id int, role string) error {
+	_, err := db.Exec(`UPDATE users SET role = $1 WHERE id = $2`, role, id)
+	return err
+}
@@ -0,0 +1,219 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+// CompletedDay is the training a user logged on a day. Tonnage is the load
+// lifted by its working sets, see tonnageSQL
+type CompletedDay struct {
+	Sets       int     `json:"sets"`
+	Exercises  int     `json:"exercises"`
+	Tonnage    Decimal `json:"tonnage"`
+	WeightUnit string  `json:"weight_unit"`
+	WorkoutIDs []int   `json:"workout_ids"`
+}
+
+// CalendarDay is a day with training, completed is null when nothing was logged
+// and planned lists the workouts programs scheduled on it
+type CalendarDay struct {
+	Date      string             `json:"date"`
+	Completed *CompletedDay      `json:"completed"`
+	Planned   []ScheduledWorkout `json:"planned"`
+}
+
+// Calendar is the training of a user between two dates, both included
+type Calendar struct {
+	From       string        `json:"from"`
+	To         string        `json:"to"`
+	WeightUnit string        `json:"weight_unit"`
+	Days       []CalendarDay `json:"days"`
+}
+
+// getCompletedDays gets the days user logged sets on from from to to, by date
+func getCompletedDays(db *sql.DB, userID int, from string, to string, unit string) (map[string]*CompletedDay, error) {
+	rows, err := db.Query(`SELECT to_char(r.date_performed, 'YYYY-MM-DD'), COUNT(*), COUNT(DISTINCT r.exercise_id),
+		`+tonnageSQL+`::text,
+		COALESCE(ARRAY_AGG(DISTINCT we.workout_id) FILTER (WHERE we.workout_id IS NOT NULL), '{}')
+		FROM user_records r
+		JOIN exercise e ON e.id = r.exercise_id
+		LEFT JOIN workout_exercises we ON we.id = r.workout_exercise_id
+		WHERE r.user_id = $1 AND r.date_performed BETWEEN $2::date AND $3::date
+		GROUP BY r.date_performed`, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	days := map[string]*CompletedDay{}
+	for rows.Next() {
+		var date, kg string
+		var workoutIDs []int64
+		day := CompletedDay{WeightUnit: unit, WorkoutIDs: []int{}}
+		err := rows.Scan(&date, &day.Sets, &day.Exercises, &kg, pq.Array(&workoutIDs))
+		if err != nil {
+			return nil, err
+		}
+		if day.Tonnage, err = fromKg(kg, unit); err != nil {
+			return nil, err
+		}
+		for _, id := range workoutIDs {
+			day.WorkoutIDs = append(day.WorkoutIDs, int(id))
+		}
+		days[date] = &day
+	}
+
+	return days, rows.Err()
+}
+
+// GetCalendar fills the days of cal with the days user logged sets on and the
+// workouts scheduled by their programs from cal.From to cal.To. Days without
+// either are left out
+func GetCalendar(db *sql.DB, userID int, cal Calendar) (Calendar, error) {
+	cal.Days = []CalendarDay{}
+
+	completed, err := getCompletedDays(db, userID, cal.From, cal.To, cal.WeightUnit)
+	if err != nil {
+		return cal, err
+	}
+	planned, err := GetScheduledWorkouts(db, userID, ScheduleFilter{From: cal.From, To: cal.To}, cal.WeightUnit)
+	if err != nil {
+		return cal, err
+	}
+
+	byDate := map[string]*CalendarDay{}
+	day := func(date string) *CalendarDay {
+		if byDate[date] == nil {
+			byDate[date] = &CalendarDay{Date: date, Planned: []ScheduledWorkout{}}
+		}
+		return byDate[date]
+	}
+	for date, c := range completed {
+		day(date).Completed = c
+	}
+	for _, sw := range planned {
+		d := day(sw.ScheduledOn)
+		d.Planned = append(d.Planned, sw)
+	}
+
+	for _, d := range byDate {
+		cal.Days = append(cal.Days, *d)
+	}
+	sort.Slice(cal.Days, func(i, j int) bool { return cal.Days[i].Date < cal.Days[j].Date })
+
+	return cal, nil
+}
+
+// SetCalendarToken sets the hash of the calendar feed token of user, replacing
+// the previous one
+func SetCalendarToken(db *sql.DB, userID int, hash string) error {
+	_, err := db.Exec(`INSERT INTO calendar_tokens(token_hash, user_id)
+		VALUES
+		($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET token_hash = EXCLUDED.token_hash, created_at = NOW()`, hash, userID)
+	return err
+}
+
+// RevokeCalendarToken deletes the calendar feed token of user
+func RevokeCalendarToken(db *sql.DB, userID int) (int, error) {
+	res, err := db.Exec(`DELETE FROM calendar_tokens WHERE user_id = $1`, userID)
+	if err != nil {
+		return 0, err
+	}
+	count, err := res.RowsAffected()
+	return int(count), err
+}
+
+// GetCalendarTokenUser gets the ID of the user a calendar feed token hash belongs to
+func GetCalendarTokenUser(db *sql.DB, hash string) (int, error) {
+	var userID int
+	err := db.QueryRow(`SELECT u.id FROM calendar_tokens ct
+		JOIN users u ON u.id = ct.user_id
+		WHERE ct.token_hash = $1 AND u.deleted_at IS NULL`, hash).Scan(&userID)
+	return userID, err
+}
+
+// icsLineLength is the most octets an iCalendar line can have before folding
+const icsLineLength = 75
+
+// icsEscaper escapes iCalendar text values
+var icsEscaper = strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// icsLine folds an iCalendar content line to lines of at most 75 octets, without
+// splitting UTF-8 characters, continuation lines start with a space
+func icsLine(line string) string {
+	var b strings.Builder
+	limit := icsLineLength
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		limit = icsLineLength - 1
+	}
+	b.WriteString(line + "\r\n")
+	return b.String()
+}
+
+// icsDescription lists the prescriptions of a scheduled workout, one per line
+func icsDescription(sw ScheduledWorkout) string {
+	lines := []string{}
+	for _, p := range sw.Exercises {
+		reps := fmt.Sprint(p.Reps)
+		if p.AMRAP {
+			reps += "+"
+		}
+		lines = append(lines, fmt.Sprintf("%s %dx%s @ %s %s", p.ExerciseName, p.Sets, reps, p.Weight, p.WeightUnit))
+	}
+	if sw.CompletedAt != nil {
+		lines = append(lines, "Completed")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// WriteICS writes scheduled workouts as an iCalendar feed of all day events,
+// stamped with now
+func WriteICS(w io.Writer, workouts []ScheduledWorkout, now time.Time) error {
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//shinpo//training calendar//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:Training",
+	}
+	for _, sw := range workouts {
+		start, err := time.Parse("2006-01-02", sw.ScheduledOn)
+		if err != nil {
+			return err
+		}
+		lines = append(lines,
+			"BEGIN:VEVENT",
+			fmt.Sprintf("UID:scheduled-workout-%d@shinpo", sw.ID),
+			"DTSTAMP:"+now.UTC().Format("20060102T150405Z"),
+			"DTSTART;VALUE=DATE:"+start.Format("20060102"),
+			"DTEND;VALUE=DATE:"+start.AddDate(0, 0, 1).Format("20060102"),
+			"SUMMARY:"+icsEscaper.Replace(fmt.Sprintf("%s - cycle %d week %d day %d", sw.ProgramName, sw.Cycle, sw.Week, sw.Day)),
+			"DESCRIPTION:"+icsEscaper.Replace(icsDescription(sw)),
+			"END:VEVENT",
+		)
+	}
+	lines = append(lines, "END:VCALENDAR")
+
+	for _, line := range lines {
+		if _, err := io.WriteString(w, icsLine(line)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestICSLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"short", "SUMMARY:Squat", "SUMMARY:Squat\r\n"},
+		{"75 octets", strings.Repeat("a", 75), strings.Repeat("a", 75) + "\r\n"},
+		{"76 octets", strings.Repeat("a", 76), strings.Repeat("a", 75) + "\r\n a\r\n"},
+		{"continuation lines", strings.Repeat("a", 75+74+1), strings.Repeat("a", 75) + "\r\n " + strings.Repeat("a", 74) + "\r\n a\r\n"},
+		// the 75th octet is the first of a 2 octet character, moved to the next line
+		{"multibyte", "SUMMARY:" + strings.Repeat("é", 40), "SUMMARY:" + strings.Repeat("é", 33) + "\r\n " + strings.Repeat("é", 7) + "\r\n"},
+	}
+	for _, tt := range tests {
+		got := icsLine(tt.line)
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+		for _, l := range strings.Split(strings.TrimSuffix(got, "\r\n"), "\r\n") {
+			if len(l) > icsLineLength || !utf8.ValidString(l) {
+				t.Errorf("%s: invalid folded line %q", tt.name, l)
+			}
+		}
+		if unfolded := strings.ReplaceAll(strings.TrimSuffix(got, "\r\n"), "\r\n ", ""); unfolded != tt.line {
+			t.Errorf("%s: unfolds to %q", tt.name, unfolded)
+		}
+	}
+}
+
+func TestICSEscaper(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Squat", "Squat"},
+		{"3x5, 100kg; RPE 8", `3x5\, 100kg\; RPE 8`},
+		{`C:\lifts`, `C:\\lifts`},
+		{"Squat\nBench\r\nDeadlift", `Squat\nBench\nDeadlift`},
+		{`\,`, `\\\,`},
+	}
+	for _, tt := range tests {
+		if got := icsEscaper.Replace(tt.text); got != tt.want {
+			t.Errorf("icsEscaper.Replace(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
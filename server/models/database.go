@@ -24,22 +24,32 @@ var exercises = map[string]int{
 	"deadlift": 2,
 }
 
+// measuredExercises are seeded after exercises so the IDs user entries point to don't change
+var measuredExercises = []Exercise{
+	{Name: "pull up", CategoryID: 1, Measurement: MeasureBodyweightReps},
+	{Name: "plank", CategoryID: 1, Measurement: MeasureDuration},
+	{Name: "run", CategoryID: 5, Measurement: MeasureDistanceDuration},
+	{Name: "row", CategoryID: 5, Measurement: MeasureDistanceDuration},
+}
+
+var seedRPE = Decimal(10 * decimalScale)
+
 var userEntries = []Record{
-	{ID: 0, Weight: 225, Reps: 10, RPE: 10, DatePerformed: "2019/05/28", ExerciseID: 2, UserID: 1},
-	{ID: 0, Weight: 235, Reps: 10, RPE: 10, DatePerformed: "2019/05/29", ExerciseID: 2, UserID: 1},
-	{ID: 0, Weight: 215, Reps: 10, RPE: 10, DatePerformed: "2019/05/30", ExerciseID: 2, UserID: 1},
-	{ID: 0, Weight: 255, Reps: 10, RPE: 10, DatePerformed: "2019/05/31", ExerciseID: 2, UserID: 1},
-	{ID: 0, Weight: 265, Reps: 10, RPE: 10, DatePerformed: "2019/06/01", ExerciseID: 2, UserID: 1},
-	{ID: 0, Weight: 325, Reps: 10, RPE: 10, DatePerformed: "2019/05/28", ExerciseID: 1, UserID: 1},
-	{ID: 0, Weight: 335, Reps: 10, RPE: 10, DatePerformed: "2019/05/29", ExerciseID: 1, UserID: 1},
-	{ID: 0, Weight: 315, Reps: 10, RPE: 10, DatePerformed: "2019/05/30", ExerciseID: 1, UserID: 1},
-	{ID: 0, Weight: 355, Reps: 10, RPE: 10, DatePerformed: "2019/05/31", ExerciseID: 1, UserID: 1},
-	{ID: 0, Weight: 365, Reps: 10, RPE: 10, DatePerformed: "2019/06/01", ExerciseID: 1, UserID: 1},
-	{ID: 0, Weight: 425, Reps: 10, RPE: 10, DatePerformed: "2019/05/28", ExerciseID: 3, UserID: 1},
-	{ID: 0, Weight: 435, Reps: 10, RPE: 10, DatePerformed: "2019/05/29", ExerciseID: 3, UserID: 1},
-	{ID: 0, Weight: 415, Reps: 10, RPE: 10, DatePerformed: "2019/05/30", ExerciseID: 3, UserID: 1},
-	{ID: 0, Weight: 455, Reps: 10, RPE: 10, DatePerformed: "2019/05/31", ExerciseID: 3, UserID: 1},
-	{ID: 0, Weight: 465, Reps: 10, RPE: 10, DatePerformed: "2019/06/01", ExerciseID: 3, UserID: 1},
+	{ID: 0, Weight: 225 * decimalScale, WeightUnit: UnitLb, Reps: 10, RPE: &seedRPE, DatePerformed: "2019/05/28", ExerciseID: 2, UserID: 1},
+	{ID: 0, Weight: 235 * decimalScale, WeightUnit: UnitLb, Reps: 10, RPE: &seedRPE, DatePerformed: "2019/05/29", ExerciseID: 2, UserID: 1},
+	{ID: 0, Weight: 215 * decimalScale, WeightUnit: UnitLb, Reps: 10, RPE: &seedRPE, DatePerformed: "2019/05/30", ExerciseID: 2, UserID: 1},
+	{ID: 0, Weight: 255 * decimalScale, WeightUnit: UnitLb, Reps: 10, RPE: &seedRPE, DatePerformed: "2019/05/31", ExerciseID: 2, UserID: 1},
+	{ID: 0, Weight: 265 * decimalScale, WeightUnit: UnitLb, Reps: 10, RPE: &seedRPE, DatePerformed: "2019/06/01", ExerciseID: 2, UserID: 1},
+	{ID: 0, Weight: 325 * decimalScale, WeightUnit: UnitLb, Reps: 10, RPE: &seedRPE, DatePerformed: "2019/05/28", ExerciseID: 1, UserID: 1},
+	{ID: 0, Weight: 335 * decimalScale, WeightUnit: UnitLb, Reps: 10, RPE: &seedRPE, DatePerformed: "2019/05/29", ExerciseID: 1, UserID: 1},
+	{ID: 0, Weight: 315 * decimalScale, WeightUnit: UnitLb, Reps: 10, RPE: &seedRPE, DatePerformed: "2019/05/30", ExerciseID: 1, UserID: 1},
+	{ID: 0, Weight: 355 * decimalScale, WeightUnit: UnitLb, Reps: 10, RPE: &seedRPE, DatePerformed: "2019/05/31", ExerciseID: 1, UserID: 1},
+	{ID: 0, Weight: 365 * decimalScale, WeightUnit: UnitLb, Reps: 10, RPE: &seedRPE, DatePerformed: "2019/06/01", ExerciseID: 1, UserID: 1},
+	{ID: 0, Weight: 425 * decimalScale, WeightUnit: UnitLb, Reps: 10, RPE: &seedRPE, DatePerformed: "2019/05/28", ExerciseID: 3, UserID: 1},
+	{ID: 0, Weight: 435 * decimalScale, WeightUnit: UnitLb, Reps: 10, RPE: &seedRPE, DatePerformed: "2019/05/29", ExerciseID: 3, UserID: 1},
+	{ID: 0, Weight: 415 * decimalScale, WeightUnit: UnitLb, Reps: 10, RPE: &seedRPE, DatePerformed: "2019/05/30", ExerciseID: 3, UserID: 1},
+	{ID: 0, Weight: 455 * decimalScale, WeightUnit: UnitLb, Reps: 10, RPE: &seedRPE, DatePerformed: "2019/05/31", ExerciseID: 3, UserID: 1},
+	{ID: 0, Weight: 465 * decimalScale, WeightUnit: UnitLb, Reps: 10, RPE: &seedRPE, DatePerformed: "2019/06/01", ExerciseID: 3, UserID: 1},
 }
 
 // RunMigrations runs migrations on database
@@ -70,17 +80,27 @@ func RunDBSeeds(db *sql.DB) {
 	userEntrySeeds(db)
 }
 
-// userSeeds seeds default user
+// userSeeds seeds default admin user
 func userSeeds(db *sql.DB) {
 	hash, err := bcrypt.GenerateFromPassword([]byte("pass"), 10)
 	if err != nil {
 		log.Fatal("error hasing seed password")
 	}
 
-	_, err = CreateUser(db, "rey", string(hash), "email@rey.sh")
+	user, err := CreateUser(db, "rey", string(hash), "email@rey.sh")
 	if err != nil {
 		log.Fatal("error seeding user:" + err.Error())
 	}
+
+	err = SetUserRole(db, user.ID, RoleAdmin)
+	if err != nil {
+		log.Fatal("error seeding user role:" + err.Error())
+	}
+
+	err = UpdateWeightUnit(db, user.ID, UnitLb)
+	if err != nil {
+		log.Fatal("error seeding user weight unit:" + err.Error())
+	}
 }
 
 // categorySeeds seeds default categories
@@ -98,7 +118,15 @@ func categorySeeds(db *sql.DB) {
 // exerciseSeeds seeds default exercises
 func exerciseSeeds(db *sql.DB) {
 	for exercise, categoryID := range exercises {
-		exer, err := CreateExercise(db, exercise, categoryID)
+		exer, err := CreateExercise(db, exercise, categoryID, MeasureWeightReps)
+		if err != nil {
+			fmt.Println(err)
+		} else {
+			fmt.Printf("CREATED EXERCISE ID:%d, NAME:%s\n", exer.ID, exer.Name)
+		}
+	}
+	for _, exercise := range measuredExercises {
+		exer, err := CreateExercise(db, exercise.Name, exercise.CategoryID, exercise.Measurement)
 		if err != nil {
 			fmt.Println(err)
 		} else {
@@ -0,0 +1,34 @@
+package models
+
+import "errors"
+
+// maxRPE is the hardest possible set, with no reps left in reserve
+const maxRPE = 10 * decimalScale
+
+// effortStep is the smallest RPE and RIR increment
+const effortStep = decimalScale / 2
+
+// ValidateEffort checks RPE goes from 1 to 10 and RIR from 0 to 9 in steps of 0.5,
+// either or both can be left out
+func ValidateEffort(rpe *Decimal, rir *Decimal) error {
+	if rpe != nil && (*rpe < decimalScale || *rpe > maxRPE || *rpe%effortStep != 0) {
+		return errors.New("rpe must be between 1 and 10 in steps of 0.5")
+	}
+	if rir != nil && (*rir < 0 || *rir > maxRPE-decimalScale || *rir%effortStep != 0) {
+		return errors.New("rir must be between 0 and 9 in steps of 0.5")
+	}
+	return nil
+}
+
+// fillEffort derives RPE from RIR or RIR from RPE when only one of them was logged,
+// an RPE of 8 means 2 reps were left in reserve
+func fillEffort(rpe **Decimal, rir **Decimal) {
+	if *rpe == nil && *rir != nil {
+		v := maxRPE - **rir
+		*rpe = &v
+	}
+	if *rir == nil && *rpe != nil {
+		v := maxRPE - **rpe
+		*rir = &v
+	}
+}
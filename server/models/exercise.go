@@ -1,6 +1,28 @@
 package models
 
-import "database/sql"
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Errors of catalog deletions, rows in use would cascade to user data
+var (
+	ErrCategoryInUse = errors.New("category still has exercises")
+	ErrExerciseInUse = errors.New("exercise is still used by records, workouts, templates or programs")
+)
+
+// exerciseReferences find a row using the exercise $1
+var exerciseReferences = []string{
+	`SELECT 1 FROM user_records WHERE exercise_id = $1`,
+	`SELECT 1 FROM workout_exercises WHERE exercise_id = $1`,
+	`SELECT 1 FROM personal_records WHERE exercise_id = $1`,
+	`SELECT 1 FROM template_exercises WHERE exercise_id = $1`,
+	`SELECT 1 FROM program_exercises WHERE exercise_id = $1`,
+	`SELECT 1 FROM program_rules WHERE exercise_id = $1`,
+	`SELECT 1 FROM training_maxes WHERE exercise_id = $1`,
+	`SELECT 1 FROM program_results WHERE exercise_id = $1`,
+}
 
 // Category is the DB response struct from category table
 type Category struct {
@@ -8,50 +30,94 @@ type Category struct {
 	Name string `json:"name"`
 }
 
-// Exercise is the DB response struct from exercise table
+// Exercise is the DB response struct from exercise table,
+// its measurement tells which fields its records carry
 type Exercise struct {
-	ID         int    `json:"id"`
-	Name       string `json:"name"`
-	CategoryID int    `json:"category_id"`
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	CategoryID  int    `json:"category_id"`
+	Measurement string `json:"measurement"`
 }
 
-// Record is the DB response struct from user_records table
+// Record is the DB response struct from user_records table,
+// each record is one set of an exercise in a workout. Duration is in seconds
+// and distance in meters
 type Record struct {
-	ID            int    `json:"id"`
-	Weight        int    `json:"weight"`
-	Reps          int    `json:"reps"`
-	RPE           int    `json:"rpe"`
-	DatePerformed string `json:"date_performed"`
-	ExerciseID    int    `json:"exercise_id"`
-	UserID        int    `json:"user_id"`
+	ID                int      `json:"id"`
+	Weight            Decimal  `json:"weight"`
+	WeightUnit        string   `json:"weight_unit"`
+	Reps              int      `json:"reps"`
+	RPE               *Decimal `json:"rpe"`
+	RIR               *Decimal `json:"rir"`
+	Duration          int      `json:"duration"`
+	Distance          Decimal  `json:"distance"`
+	SetType           string   `json:"set_type"`
+	DatePerformed     string   `json:"date_performed"`
+	ExerciseID        int      `json:"exercise_id"`
+	WorkoutID         int      `json:"workout_id"`
+	WorkoutExerciseID int      `json:"workout_exercise_id"`
+	UserID            int      `json:"user_id"`
 }
 
 //////////////////
 //// CATEGORY ////
 //////////////////
 
-// GetAllCategories gets all categories
-func GetAllCategories(db *sql.DB) ([]Category, error) {
-	rows, err := db.Query(`SELECT c.id, c.name FROM category c`)
+// CategoryFilter filters a category listing, name matches part of the name
+type CategoryFilter struct {
+	Name string
+}
+
+// categorySortKeys are the keys categories can be sorted by
+var categorySortKeys = map[string]sortKey{
+	"id":   {"c.id", "integer"},
+	"name": {"c.name", "text"},
+}
+
+// GetAllCategories gets a page of categories
+func GetAllCategories(db *sql.DB, filter CategoryFilter, opts ListOptions) ([]Category, *string, error) {
+	where := []string{}
+	args := []interface{}{}
+	if filter.Name != "" {
+		args = append(args, filter.Name)
+		where = append(where, fmt.Sprintf("c.name ILIKE '%%' || $%d || '%%'", len(args)))
+	}
+
+	query, args, err := listQuery(`c.id, c.name`, `category c`, where, args, "c.id", categorySortKeys, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows, err := db.Query(query, args...)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	defer rows.Close()
 
-	var categories []Category
+	categories := []Category{}
+	keys := []string{}
 	for rows.Next() {
 		var category Category
-		err := rows.Scan(
+		row := &keyedRow{row: rows}
+		err := row.Scan(
 			&category.ID,
 			&category.Name,
 		)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		categories = append(categories, category)
+		keys = append(keys, row.key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
 	}
 
-	return categories, nil
-
+	if len(categories) <= opts.Limit {
+		return categories, nil, nil
+	}
+	categories = categories[:opts.Limit]
+	last := categories[opts.Limit-1]
+	return categories, nextCursor(opts, keys[opts.Limit-1], last.ID), nil
 }
 
 // GetCategory gets category by ID
@@ -98,52 +164,89 @@ func EditCategory(db *sql.DB, c Category) (Category, error) {
 	return category, nil
 }
 
-// DeleteCategory deletes category
+// DeleteCategory deletes category, returns ErrCategoryInUse while it has exercises
 func DeleteCategory(db *sql.DB, id int) (int, error) {
-	var count = 0
-	rows, err := db.Query(`DELETE FROM category WHERE id = $1 RETURNING *`, id)
-	if err != nil {
-		return 0, err
-	}
-	for rows.Next() {
-		count++
-	}
-	return count, nil
+	return deleteCatalogRow(db, `category`, id, ErrCategoryInUse, `SELECT 1 FROM exercise WHERE category_id = $1`)
 }
 
 //////////////////
 //// EXERCISE ////
 //////////////////
 
-// GetAllExercises gets all exercises
-func GetAllExercises(db *sql.DB) ([]Exercise, error) {
-	rows, err := db.Query(`SELECT e.id, e.name, e.category_id FROM exercise e`)
+// ExerciseFilter filters an exercise listing, name matches part of the name
+type ExerciseFilter struct {
+	CategoryID  int
+	Measurement string
+	Name        string
+}
+
+// exerciseSortKeys are the keys exercises can be sorted by
+var exerciseSortKeys = map[string]sortKey{
+	"id":   {"e.id", "integer"},
+	"name": {"e.name", "text"},
+}
+
+// GetAllExercises gets a page of exercises
+func GetAllExercises(db *sql.DB, filter ExerciseFilter, opts ListOptions) ([]Exercise, *string, error) {
+	where := []string{}
+	args := []interface{}{}
+	if filter.CategoryID != 0 {
+		args = append(args, filter.CategoryID)
+		where = append(where, fmt.Sprintf("e.category_id = $%d", len(args)))
+	}
+	if filter.Measurement != "" {
+		args = append(args, filter.Measurement)
+		where = append(where, fmt.Sprintf("e.measurement = $%d", len(args)))
+	}
+	if filter.Name != "" {
+		args = append(args, filter.Name)
+		where = append(where, fmt.Sprintf("e.name ILIKE '%%' || $%d || '%%'", len(args)))
+	}
+
+	query, args, err := listQuery(`e.id, e.name, e.category_id, e.measurement`, `exercise e`, where, args, "e.id", exerciseSortKeys, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows, err := db.Query(query, args...)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	defer rows.Close()
 
-	var exercises []Exercise
+	exercises := []Exercise{}
+	keys := []string{}
 	for rows.Next() {
 		var exercise Exercise
-		err := rows.Scan(
+		row := &keyedRow{row: rows}
+		err := row.Scan(
 			&exercise.ID,
 			&exercise.Name,
 			&exercise.CategoryID,
+			&exercise.Measurement,
 		)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		exercises = append(exercises, exercise)
+		keys = append(keys, row.key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
 	}
 
-	return exercises, nil
+	if len(exercises) <= opts.Limit {
+		return exercises, nil, nil
+	}
+	exercises = exercises[:opts.Limit]
+	last := exercises[opts.Limit-1]
+	return exercises, nextCursor(opts, keys[opts.Limit-1], last.ID), nil
 }
 
 // GetExercise gets exercise by ID
 func GetExercise(db *sql.DB, id int) (Exercise, error) {
 	var exercise Exercise
-	err := db.QueryRow(`SELECT e.id, e.name, e.category_id FROM exercise e WHERE id = ($1)`,
-		id).Scan(&exercise.ID, &exercise.Name, &exercise.CategoryID)
+	err := db.QueryRow(`SELECT e.id, e.name, e.category_id, e.measurement FROM exercise e WHERE id = ($1)`,
+		id).Scan(&exercise.ID, &exercise.Name, &exercise.CategoryID, &exercise.Measurement)
 
 	if err != nil {
 		return exercise, err
@@ -153,12 +256,12 @@ func GetExercise(db *sql.DB, id int) (Exercise, error) {
 }
 
 // CreateExercise creates a new exercise
-func CreateExercise(db *sql.DB, name string, categoryID int) (Exercise, error) {
+func CreateExercise(db *sql.DB, name string, categoryID int, measurement string) (Exercise, error) {
 	var exercise Exercise
-	err := db.QueryRow(`INSERT INTO exercise(name, category_id)
+	err := db.QueryRow(`INSERT INTO exercise(name, category_id, measurement)
 		VALUES
-		(UPPER($1), $2)
-		RETURNING id, name, category_id`, name, categoryID).Scan(&exercise.ID, &exercise.Name, &exercise.CategoryID)
+		(UPPER($1), $2, $3)
+		RETURNING id, name, category_id, measurement`, name, categoryID, measurement).Scan(&exercise.ID, &exercise.Name, &exercise.CategoryID, &exercise.Measurement)
 
 	if err != nil {
 		return exercise, err
@@ -167,13 +270,13 @@ func CreateExercise(db *sql.DB, name string, categoryID int) (Exercise, error) {
 	return exercise, nil
 }
 
-// EditExercise edits categoru by ID
+// EditExercise edits categoru by ID, an empty measurement is left unchanged
 func EditExercise(db *sql.DB, e Exercise) (Exercise, error) {
 	var exercise Exercise
 	err := db.QueryRow(`UPDATE exercise
-		SET name = UPPER($1), category_id = $2
-		WHERE id = $3
-		RETURNING id, name, category_id`, e.Name, e.CategoryID, e.ID).Scan(&exercise.ID, &exercise.Name, &exercise.CategoryID)
+		SET name = UPPER($1), category_id = $2, measurement = COALESCE(NULLIF($3, ''), measurement)
+		WHERE id = $4
+		RETURNING id, name, category_id, measurement`, e.Name, e.CategoryID, e.Measurement, e.ID).Scan(&exercise.ID, &exercise.Name, &exercise.CategoryID, &exercise.Measurement)
 
 	if err != nil {
 		return exercise, err
@@ -182,131 +285,318 @@ func EditExercise(db *sql.DB, e Exercise) (Exercise, error) {
 	return exercise, nil
 }
 
-// DeleteExercise deletes exercise by ID
+// DeleteExercise deletes exercise by ID, returns ErrExerciseInUse while anything
+// references it
 func DeleteExercise(db *sql.DB, id int) (int, error) {
-	var count = 0
-	rows, err := db.Query(`DELETE FROM exercise WHERE id = $1 RETURNING *`, id)
+	return deleteCatalogRow(db, `exercise`, id, ErrExerciseInUse, exerciseReferences...)
+}
+
+// deleteCatalogRow deletes the row id of a catalog table unless one of the
+// references queries finds a row using it. The row is locked first, so no
+// reference can be added while checking
+func deleteCatalogRow(db *sql.DB, table string, id int, inUse error, references ...string) (int, error) {
+	tx, err := db.Begin()
 	if err != nil {
 		return 0, err
 	}
-	for rows.Next() {
-		count++
+	defer tx.Rollback()
+
+	var locked int
+	err = tx.QueryRow(`SELECT id FROM `+table+` WHERE id = $1 FOR UPDATE`, id).Scan(&locked)
+	if err == sql.ErrNoRows {
+		return 0, nil
 	}
-	return count, nil
+	if err != nil {
+		return 0, err
+	}
+
+	for _, reference := range references {
+		var used bool
+		if err := tx.QueryRow(`SELECT EXISTS (`+reference+`)`, id).Scan(&used); err != nil {
+			return 0, err
+		}
+		if used {
+			return 0, inUse
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM `+table+` WHERE id = $1`, id); err != nil {
+		return 0, err
+	}
+	return 1, tx.Commit()
 }
 
 //////////////////
 ////  RECORDS ////
 //////////////////
 
-// GetAllRecords gets all user record by user ID
-func GetAllRecords(db *sql.DB, id int) ([]Record, error) {
-	rows, err := db.Query(`SELECT * FROM user_records WHERE user_id = $1`, id)
+// recordColumns are the user_records columns scanned by scanRecord
+const recordColumns = `r.id, r.weight, r.weight_unit, r.reps, r.rpe, r.rir, r.duration, r.distance, r.set_type, r.date_performed, r.exercise_id,
+	(SELECT we.workout_id FROM workout_exercises we WHERE we.id = r.workout_exercise_id), r.workout_exercise_id, r.user_id`
+
+// scanRecord scans a row selected with recordColumns
+func scanRecord(row interface{ Scan(...interface{}) error }) (Record, error) {
+	var record Record
+	var kg string
+	err := row.Scan(
+		&record.ID,
+		&kg,
+		&record.WeightUnit,
+		&record.Reps,
+		&record.RPE,
+		&record.RIR,
+		&record.Duration,
+		&record.Distance,
+		&record.SetType,
+		&record.DatePerformed,
+		&record.ExerciseID,
+		&record.WorkoutID,
+		&record.WorkoutExerciseID,
+		&record.UserID,
+	)
 	if err != nil {
-		return nil, err
+		return record, err
 	}
 
-	var records []Record
+	fillEffort(&record.RPE, &record.RIR)
+	// weights are shown in the unit they were logged in unless converted
+	record.Weight, err = fromKg(kg, record.WeightUnit)
+	return record, err
+}
+
+// ConvertWeight converts the record weight to unit
+func (r *Record) ConvertWeight(unit string) {
+	r.Weight = ConvertWeight(r.Weight, r.WeightUnit, unit)
+	r.WeightUnit = unit
+}
+
+// RecordFilter filters a record listing, weights are in weight unit and
+// dates are YYYY-MM-DD, both bounds included
+type RecordFilter struct {
+	ExerciseID int
+	CategoryID int
+	From       string
+	To         string
+	MinWeight  *Decimal
+	MaxWeight  *Decimal
+	WeightUnit string
+}
+
+// recordSortKeys are the keys records can be sorted by
+var recordSortKeys = map[string]sortKey{
+	"date":   {"r.date_performed", "date"},
+	"weight": {"r.weight", "numeric"},
+	"reps":   {"r.reps", "integer"},
+}
+
+// recordFilterWhere returns the conditions and arguments selecting the records of
+// user matching filter
+func recordFilterWhere(userID int, filter RecordFilter) ([]string, []interface{}) {
+	where := []string{"r.user_id = $1"}
+	args := []interface{}{userID}
+	if filter.ExerciseID != 0 {
+		args = append(args, filter.ExerciseID)
+		where = append(where, fmt.Sprintf("r.exercise_id = $%d", len(args)))
+	}
+	if filter.CategoryID != 0 {
+		args = append(args, filter.CategoryID)
+		where = append(where, fmt.Sprintf("r.exercise_id IN (SELECT e.id FROM exercise e WHERE e.category_id = $%d)", len(args)))
+	}
+	if filter.From != "" {
+		args = append(args, filter.From)
+		where = append(where, fmt.Sprintf("r.date_performed >= $%d::date", len(args)))
+	}
+	if filter.To != "" {
+		args = append(args, filter.To)
+		where = append(where, fmt.Sprintf("r.date_performed <= $%d::date", len(args)))
+	}
+	if filter.MinWeight != nil {
+		args = append(args, toKg(*filter.MinWeight, filter.WeightUnit))
+		where = append(where, fmt.Sprintf("r.weight >= $%d::numeric", len(args)))
+	}
+	if filter.MaxWeight != nil {
+		args = append(args, toKg(*filter.MaxWeight, filter.WeightUnit))
+		where = append(where, fmt.Sprintf("r.weight <= $%d::numeric", len(args)))
+	}
+
+	return where, args
+}
+
+// GetAllRecords gets a page of the records of user
+func GetAllRecords(db *sql.DB, id int, filter RecordFilter, opts ListOptions) ([]Record, *string, error) {
+	where, args := recordFilterWhere(id, filter)
+	query, args, err := listQuery(recordColumns, `user_records r`, where, args, "r.id", recordSortKeys, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	records := []Record{}
+	keys := []string{}
 	for rows.Next() {
-		var record Record
-		err := rows.Scan(
-			&record.ID,
-			&record.Weight,
-			&record.Reps,
-			&record.RPE,
-			&record.DatePerformed,
-			&record.ExerciseID,
-			&record.UserID,
-		)
+		row := &keyedRow{row: rows}
+		record, err := scanRecord(row)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		records = append(records, record)
+		keys = append(keys, row.key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
 	}
 
-	return records, nil
+	if len(records) <= opts.Limit {
+		return records, nil, nil
+	}
+	records = records[:opts.Limit]
+	last := records[opts.Limit-1]
+	return records, nextCursor(opts, keys[opts.Limit-1], last.ID), nil
 }
 
 // GetRecord gets record by ID
 func GetRecord(db *sql.DB, id int) (Record, error) {
-	var record Record
-	err := db.QueryRow(`SELECT * FROM user_records WHERE id = ($1)`, id).Scan(
-		&record.ID,
-		&record.Weight,
-		&record.Reps,
-		&record.RPE,
-		&record.DatePerformed,
-		&record.ExerciseID,
-		&record.UserID,
-	)
+	return scanRecord(db.QueryRow(`SELECT `+recordColumns+` FROM user_records r WHERE r.id = ($1)`, id))
+}
+
+// CreateRecord creates new record as the last set of workout e.WorkoutID of its
+// user, performed on the day of the workout. Without a workout it joins the
+// latest workout of the user started on its day, or is the single set of a new
+// one. Returns sql.ErrNoRows when the workout is not one of the user
+func CreateRecord(db *sql.DB, e Record) (Record, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return Record{}, err
+	}
+	defer tx.Rollback()
+
+	if e.WorkoutID != 0 {
+		err = tx.QueryRow(`SELECT to_char(w.started_at, 'YYYY-MM-DD') FROM workouts w
+			WHERE w.id = $1 AND w.user_id = $2
+			FOR UPDATE`, e.WorkoutID, e.UserID).Scan(&e.DatePerformed)
+	} else {
+		err = tx.QueryRow(`SELECT w.id FROM workouts w
+			WHERE w.user_id = $1 AND w.started_at::date = $2::date
+			ORDER BY w.started_at DESC, w.id DESC
+			LIMIT 1
+			FOR UPDATE`, e.UserID, e.DatePerformed).Scan(&e.WorkoutID)
+		if err == sql.ErrNoRows {
+			err = nil
+		}
+	}
+	if err != nil {
+		return Record{}, err
+	}
 
+	record, err := createRecord(tx, e)
 	if err != nil {
 		return record, err
 	}
 
-	return record, nil
+	return record, tx.Commit()
 }
 
-// CreateRecord creates new record
-func CreateRecord(db *sql.DB, e Record) (Record, error) {
+// createRecord creates a record as the last set of workout e.WorkoutID, or of a
+// new workout when it is 0. The set joins the last exercise of the workout when
+// it is the same exercise
+func createRecord(tx *sql.Tx, e Record) (Record, error) {
 	var record Record
-	err := db.QueryRow(`
-		INSERT INTO user_records(weight, reps, rpe, date_performed, exercise_id, user_id)
-		VALUES
-		($1, $2, $3, $4, $5, $6)
-		RETURNING *`,
-		e.Weight, e.Reps, e.RPE, e.DatePerformed, e.ExerciseID, e.UserID,
-	).Scan(
-		&record.ID,
-		&record.Weight,
-		&record.Reps,
-		&record.RPE,
-		&record.DatePerformed,
-		&record.ExerciseID,
-		&record.UserID,
-	)
+	if e.SetType == "" {
+		e.SetType = SetWorking
+	}
+	if e.WeightUnit == "" {
+		e.WeightUnit = UnitKg
+	}
 
+	workoutID := e.WorkoutID
+	if workoutID == 0 {
+		err := tx.QueryRow(`INSERT INTO workouts(started_at, user_id)
+			VALUES
+			($1::date, $2)
+			RETURNING id`, e.DatePerformed, e.UserID).Scan(&workoutID)
+		if err != nil {
+			return record, err
+		}
+	}
+
+	var workoutExerciseID, position int
+	err := tx.QueryRow(`SELECT we.id, (SELECT COUNT(*) FROM user_records r WHERE r.workout_exercise_id = we.id)
+		FROM workout_exercises we
+		WHERE we.workout_id = $1 AND we.exercise_id = $2
+		AND we.position = (SELECT MAX(position) FROM workout_exercises WHERE workout_id = $1)`,
+		workoutID, e.ExerciseID).Scan(&workoutExerciseID, &position)
+	if err == sql.ErrNoRows {
+		err = tx.QueryRow(`INSERT INTO workout_exercises(position, workout_id, exercise_id)
+			VALUES
+			((SELECT COALESCE(MAX(position), 0) + 1 FROM workout_exercises WHERE workout_id = $1), $1, $2)
+			RETURNING id`, workoutID, e.ExerciseID).Scan(&workoutExerciseID)
+	}
 	if err != nil {
 		return record, err
 	}
 
-	return record, nil
+	return scanRecord(tx.QueryRow(`
+		INSERT INTO user_records AS r(weight, weight_unit, reps, rpe, rir, duration, distance, set_type, position, date_performed, exercise_id, workout_exercise_id, user_id)
+		VALUES
+		($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING `+recordColumns,
+		toKg(e.Weight, e.WeightUnit), e.WeightUnit, e.Reps, e.RPE, e.RIR, e.Duration, e.Distance, e.SetType, position+1, e.DatePerformed, e.ExerciseID, workoutExerciseID, e.UserID,
+	))
 }
 
 // EditRecord edits record by record ID
 func EditRecord(db *sql.DB, userID int, e Record) (Record, error) {
-	var record Record
-	err := db.QueryRow(`UPDATE user_records
-		SET weight = $1, reps = $2, rpe = $3
-		WHERE id = $4 AND user_id = $5
-		RETURNING *`,
-		e.Weight, e.Reps, e.RPE, e.ID, userID,
-	).Scan(
-		&record.ID,
-		&record.Weight,
-		&record.Reps,
-		&record.RPE,
-		&record.DatePerformed,
-		&record.ExerciseID,
-		&record.UserID,
-	)
-
-	if err != nil {
-		return record, err
+	if e.SetType == "" {
+		e.SetType = SetWorking
 	}
-
-	return record, nil
+	if e.WeightUnit == "" {
+		e.WeightUnit = UnitKg
+	}
+	return scanRecord(db.QueryRow(`UPDATE user_records AS r
+		SET weight = $1, weight_unit = $2, reps = $3, rpe = $4, rir = $5, duration = $6, distance = $7, set_type = $8
+		WHERE id = $9 AND user_id = $10
+		RETURNING `+recordColumns,
+		toKg(e.Weight, e.WeightUnit), e.WeightUnit, e.Reps, e.RPE, e.RIR, e.Duration, e.Distance, e.SetType, e.ID, userID,
+	))
 }
 
-// DeleteRecord deletes record by ID
+// DeleteRecord deletes record by ID, along its workout exercise and workout
+// when it was the last set left in them
 func DeleteRecord(db *sql.DB, userID int, id int) (int, error) {
-	var count = 0
-	rows, err := db.Query(`DELETE FROM user_records WHERE id = $1 AND user_id = $2 RETURNING *`, id, userID)
+	tx, err := db.Begin()
 	if err != nil {
 		return 0, err
 	}
-	for rows.Next() {
-		count++
+	defer tx.Rollback()
+
+	var workoutExerciseID int
+	err = tx.QueryRow(`DELETE FROM user_records WHERE id = $1 AND user_id = $2 RETURNING workout_exercise_id`,
+		id, userID).Scan(&workoutExerciseID)
+	if err == sql.ErrNoRows {
+		return 0, nil
 	}
-	return count, nil
+	if err != nil {
+		return 0, err
+	}
+
+	var workoutID int
+	err = tx.QueryRow(`DELETE FROM workout_exercises we
+		WHERE we.id = $1 AND NOT EXISTS (SELECT 1 FROM user_records r WHERE r.workout_exercise_id = we.id)
+		RETURNING we.workout_id`, workoutExerciseID).Scan(&workoutID)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM workouts w
+			WHERE w.id = $1 AND NOT EXISTS (SELECT 1 FROM workout_exercises we WHERE we.workout_id = w.id)`, workoutID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return 1, tx.Commit()
 }
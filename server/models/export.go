@@ -0,0 +1,267 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export job statuses
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+// ExportJob is the DB response struct from export_jobs table,
+// the archive itself is only read when downloaded
+type ExportJob struct {
+	ID          int        `json:"id"`
+	Status      string     `json:"status"`
+	Error       *string    `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	UserID      int        `json:"-"`
+}
+
+// ExportRecord is a user record joined with its exercise and category names
+type ExportRecord struct {
+	ID            int      `json:"id"`
+	Weight        Decimal  `json:"weight"`
+	WeightUnit    string   `json:"weight_unit"`
+	Reps          int      `json:"reps"`
+	RPE           *Decimal `json:"rpe"`
+	RIR           *Decimal `json:"rir"`
+	Duration      int      `json:"duration"`
+	Distance      Decimal  `json:"distance"`
+	SetType       string   `json:"set_type"`
+	DatePerformed string   `json:"date_performed"`
+	WorkoutID     int      `json:"workout_id"`
+	ExerciseID    int      `json:"exercise_id"`
+	ExerciseName  string   `json:"exercise_name"`
+	Measurement   string   `json:"measurement"`
+	CategoryID    int      `json:"category_id"`
+	CategoryName  string   `json:"category_name"`
+}
+
+// exportJobColumns are the export_jobs columns scanned by scanExportJob
+const exportJobColumns = `j.id, j.status, j.error, j.created_at, j.completed_at, j.expires_at, j.user_id`
+
+// scanExportJob scans a row selected with exportJobColumns
+func scanExportJob(row interface{ Scan(...interface{}) error }) (ExportJob, error) {
+	var job ExportJob
+	err := row.Scan(
+		&job.ID,
+		&job.Status,
+		&job.Error,
+		&job.CreatedAt,
+		&job.CompletedAt,
+		&job.ExpiresAt,
+		&job.UserID,
+	)
+	return job, err
+}
+
+// CreateExportJob creates a pending export job for user
+func CreateExportJob(db *sql.DB, userID int) (ExportJob, error) {
+	return scanExportJob(db.QueryRow(`INSERT INTO export_jobs AS j(user_id)
+		VALUES
+		($1)
+		RETURNING `+exportJobColumns, userID))
+}
+
+// GetActiveExportJob gets the user export job that is still being built or can
+// still be downloaded, returns sql.ErrNoRows if there is none
+func GetActiveExportJob(db *sql.DB, userID int) (ExportJob, error) {
+	return scanExportJob(db.QueryRow(`SELECT `+exportJobColumns+`
+		FROM export_jobs j
+		WHERE j.user_id = $1
+		AND (j.status = $2 OR (j.status = $3 AND j.expires_at > NOW()))
+		ORDER BY j.created_at DESC
+		LIMIT 1`, userID, ExportPending, ExportReady))
+}
+
+// GetExportJob gets an export job of user by ID
+func GetExportJob(db *sql.DB, userID int, id int) (ExportJob, error) {
+	return scanExportJob(db.QueryRow(`SELECT `+exportJobColumns+`
+		FROM export_jobs j
+		WHERE j.id = $1 AND j.user_id = $2`, id, userID))
+}
+
+// GetExportArchive gets the archive of a ready export job of user,
+// returns sql.ErrNoRows once it has expired
+func GetExportArchive(db *sql.DB, userID int, id int) ([]byte, error) {
+	var archive []byte
+	err := db.QueryRow(`SELECT j.archive
+		FROM export_jobs j
+		WHERE j.id = $1 AND j.user_id = $2 AND j.status = $3 AND j.expires_at > NOW()`,
+		id, userID, ExportReady).Scan(&archive)
+	return archive, err
+}
+
+// CompleteExportJob stores the archive of an export job until expiresAt
+func CompleteExportJob(db *sql.DB, id int, archive []byte, expiresAt time.Time) error {
+	_, err := db.Exec(`UPDATE export_jobs
+		SET status = $1, archive = $2, completed_at = NOW(), expires_at = $3
+		WHERE id = $4`, ExportReady, archive, expiresAt, id)
+	return err
+}
+
+// FailExportJob marks an export job as failed
+func FailExportJob(db *sql.DB, id int, reason string) error {
+	_, err := db.Exec(`UPDATE export_jobs
+		SET status = $1, error = $2, completed_at = NOW()
+		WHERE id = $3`, ExportFailed, reason, id)
+	return err
+}
+
+// DeleteExpiredExportJobs deletes expired archives and jobs stuck pending for
+// longer than stale, which happens when the server stops while building one
+func DeleteExpiredExportJobs(db *sql.DB, stale time.Duration) (int, error) {
+	res, err := db.Exec(`DELETE FROM export_jobs
+		WHERE expires_at < NOW()
+		OR (status = $1 AND created_at < NOW() - $2 * INTERVAL '1 second')`,
+		ExportPending, int64(stale.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+	count, err := res.RowsAffected()
+	return int(count), err
+}
+
+// ExportRecordColumns are the CSV columns of export records
+var ExportRecordColumns = []string{"id", "date_performed", "workout_id", "exercise_id", "exercise_name", "measurement", "category_id", "category_name",
+	"weight", "weight_unit", "reps", "rpe", "rir", "duration", "distance", "set_type"}
+
+// formatDecimal formats an optional number, empty when missing
+func formatDecimal(d *Decimal) string {
+	if d == nil {
+		return ""
+	}
+	return d.String()
+}
+
+// CSVRow returns the values of r in the order of ExportRecordColumns
+func (r ExportRecord) CSVRow() []string {
+	return []string{
+		strconv.Itoa(r.ID),
+		r.DatePerformed,
+		strconv.Itoa(r.WorkoutID),
+		strconv.Itoa(r.ExerciseID),
+		r.ExerciseName,
+		r.Measurement,
+		strconv.Itoa(r.CategoryID),
+		r.CategoryName,
+		r.Weight.String(),
+		r.WeightUnit,
+		strconv.Itoa(r.Reps),
+		formatDecimal(r.RPE),
+		formatDecimal(r.RIR),
+		strconv.Itoa(r.Duration),
+		r.Distance.String(),
+		r.SetType,
+	}
+}
+
+// exportFetchSize is how many records are fetched from the export cursor at once
+const exportFetchSize = 500
+
+// StreamExportRecords calls fn with every record of user matching filter, with its
+// exercise and category names and its weight in unit. Records are read through a
+// server-side cursor so they are never all in memory
+func StreamExportRecords(db *sql.DB, userID int, filter RecordFilter, unit string, fn func(ExportRecord) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	where, args := recordFilterWhere(userID, filter)
+	_, err = tx.Exec(`DECLARE export_records NO SCROLL CURSOR FOR
+		SELECT r.id, r.weight, r.reps, r.rpe, r.rir, r.duration, r.distance, r.set_type, to_char(r.date_performed, 'YYYY-MM-DD'),
+		we.workout_id, e.id, e.name, e.measurement, c.id, c.name
+		FROM user_records r
+		JOIN workout_exercises we ON we.id = r.workout_exercise_id
+		JOIN exercise e ON e.id = r.exercise_id
+		JOIN category c ON c.id = e.category_id
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY r.date_performed, we.workout_id, we.position, r.position`, args...)
+	if err != nil {
+		return err
+	}
+
+	for {
+		fetched, err := fetchExportRecords(tx, unit, fn)
+		if err != nil {
+			return err
+		}
+		if fetched < exportFetchSize {
+			break
+		}
+	}
+
+	return tx.Commit()
+}
+
+// fetchExportRecords fetches the next records of the export cursor and calls fn
+// with each of them, returns how many were fetched
+func fetchExportRecords(tx *sql.Tx, unit string, fn func(ExportRecord) error) (int, error) {
+	rows, err := tx.Query(fmt.Sprintf(`FETCH %d FROM export_records`, exportFetchSize))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	fetched := 0
+	for rows.Next() {
+		var record ExportRecord
+		var kg string
+		err := rows.Scan(
+			&record.ID,
+			&kg,
+			&record.Reps,
+			&record.RPE,
+			&record.RIR,
+			&record.Duration,
+			&record.Distance,
+			&record.SetType,
+			&record.DatePerformed,
+			&record.WorkoutID,
+			&record.ExerciseID,
+			&record.ExerciseName,
+			&record.Measurement,
+			&record.CategoryID,
+			&record.CategoryName,
+		)
+		if err != nil {
+			return fetched, err
+		}
+		fillEffort(&record.RPE, &record.RIR)
+		record.Weight, err = fromKg(kg, unit)
+		if err != nil {
+			return fetched, err
+		}
+		record.WeightUnit = unit
+
+		fetched++
+		if err := fn(record); err != nil {
+			return fetched, err
+		}
+	}
+
+	return fetched, rows.Err()
+}
+
+// GetExportRecords gets every record of user with its exercise and category names,
+// weights are converted to unit
+func GetExportRecords(db *sql.DB, userID int, unit string) ([]ExportRecord, error) {
+	records := []ExportRecord{}
+	err := StreamExportRecords(db, userID, RecordFilter{}, unit, func(r ExportRecord) error {
+		records = append(records, r)
+		return nil
+	})
+	return records, err
+}
@@ -0,0 +1,108 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// OAuthState is what is remembered about an authorization request
+// until the provider redirects back
+type OAuthState struct {
+	Provider string `json:"provider"`
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+}
+
+// oauthStateKey is the redis key holding an authorization request by state
+func oauthStateKey(state string) string {
+	return "oauth_state:" + state
+}
+
+// SaveOAuthState stores an authorization request by state
+func SaveOAuthState(c *redis.Client, state string, s OAuthState, ttl time.Duration) error {
+	value, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return c.Set(oauthStateKey(state), value, ttl).Err()
+}
+
+// ConsumeOAuthState returns an authorization request and deletes it,
+// returns redis.Nil if it does not exist or was already used
+func ConsumeOAuthState(c *redis.Client, state string) (OAuthState, error) {
+	var s OAuthState
+	key := oauthStateKey(state)
+	value, err := c.Get(key).Bytes()
+	if err != nil {
+		return s, err
+	}
+
+	count, err := c.Del(key).Result()
+	if err != nil {
+		return s, err
+	}
+	if count == 0 {
+		return s, redis.Nil
+	}
+
+	err = json.Unmarshal(value, &s)
+	return s, err
+}
+
+// GetIdentityUser gets the User linked to a provider identity
+func GetIdentityUser(db *sql.DB, provider string, subject string) (UserResponse, error) {
+	var user UserResponse
+	err := db.QueryRow(
+		`SELECT u.id, u.username, u.email, u.verified_at IS NOT NULL, u.role
+		FROM user_identities i
+		JOIN users u ON u.id = i.user_id
+		WHERE i.provider = $1 AND i.subject = $2 AND u.deleted_at IS NULL`,
+		provider, subject).Scan(&user.ID, &user.Username, &user.Email, &user.Verified, &user.Role)
+	if err != nil {
+		return user, err
+	}
+	return user, nil
+}
+
+// CreateIdentity links a provider identity to user
+func CreateIdentity(db *sql.DB, provider string, subject string, userID int) error {
+	_, err := db.Exec(`INSERT INTO user_identities(provider, subject, user_id)
+		VALUES
+		($1, $2, $3)`, provider, subject, userID)
+	return err
+}
+
+// Identity is the DB response struct from user_identities table, a provider
+// account linked to a user
+type Identity struct {
+	ID        int       `json:"id"`
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GetIdentities gets every provider identity linked to user
+func GetIdentities(db *sql.DB, userID int) ([]Identity, error) {
+	rows, err := db.Query(`SELECT i.id, i.provider, i.subject, i.created_at
+		FROM user_identities i
+		WHERE i.user_id = $1
+		ORDER BY i.created_at, i.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []Identity{}
+	for rows.Next() {
+		var i Identity
+		if err := rows.Scan(&i.ID, &i.Provider, &i.Subject, &i.CreatedAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, i)
+	}
+
+	return identities, rows.Err()
+}
@@ -0,0 +1,235 @@
+package models
+
+import (
+	"database/sql"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// minMatchSimilarity is how similar an imported exercise name must be to a
+// catalog name to match it, from 0 to 1
+const minMatchSimilarity = 0.8
+
+// ImportSet is a set read from an imported file. Sets of the same session are
+// imported in the same workout
+type ImportSet struct {
+	Line         int       `json:"line"`
+	Session      string    `json:"-"`
+	StartedAt    time.Time `json:"-"`
+	ExerciseName string    `json:"exercise_name"`
+	Record       Record    `json:"record"`
+}
+
+// nonAlphanumeric matches the characters left out when comparing exercise names
+var nonAlphanumeric = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// parenthesized matches a parenthesized part of an exercise name, like the
+// equipment in "Bench Press (Barbell)"
+var parenthesized = regexp.MustCompile(`\(([^)]*)\)`)
+
+// normalizeExerciseName uppercases name and keeps its words sorted, so
+// "Bench Press (Barbell)" and "barbell bench-press" are the same exercise
+func normalizeExerciseName(name string) string {
+	name = parenthesized.ReplaceAllString(strings.ToUpper(name), " $1 ")
+	words := strings.Fields(nonAlphanumeric.ReplaceAllString(name, " "))
+	sort.Strings(words)
+	return strings.Join(words, " ")
+}
+
+// exerciseNameVariants returns the normalized name along the one without its
+// parenthesized parts, apps often add the equipment to catalog names
+func exerciseNameVariants(name string) []string {
+	return []string{
+		normalizeExerciseName(name),
+		normalizeExerciseName(parenthesized.ReplaceAllString(name, " ")),
+	}
+}
+
+// similarity returns how close two strings are from 0 to 1, from their
+// Levenshtein distance
+func similarity(a string, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 && len(rb) == 0 {
+		return 1
+	}
+
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous, current = current, previous
+	}
+
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	return 1 - float64(previous[len(rb)])/float64(longest)
+}
+
+// MatchExercise finds the catalog exercise name refers to, the same name once
+// normalized or else the most similar one
+func MatchExercise(name string, catalog []Exercise) (Exercise, bool) {
+	variants := exerciseNameVariants(name)
+	var best Exercise
+	bestSimilarity := 0.0
+	for _, e := range catalog {
+		catalogName := normalizeExerciseName(e.Name)
+		for _, v := range variants {
+			if s := similarity(v, catalogName); s > bestSimilarity {
+				best, bestSimilarity = e, s
+			}
+		}
+	}
+	return best, bestSimilarity >= minMatchSimilarity
+}
+
+// GetExerciseCatalog gets every exercise
+func GetExerciseCatalog(db *sql.DB) ([]Exercise, error) {
+	rows, err := db.Query(`SELECT e.id, e.name, e.category_id, e.measurement FROM exercise e ORDER BY e.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exercises := []Exercise{}
+	for rows.Next() {
+		var exercise Exercise
+		err := rows.Scan(&exercise.ID, &exercise.Name, &exercise.CategoryID, &exercise.Measurement)
+		if err != nil {
+			return nil, err
+		}
+		exercises = append(exercises, exercise)
+	}
+
+	return exercises, rows.Err()
+}
+
+// importKey identifies a set when looking for duplicates
+type importKey struct {
+	date       string
+	exerciseID int
+	weight     Decimal
+	reps       int
+	duration   int
+	distance   Decimal
+}
+
+// newImportKey returns the key of a record, its date is YYYY-MM-DD
+func newImportKey(r Record, date string) importKey {
+	return importKey{
+		date:       date,
+		exerciseID: r.ExerciseID,
+		weight:     ConvertWeight(r.Weight, r.WeightUnit, UnitKg),
+		reps:       r.Reps,
+		duration:   r.Duration,
+		distance:   r.Distance,
+	}
+}
+
+// FindDuplicates returns the lines of sets the user already logged, sets must
+// have their exercise ID. A file can have the same set several times, so only as
+// many of them as the user logged are duplicates
+func FindDuplicates(db *sql.DB, userID int, sets []ImportSet) ([]int, error) {
+	if len(sets) == 0 {
+		return []int{}, nil
+	}
+
+	from, to := sets[0].Record.DatePerformed, sets[0].Record.DatePerformed
+	for _, s := range sets {
+		if s.Record.DatePerformed < from {
+			from = s.Record.DatePerformed
+		}
+		if s.Record.DatePerformed > to {
+			to = s.Record.DatePerformed
+		}
+	}
+
+	rows, err := db.Query(`SELECT `+recordColumns+`, to_char(r.date_performed, 'YYYY-MM-DD') FROM user_records r
+		WHERE r.user_id = $1 AND r.date_performed BETWEEN $2::date AND $3::date`, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	logged := map[importKey]int{}
+	for rows.Next() {
+		row := &keyedRow{row: rows}
+		record, err := scanRecord(row)
+		if err != nil {
+			return nil, err
+		}
+		logged[newImportKey(record, row.key)]++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return countDuplicates(logged, sets), nil
+}
+
+// countDuplicates returns the lines of sets matching a logged set, logged
+// counts the sets the user logged by key. Each logged set matches one set
+func countDuplicates(logged map[importKey]int, sets []ImportSet) []int {
+	duplicates := []int{}
+	for _, s := range sets {
+		key := newImportKey(s.Record, s.Record.DatePerformed)
+		if logged[key] > 0 {
+			logged[key]--
+			duplicates = append(duplicates, s.Line)
+		}
+	}
+	return duplicates
+}
+
+// ImportSets creates the sets of user in one transaction, a workout per session
+// in the order sets are given, and returns how many workouts were created
+func ImportSets(db *sql.DB, userID int, sets []ImportSet) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	workouts := map[string]int{}
+	for _, s := range sets {
+		workoutID, ok := workouts[s.Session]
+		if !ok {
+			err := tx.QueryRow(`INSERT INTO workouts(started_at, user_id)
+				VALUES
+				($1, $2)
+				RETURNING id`, s.StartedAt, userID).Scan(&workoutID)
+			if err != nil {
+				return 0, err
+			}
+			workouts[s.Session] = workoutID
+		}
+
+		record := s.Record
+		record.WorkoutID = workoutID
+		record.UserID = userID
+		if _, err := createRecord(tx, record); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(workouts), tx.Commit()
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeExerciseName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Bench Press (Barbell)", "BARBELL BENCH PRESS"},
+		{"barbell bench-press", "BARBELL BENCH PRESS"},
+		{"  Pull-Up  ", "PULL UP"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeExerciseName(tt.name); got != tt.want {
+			t.Errorf("normalizeExerciseName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMatchExercise(t *testing.T) {
+	catalog := []Exercise{
+		{ID: 1, Name: "Bench Press (Barbell)"},
+		{ID: 2, Name: "Squat"},
+		{ID: 3, Name: "Deadlift"},
+	}
+	tests := []struct {
+		name    string
+		id      int
+		matched bool
+	}{
+		{"Bench Press (Barbell)", 1, true},
+		{"barbell bench-press", 1, true},
+		// the equipment added by the app is left out
+		{"Squat (Barbell)", 2, true},
+		// one edit in 9 letters is similar enough
+		{"Deadlifts", 3, true},
+		// two edits in 8 letters are not
+		{"Deadlfit", 3, false},
+		{"Leg Press", 0, false},
+	}
+	for _, tt := range tests {
+		e, matched := MatchExercise(tt.name, catalog)
+		if matched != tt.matched || (matched && e.ID != tt.id) {
+			t.Errorf("MatchExercise(%q) = %d, %t, want %d, %t", tt.name, e.ID, matched, tt.id, tt.matched)
+		}
+	}
+}
+
+func TestCountDuplicates(t *testing.T) {
+	set := func(line int, date string, kg Decimal, reps int) ImportSet {
+		return ImportSet{Line: line, Record: Record{
+			Weight: kg * decimalScale, WeightUnit: UnitKg, Reps: reps, DatePerformed: date, ExerciseID: 1,
+		}}
+	}
+	logged := func(sets ...ImportSet) map[importKey]int {
+		keys := map[importKey]int{}
+		for _, s := range sets {
+			keys[newImportKey(s.Record, s.Record.DatePerformed)]++
+		}
+		return keys
+	}
+	lb := set(3, "2024-01-01", 0, 5)
+	lb.Record.Weight, lb.Record.WeightUnit = 220462*decimalScale/1000, UnitLb
+
+	tests := []struct {
+		name   string
+		logged map[importKey]int
+		sets   []ImportSet
+		want   []int
+	}{
+		{"nothing logged", logged(), []ImportSet{set(1, "2024-01-01", 100, 5)}, []int{}},
+		{"same set", logged(set(0, "2024-01-01", 100, 5)), []ImportSet{set(1, "2024-01-01", 100, 5)}, []int{1}},
+		{"other day", logged(set(0, "2024-01-02", 100, 5)), []ImportSet{set(1, "2024-01-01", 100, 5)}, []int{}},
+		{"other reps", logged(set(0, "2024-01-01", 100, 6)), []ImportSet{set(1, "2024-01-01", 100, 5)}, []int{}},
+		{
+			"as many as logged",
+			logged(set(0, "2024-01-01", 100, 5), set(0, "2024-01-01", 100, 5)),
+			[]ImportSet{set(1, "2024-01-01", 100, 5), set(2, "2024-01-01", 100, 5), set(3, "2024-01-01", 100, 5)},
+			[]int{1, 2},
+		},
+		{"weight in pounds", logged(set(0, "2024-01-01", 100, 5)), []ImportSet{lb}, []int{3}},
+	}
+	for _, tt := range tests {
+		if got := countDuplicates(tt.logged, tt.sets); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
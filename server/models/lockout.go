@@ -0,0 +1,76 @@
+package models
+
+import (
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// loginFailuresKey is the redis key counting failed logins of subject
+func loginFailuresKey(subject string) string {
+	return "login_failures:" + subject
+}
+
+// loginLockKey is the redis key set while subject is locked out
+func loginLockKey(subject string) string {
+	return "login_lock:" + subject
+}
+
+// IncrLoginFailures counts a failed login of subject, the count is forgotten
+// after window without failures
+func IncrLoginFailures(c *redis.Client, subject string, window time.Duration) (int64, error) {
+	var incr *redis.IntCmd
+	_, err := c.TxPipelined(func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(loginFailuresKey(subject))
+		pipe.Expire(loginFailuresKey(subject), window)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+// LockLogin locks subject out for d
+func LockLogin(c *redis.Client, subject string, d time.Duration) error {
+	return c.Set(loginLockKey(subject), 1, d).Err()
+}
+
+// GetLoginLock returns how long subject is still locked out for
+func GetLoginLock(c *redis.Client, subject string) (time.Duration, error) {
+	d, err := c.TTL(loginLockKey(subject)).Result()
+	if err != nil {
+		return 0, err
+	}
+	// negative values mean the key does not exist or has no expiration
+	if d < 0 {
+		return 0, nil
+	}
+	return d, nil
+}
+
+// ResetLoginFailures clears failed logins and lock of subject
+func ResetLoginFailures(c *redis.Client, subject string) error {
+	return c.Del(loginFailuresKey(subject), loginLockKey(subject)).Err()
+}
+
+// unlockIPKey is the redis key holding the IP whose failed logins locked the
+// account of an unlock link, by the link token hash
+func unlockIPKey(hash string) string {
+	return "login_unlock_ip:" + hash
+}
+
+// SaveUnlockIP stores the IP an unlock link also lifts the lockout of
+func SaveUnlockIP(c *redis.Client, hash string, ip string, ttl time.Duration) error {
+	return c.Set(unlockIPKey(hash), ip, ttl).Err()
+}
+
+// ConsumeUnlockIP returns the IP of an unlock link and deletes it, returns
+// redis.Nil if it does not exist
+func ConsumeUnlockIP(c *redis.Client, hash string) (string, error) {
+	ip, err := c.Get(unlockIPKey(hash)).Result()
+	if err != nil {
+		return "", err
+	}
+	return ip, c.Del(unlockIPKey(hash)).Err()
+}
@@ -0,0 +1,65 @@
+package models
+
+import "errors"
+
+// Measurement types an exercise can have
+const (
+	MeasureWeightReps       = "weight_reps"
+	MeasureBodyweightReps   = "bodyweight_reps"
+	MeasureAssisted         = "assisted"
+	MeasureDuration         = "duration"
+	MeasureDistanceDuration = "distance_duration"
+)
+
+// Measurements lists every valid measurement type
+var Measurements = map[string]bool{
+	MeasureWeightReps:       true,
+	MeasureBodyweightReps:   true,
+	MeasureAssisted:         true,
+	MeasureDuration:         true,
+	MeasureDistanceDuration: true,
+}
+
+// ValidateRecord checks r carries the fields of the exercise measurement and
+// nothing else. Weight is the load for weight×reps, the optional added load for
+// bodyweight×reps and duration, and the assistance for assisted exercises
+func (e Exercise) ValidateRecord(r Record) error {
+	if r.Weight < 0 || r.Reps < 0 || r.Duration < 0 || r.Distance < 0 {
+		return errors.New("weight, reps, duration and distance can't be negative")
+	}
+
+	switch e.Measurement {
+	case MeasureWeightReps, MeasureBodyweightReps:
+		if r.Reps == 0 {
+			return errors.New("reps are required")
+		}
+		if r.Duration != 0 || r.Distance != 0 {
+			return errors.New("duration and distance can't be logged for " + e.Measurement + " exercises")
+		}
+	case MeasureAssisted:
+		if r.Reps == 0 || r.Weight == 0 {
+			return errors.New("reps and assistance weight are required")
+		}
+		if r.Duration != 0 || r.Distance != 0 {
+			return errors.New("duration and distance can't be logged for assisted exercises")
+		}
+	case MeasureDuration:
+		if r.Duration == 0 {
+			return errors.New("duration is required")
+		}
+		if r.Reps != 0 || r.Distance != 0 {
+			return errors.New("reps and distance can't be logged for duration exercises")
+		}
+	case MeasureDistanceDuration:
+		if r.Distance == 0 || r.Duration == 0 {
+			return errors.New("distance and duration are required")
+		}
+		if r.Weight != 0 || r.Reps != 0 {
+			return errors.New("weight and reps can't be logged for distance exercises")
+		}
+	default:
+		return errors.New("unknown measurement " + e.Measurement)
+	}
+
+	return nil
+}
@@ -0,0 +1,168 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Page sizes of listings
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// Page is a page of a listing, next cursor is null on the last page
+type Page struct {
+	Items      interface{} `json:"items"`
+	NextCursor *string     `json:"next_cursor"`
+}
+
+// ListOptions sorts and paginates a listing. Sort is a sort key, descending when
+// prefixed with -, and cursor the next cursor of the previous page
+type ListOptions struct {
+	Sort   string
+	Cursor string
+	Limit  int
+}
+
+// cursor is the position of the last item of a page: its sort key and ID, which
+// breaks ties so pages never skip or repeat items
+type cursor struct {
+	Sort string `json:"s"`
+	Key  string `json:"k"`
+	ID   int    `json:"i"`
+}
+
+// sortKey is a column a listing can be sorted by and the type its cursor key
+// is cast back to
+type sortKey struct {
+	column string
+	cast   string
+}
+
+// errInvalidCursor is returned for cursors that weren't made by this listing
+var errInvalidCursor = errors.New("invalid cursor")
+
+// ListError is returned for a sort, cursor or limit a listing can't be made
+// with, unlike the errors of its query
+type ListError struct {
+	err error
+}
+
+func (e ListError) Error() string {
+	return e.err.Error()
+}
+
+// encodeCursor returns the opaque cursor of c
+func encodeCursor(c cursor) *string {
+	b, _ := json.Marshal(c)
+	s := base64.RawURLEncoding.EncodeToString(b)
+	return &s
+}
+
+// decodeCursor reads a cursor made by encodeCursor
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, errInvalidCursor
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, errInvalidCursor
+	}
+	return c, nil
+}
+
+// numericKey matches the text of a numeric sort key
+var numericKey = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+// validKey tells whether a cursor key can be cast back to cast, so a tampered
+// cursor is refused rather than failing its query
+func validKey(key, cast string) bool {
+	switch cast {
+	case "date":
+		_, err := time.Parse("2006-01-02", key)
+		return err == nil
+	case "numeric":
+		return numericKey.MatchString(key)
+	case "integer":
+		_, err := strconv.ParseInt(key, 10, 32)
+		return err == nil
+	case "text":
+		return utf8.ValidString(key) && !strings.ContainsRune(key, 0)
+	}
+	return false
+}
+
+// listQuery builds a sorted and paginated listing query. It selects columns and
+// the sort key as text last, filtered by where, and fetches one more row than
+// the page size to know if there is a next page
+func listQuery(columns string, from string, where []string, args []interface{}, id string, keys map[string]sortKey, opts ListOptions) (string, []interface{}, error) {
+	name := strings.TrimPrefix(opts.Sort, "-")
+	key, ok := keys[name]
+	if !ok {
+		sorts := []string{}
+		for k := range keys {
+			sorts = append(sorts, k)
+		}
+		sort.Strings(sorts)
+		return "", nil, ListError{fmt.Errorf("sort must be one of %s, prefixed with - to sort descending", strings.Join(sorts, ", "))}
+	}
+	if opts.Limit < 1 || opts.Limit > MaxPageSize {
+		return "", nil, ListError{fmt.Errorf("limit must be between 1 and %d", MaxPageSize)}
+	}
+
+	direction, compare := "ASC", ">"
+	if strings.HasPrefix(opts.Sort, "-") {
+		direction, compare = "DESC", "<"
+	}
+
+	if opts.Cursor != "" {
+		c, err := decodeCursor(opts.Cursor)
+		if err != nil {
+			return "", nil, ListError{err}
+		}
+		if c.Sort != opts.Sort {
+			return "", nil, ListError{errors.New("cursor was made with another sort")}
+		}
+		if !validKey(c.Key, key.cast) {
+			return "", nil, ListError{errInvalidCursor}
+		}
+		args = append(args, c.Key, c.ID)
+		where = append(where, fmt.Sprintf("(%s, %s) %s ($%d::%s, $%d)",
+			key.column, id, compare, len(args)-1, key.cast, len(args)))
+	}
+
+	query := `SELECT ` + columns + `, ` + key.column + `::text FROM ` + from
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	args = append(args, opts.Limit+1)
+	query += fmt.Sprintf(` ORDER BY %s %s, %s %s LIMIT $%d`, key.column, direction, id, direction, len(args))
+
+	return query, args, nil
+}
+
+// keyedRow scans the sort key selected last by listQuery along the row columns
+type keyedRow struct {
+	row interface{ Scan(...interface{}) error }
+	key string
+}
+
+// Scan scans the row columns into dest and its sort key into k.key
+func (k *keyedRow) Scan(dest ...interface{}) error {
+	return k.row.Scan(append(dest, &k.key)...)
+}
+
+// nextCursor returns the cursor of the page after the item with key and id
+func nextCursor(opts ListOptions, key string, id int) *string {
+	return encodeCursor(cursor{Sort: opts.Sort, Key: key, ID: id})
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestListQueryCursorKey(t *testing.T) {
+	tests := []struct {
+		sort  string
+		key   string
+		valid bool
+	}{
+		{"date", "2024-02-29", true},
+		{"date", "2023-02-29", false},
+		{"date", "yesterday", false},
+		{"weight", "102.500", true},
+		{"weight", "-2.5", true},
+		{"weight", "NaN", false},
+		{"weight", "1e3", false},
+		{"reps", "12", true},
+		{"reps", "12.5", false},
+		{"reps", "99999999999", false},
+		{"name", "Bench press", true},
+		{"name", "bad\x00key", false},
+	}
+	keys := map[string]sortKey{
+		"date":   recordSortKeys["date"],
+		"weight": recordSortKeys["weight"],
+		"reps":   recordSortKeys["reps"],
+		"name":   exerciseSortKeys["name"],
+	}
+	for _, tt := range tests {
+		opts := ListOptions{Sort: tt.sort, Limit: DefaultPageSize}
+		opts.Cursor = *encodeCursor(cursor{Sort: tt.sort, Key: tt.key, ID: 1})
+		_, _, err := listQuery("r.id", "record r", nil, nil, "r.id", keys, opts)
+		if _, refused := err.(ListError); refused == tt.valid || (err != nil && !refused) {
+			t.Errorf("%s cursor key %q: got error %v, want valid %t", tt.sort, tt.key, err, tt.valid)
+		}
+	}
+}
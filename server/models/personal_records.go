@@ -0,0 +1,303 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Personal record types
+const (
+	PRHeaviest      = "heaviest_weight"
+	PROneRepMax     = "e1rm"
+	PRRepsAtWeight  = "reps_at_weight"
+	PRSessionVolume = "session_volume"
+)
+
+// PRTypes lists every valid personal record type
+var PRTypes = map[string]bool{
+	PRHeaviest:      true,
+	PROneRepMax:     true,
+	PRRepsAtWeight:  true,
+	PRSessionVolume: true,
+}
+
+// PersonalRecord is the DB response struct from personal_records table, an event
+// of a set beating the previous best of its exercise. Value is a weight, except
+// for reps at weight where it is the reps done at Weight, and for session volume
+// where it is the weight times reps of every working set of that day. Previous is
+// null for the first set of an exercise
+type PersonalRecord struct {
+	ID            int       `json:"id"`
+	Type          string    `json:"type"`
+	Value         Decimal   `json:"value"`
+	Previous      *Decimal  `json:"previous"`
+	Weight        Decimal   `json:"weight"`
+	WeightUnit    string    `json:"weight_unit"`
+	Reps          int       `json:"reps"`
+	DatePerformed string    `json:"date_performed"`
+	CreatedAt     time.Time `json:"created_at"`
+	ExerciseID    int       `json:"exercise_id"`
+	RecordID      int       `json:"record_id"`
+	UserID        int       `json:"user_id"`
+}
+
+// ExercisePRs is the personal record history of an exercise, current holds the
+// latest event of each type
+type ExercisePRs struct {
+	ExerciseID int              `json:"exercise_id"`
+	Current    []PersonalRecord `json:"current"`
+	History    []PersonalRecord `json:"history"`
+}
+
+// ConvertWeight converts the weights of the personal record from kilograms to unit
+func (p *PersonalRecord) ConvertWeight(unit string) {
+	if p.Type != PRRepsAtWeight {
+		p.Value = ConvertWeight(p.Value, UnitKg, unit)
+		if p.Previous != nil {
+			previous := ConvertWeight(*p.Previous, UnitKg, unit)
+			p.Previous = &previous
+		}
+	}
+	p.Weight = ConvertWeight(p.Weight, UnitKg, unit)
+	p.WeightUnit = unit
+}
+
+// personalRecordColumns are the personal_records columns scanned by scanPersonalRecord
+const personalRecordColumns = `p.id, p.type, p.value, p.previous, p.weight, p.reps, to_char(p.date_performed, 'YYYY-MM-DD'),
+	p.created_at, p.exercise_id, p.record_id, p.user_id`
+
+// scanPersonalRecord scans a row selected with personalRecordColumns, weights
+// are in kilograms
+func scanPersonalRecord(row interface{ Scan(...interface{}) error }) (PersonalRecord, error) {
+	var pr PersonalRecord
+	err := row.Scan(
+		&pr.ID,
+		&pr.Type,
+		&pr.Value,
+		&pr.Previous,
+		&pr.Weight,
+		&pr.Reps,
+		&pr.DatePerformed,
+		&pr.CreatedAt,
+		&pr.ExerciseID,
+		&pr.RecordID,
+		&pr.UserID,
+	)
+	pr.WeightUnit = UnitKg
+	return pr, err
+}
+
+// volume returns the weight times reps of a set
+func volume(r Record) Decimal {
+	return r.Weight * Decimal(r.Reps)
+}
+
+// personalRecordCandidates compares record with other working sets of its
+// exercise and returns the personal records it sets, weights in kilograms
+func personalRecordCandidates(record Record, others []Record) []PersonalRecord {
+	record.ConvertWeight(UnitKg)
+	e1rm, estimated := OneRepMax(FormulaEpley, record.Weight, record.Reps, record.RIR)
+	day := dateOf(record.DatePerformed)
+
+	var heaviest, bestE1RM, repsAtWeight *Decimal
+	dayVolume := volume(record)
+	volumes := map[string]Decimal{}
+	for _, r := range others {
+		if r.ID == record.ID || r.SetType == SetWarmUp {
+			continue
+		}
+		r.ConvertWeight(UnitKg)
+
+		if heaviest == nil || r.Weight > *heaviest {
+			w := r.Weight
+			heaviest = &w
+		}
+		if v, ok := OneRepMax(FormulaEpley, r.Weight, r.Reps, r.RIR); ok && (bestE1RM == nil || v > *bestE1RM) {
+			bestE1RM = &v
+		}
+		// reps done at this weight or heavier
+		if reps := Decimal(r.Reps) * decimalScale; r.Weight >= record.Weight && (repsAtWeight == nil || reps > *repsAtWeight) {
+			repsAtWeight = &reps
+		}
+		if d := dateOf(r.DatePerformed); d == day {
+			dayVolume += volume(r)
+		} else {
+			volumes[d] += volume(r)
+		}
+	}
+	var bestVolume *Decimal
+	for _, v := range volumes {
+		if bestVolume == nil || v > *bestVolume {
+			best := v
+			bestVolume = &best
+		}
+	}
+
+	candidates := []PersonalRecord{}
+	beats := func(value Decimal, previous *Decimal) bool {
+		return previous == nil || value > *previous
+	}
+	if beats(record.Weight, heaviest) {
+		candidates = append(candidates, PersonalRecord{Type: PRHeaviest, Value: record.Weight, Previous: heaviest})
+	}
+	if estimated && beats(e1rm, bestE1RM) {
+		candidates = append(candidates, PersonalRecord{Type: PROneRepMax, Value: e1rm, Previous: bestE1RM})
+	}
+	if reps := Decimal(record.Reps) * decimalScale; beats(reps, repsAtWeight) {
+		candidates = append(candidates, PersonalRecord{Type: PRRepsAtWeight, Value: reps, Previous: repsAtWeight})
+	}
+	if beats(dayVolume, bestVolume) {
+		candidates = append(candidates, PersonalRecord{Type: PRSessionVolume, Value: dayVolume, Previous: bestVolume})
+	}
+	return candidates
+}
+
+// replayPersonalRecords returns the personal records set by records, the
+// sets of an exercise sorted oldest first, from the day from on, weights in
+// kilograms. Each set is compared with the sets logged before it, and a day
+// keeps a single session volume record, set by its last set raising it
+func replayPersonalRecords(exercise Exercise, records []Record, from string) []PersonalRecord {
+	prs := []PersonalRecord{}
+	sessionVolumes := map[string]int{} // day -> index in prs of its session volume record
+	for i, record := range records {
+		day := dateOf(record.DatePerformed)
+		if day < dateOf(from) || !setsPersonalRecords(exercise, record) {
+			continue
+		}
+		for _, pr := range personalRecordCandidates(record, records[:i]) {
+			kg := record
+			kg.ConvertWeight(UnitKg)
+			pr.Weight, pr.WeightUnit, pr.Reps, pr.DatePerformed = kg.Weight, UnitKg, kg.Reps, day
+			pr.ExerciseID, pr.RecordID, pr.UserID = kg.ExerciseID, kg.ID, kg.UserID
+			if pr.Type == PRSessionVolume {
+				if j, ok := sessionVolumes[day]; ok {
+					prs[j] = pr
+					continue
+				}
+				sessionVolumes[day] = len(prs)
+			}
+			prs = append(prs, pr)
+		}
+	}
+	return prs
+}
+
+// setsPersonalRecords tells whether record of exercise can set personal records
+func setsPersonalRecords(exercise Exercise, record Record) bool {
+	return exercise.Measurement == MeasureWeightReps && record.SetType != SetWarmUp && record.Reps >= 1
+}
+
+// DetectPersonalRecords saves the personal records set by a newly created
+// record, weights in kilograms. As the record may be back-dated, the personal
+// records of its exercise are replayed from its day on. Only weight×reps
+// exercises have personal records
+func DetectPersonalRecords(db *sql.DB, exercise Exercise, record Record) ([]PersonalRecord, error) {
+	prs := []PersonalRecord{}
+	if !setsPersonalRecords(exercise, record) {
+		return prs, nil
+	}
+
+	saved, err := RebuildPersonalRecords(db, exercise, record.UserID, record.DatePerformed)
+	if err != nil {
+		return nil, err
+	}
+	for _, pr := range saved {
+		if pr.RecordID == record.ID {
+			prs = append(prs, pr)
+		}
+	}
+	return prs, nil
+}
+
+// dateOf returns the YYYY-MM-DD day of a scanned date
+func dateOf(date string) string {
+	if len(date) > len("2006-01-02") {
+		return date[:len("2006-01-02")]
+	}
+	return date
+}
+
+// RebuildPersonalRecords replays the personal records of an exercise of user
+// from the day from on, once its sets were created, edited or deleted, and
+// returns the personal records saved
+func RebuildPersonalRecords(db *sql.DB, exercise Exercise, userID int, from string) ([]PersonalRecord, error) {
+	records, err := GetExerciseRecords(db, userID, exercise.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM personal_records WHERE user_id = $1 AND exercise_id = $2 AND date_performed >= $3::date`,
+		userID, exercise.ID, from)
+	if err != nil {
+		return nil, err
+	}
+	prs := []PersonalRecord{}
+	for _, c := range replayPersonalRecords(exercise, records, from) {
+		pr, err := scanPersonalRecord(tx.QueryRow(`INSERT INTO personal_records AS p(type, value, previous, weight, reps, date_performed, exercise_id, record_id, user_id)
+			VALUES
+			($1, $2, $3, $4, $5, $6::date, $7, $8, $9)
+			RETURNING `+personalRecordColumns,
+			c.Type, c.Value, c.Previous, c.Weight, c.Reps, c.DatePerformed, c.ExerciseID, c.RecordID, c.UserID,
+		))
+		if err != nil {
+			return nil, err
+		}
+		prs = append(prs, pr)
+	}
+
+	return prs, tx.Commit()
+}
+
+// GetPersonalRecords gets the personal record history of user grouped by exercise,
+// oldest first, of a single exercise when exerciseID isn't 0
+func GetPersonalRecords(db *sql.DB, userID int, exerciseID int) ([]ExercisePRs, error) {
+	rows, err := db.Query(`SELECT `+personalRecordColumns+` FROM personal_records p
+		WHERE p.user_id = $1 AND ($2 = 0 OR p.exercise_id = $2)
+		ORDER BY p.exercise_id, p.date_performed, p.id`, userID, exerciseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exercises := []ExercisePRs{}
+	for rows.Next() {
+		pr, err := scanPersonalRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		last := len(exercises) - 1
+		if last < 0 || exercises[last].ExerciseID != pr.ExerciseID {
+			exercises = append(exercises, ExercisePRs{
+				ExerciseID: pr.ExerciseID,
+				Current:    []PersonalRecord{},
+				History:    []PersonalRecord{},
+			})
+			last++
+		}
+		exercises[last].History = append(exercises[last].History, pr)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range exercises {
+		latest := map[string]int{}
+		for j, pr := range exercises[i].History {
+			latest[pr.Type] = j
+		}
+		for j, pr := range exercises[i].History {
+			if latest[pr.Type] == j {
+				exercises[i].Current = append(exercises[i].Current, pr)
+			}
+		}
+	}
+
+	return exercises, nil
+}
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReplayPersonalRecords(t *testing.T) {
+	exercise := Exercise{ID: 1, Measurement: MeasureWeightReps}
+	set := func(id int, day string, kg Decimal, reps int) Record {
+		return Record{ID: id, Weight: kg * decimalScale, WeightUnit: UnitKg, Reps: reps, DatePerformed: day, ExerciseID: 1, UserID: 1}
+	}
+	kg := func(v Decimal) *Decimal {
+		v *= decimalScale
+		return &v
+	}
+	e1rm := func(weight Decimal, reps int) Decimal {
+		v, _ := OneRepMax(FormulaEpley, weight*decimalScale, reps, nil)
+		return v
+	}
+	type event struct {
+		Type     string
+		RecordID int
+		Value    Decimal
+		Previous *Decimal
+	}
+
+	// set 3 was logged last but performed first
+	backDated := []Record{
+		set(3, "2024-01-01", 100, 5),
+		set(1, "2024-01-05", 90, 5),
+		set(2, "2024-01-10T00:00:00Z", 95, 5),
+	}
+	// the volume of the second day grows with each set
+	sameDay := []Record{
+		set(1, "2024-02-01", 100, 5),
+		set(2, "2024-02-02", 60, 5),
+		set(3, "2024-02-02", 60, 5),
+		set(4, "2024-02-02", 60, 5),
+	}
+	warmUp := set(2, "2024-03-02", 120, 5)
+	warmUp.SetType = SetWarmUp
+
+	tests := []struct {
+		name    string
+		records []Record
+		from    string
+		want    []event
+	}{
+		{"back-dated set", backDated, "2024-01-01", []event{
+			{PRHeaviest, 3, 100 * decimalScale, nil},
+			{PROneRepMax, 3, e1rm(100, 5), nil},
+			{PRRepsAtWeight, 3, 5 * decimalScale, nil},
+			{PRSessionVolume, 3, 500 * decimalScale, nil},
+		}},
+		{"later sets beat by a back-dated set", backDated, "2024-01-05", nil},
+		{"one session volume per day", sameDay, "2024-02-02", []event{
+			{PRSessionVolume, 4, 900 * decimalScale, kg(500)},
+		}},
+		{"warm-up", []Record{set(1, "2024-03-01", 100, 5), warmUp}, "2024-03-02", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []event
+			for _, pr := range replayPersonalRecords(exercise, tt.records, tt.from) {
+				got = append(got, event{pr.Type, pr.RecordID, pr.Value, pr.Previous})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
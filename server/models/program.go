@@ -0,0 +1,1017 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// When a progression rule advances the training max after a successful session,
+// every session or only in the last week of a cycle like 5/3/1
+const (
+	AdvanceSession = "session"
+	AdvanceCycle   = "cycle"
+)
+
+// AdvanceOn lists every valid progression rule advance
+var AdvanceOn = map[string]bool{
+	AdvanceSession: true,
+	AdvanceCycle:   true,
+}
+
+// Enrollment statuses, an enrollment is completed once every scheduled workout is
+const (
+	EnrollmentActive    = "active"
+	EnrollmentCompleted = "completed"
+	EnrollmentCancelled = "cancelled"
+)
+
+// Outcomes of a prescribed exercise
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// plateIncrements are what prescribed weights are rounded to in each unit
+var plateIncrements = map[string]Decimal{
+	UnitKg: 2500,
+	UnitLb: 5000,
+}
+
+// Program is the DB response struct from programs table, a training program of
+// weeks of days, like linear progression, 5/3/1 or GZCL. User ID is its author
+type Program struct {
+	ID          int               `json:"id"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Weeks       int               `json:"weeks"`
+	DaysPerWeek int               `json:"days_per_week"`
+	CreatedAt   time.Time         `json:"created_at"`
+	Exercises   []ProgramExercise `json:"exercises"`
+	Rules       []ProgressionRule `json:"rules"`
+	UserID      *int              `json:"user_id"`
+}
+
+// ProgramExercise is the DB response struct from program_exercises table, the
+// sets of an exercise on a day of the program. Percent is a percentage of the
+// training max and AMRAP marks a last set of as many reps as possible
+type ProgramExercise struct {
+	ID         int     `json:"id"`
+	Week       int     `json:"week"`
+	Day        int     `json:"day"`
+	ExerciseID int     `json:"exercise_id"`
+	Sets       int     `json:"sets"`
+	Reps       int     `json:"reps"`
+	Percent    Decimal `json:"percent"`
+	AMRAP      bool    `json:"amrap"`
+}
+
+// ProgressionRule is the DB response struct from program_rules table, how the
+// training max of an exercise changes. A success adds increment to it, and after
+// failures to reset failures in a row it goes down to reset percent of itself.
+// Zero failures to reset never resets
+type ProgressionRule struct {
+	ID              int     `json:"id"`
+	ExerciseID      int     `json:"exercise_id"`
+	Increment       Decimal `json:"increment"`
+	WeightUnit      string  `json:"weight_unit"`
+	AdvanceOn       string  `json:"advance_on"`
+	FailuresToReset int     `json:"failures_to_reset"`
+	ResetPercent    Decimal `json:"reset_percent"`
+}
+
+// ConvertWeights converts the increments of the program rules from kilograms to unit
+func (p *Program) ConvertWeights(unit string) {
+	for i := range p.Rules {
+		p.Rules[i].Increment = ConvertWeight(p.Rules[i].Increment, p.Rules[i].WeightUnit, unit)
+		p.Rules[i].WeightUnit = unit
+	}
+}
+
+// Enrollment is the DB response struct from enrollments table, a user following
+// a program for a number of cycles from the day it started on
+type Enrollment struct {
+	ID            int           `json:"id"`
+	ProgramID     int           `json:"program_id"`
+	StartedOn     string        `json:"started_on"`
+	Cycles        int           `json:"cycles"`
+	Status        string        `json:"status"`
+	CreatedAt     time.Time     `json:"created_at"`
+	TrainingMaxes []TrainingMax `json:"training_maxes"`
+	UserID        int           `json:"user_id"`
+}
+
+// TrainingMax is the DB response struct from training_maxes table, the weight
+// prescriptions of an exercise are a percentage of. Failures are the failed
+// sessions in a row
+type TrainingMax struct {
+	ExerciseID int       `json:"exercise_id"`
+	Weight     Decimal   `json:"weight"`
+	WeightUnit string    `json:"weight_unit"`
+	Failures   int       `json:"failures"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// ConvertWeights converts the training maxes of the enrollment to unit
+func (e *Enrollment) ConvertWeights(unit string) {
+	for i := range e.TrainingMaxes {
+		e.TrainingMaxes[i].Weight = ConvertWeight(e.TrainingMaxes[i].Weight, e.TrainingMaxes[i].WeightUnit, unit)
+		e.TrainingMaxes[i].WeightUnit = unit
+	}
+}
+
+// ScheduledWorkout is the DB response struct from scheduled_workouts table, a
+// day of a program planned on a date. Its workout is the one the last result
+// was logged in once completed
+type ScheduledWorkout struct {
+	ID           int            `json:"id"`
+	EnrollmentID int            `json:"enrollment_id"`
+	ProgramID    int            `json:"program_id"`
+	ProgramName  string         `json:"program_name"`
+	Cycle        int            `json:"cycle"`
+	Week         int            `json:"week"`
+	Day          int            `json:"day"`
+	ScheduledOn  string         `json:"scheduled_on"`
+	CompletedAt  *time.Time     `json:"completed_at"`
+	WorkoutID    *int           `json:"workout_id"`
+	Exercises    []Prescription `json:"exercises"`
+}
+
+// Prescription is an exercise of a scheduled workout with its weight worked out
+// from the current training max. Outcome is null until a result is logged
+type Prescription struct {
+	ExerciseID   int     `json:"exercise_id"`
+	ExerciseName string  `json:"exercise_name"`
+	Sets         int     `json:"sets"`
+	Reps         int     `json:"reps"`
+	Percent      Decimal `json:"percent"`
+	AMRAP        bool    `json:"amrap"`
+	Weight       Decimal `json:"weight"`
+	WeightUnit   string  `json:"weight_unit"`
+	Outcome      *string `json:"outcome"`
+}
+
+// ScheduleFilter filters scheduled workouts, from and to are YYYY-MM-DD dates
+type ScheduleFilter struct {
+	EnrollmentID int
+	From         string
+	To           string
+}
+
+// ProgressionEvent is how a logged set changed the training max of its exercise
+type ProgressionEvent struct {
+	EnrollmentID       int     `json:"enrollment_id"`
+	ScheduledWorkoutID int     `json:"scheduled_workout_id"`
+	ExerciseID         int     `json:"exercise_id"`
+	Outcome            string  `json:"outcome"`
+	Previous           Decimal `json:"previous"`
+	TrainingMax        Decimal `json:"training_max"`
+	WeightUnit         string  `json:"weight_unit"`
+	Failures           int     `json:"failures"`
+}
+
+// ConvertWeights converts the training maxes of the event from kilograms to unit
+func (p *ProgressionEvent) ConvertWeights(unit string) {
+	p.Previous = ConvertWeight(p.Previous, p.WeightUnit, unit)
+	p.TrainingMax = ConvertWeight(p.TrainingMax, p.WeightUnit, unit)
+	p.WeightUnit = unit
+}
+
+// percentOf returns percent of the exact kilograms kg
+func percentOf(kg *big.Rat, percent Decimal) *big.Rat {
+	r := new(big.Rat).Mul(kg, percent.rat())
+	return r.Quo(r, big.NewRat(100, 1))
+}
+
+// prescribedWeight returns percent of the training max kg in unit, rounded to
+// the nearest plate increment of unit
+func prescribedWeight(kg *big.Rat, percent Decimal, unit string) Decimal {
+	w := percentOf(kg, percent)
+	w.Quo(w, unitInKg(unit))
+	increment := plateIncrements[unit]
+	plates := roundDecimal(w.Quo(w, increment.rat())).Int()
+	return Decimal(plates) * increment
+}
+
+// scheduleOffset returns how many days after the start of a program a day of
+// it is, days of a week are spread evenly over it
+func scheduleOffset(cycle int, week int, day int, p Program) int {
+	return ((cycle-1)*p.Weeks+week-1)*7 + (day-1)*7/p.DaysPerWeek
+}
+
+//////////////////
+//// PROGRAMS ////
+//////////////////
+
+// programColumns are the programs columns scanned by scanProgram
+const programColumns = `p.id, p.name, p.description, p.weeks, p.days_per_week, p.created_at, p.user_id`
+
+// scanProgram scans a row selected with programColumns
+func scanProgram(row interface{ Scan(...interface{}) error }) (Program, error) {
+	var p Program
+	var userID sql.NullInt64
+	err := row.Scan(&p.ID, &p.Name, &p.Description, &p.Weeks, &p.DaysPerWeek, &p.CreatedAt, &userID)
+	if userID.Valid {
+		id := int(userID.Int64)
+		p.UserID = &id
+	}
+	p.Exercises = []ProgramExercise{}
+	p.Rules = []ProgressionRule{}
+	return p, err
+}
+
+// loadProgramDetails fills the exercises and rules of programs, rule increments
+// are in kilograms
+func loadProgramDetails(db *sql.DB, programs []Program) error {
+	if len(programs) == 0 {
+		return nil
+	}
+
+	ids := []int64{}
+	byID := map[int]*Program{}
+	for i := range programs {
+		ids = append(ids, int64(programs[i].ID))
+		byID[programs[i].ID] = &programs[i]
+	}
+
+	rows, err := db.Query(`SELECT pe.id, pe.week, pe.day, pe.exercise_id, pe.sets, pe.reps, pe.percent, pe.amrap, pe.program_id
+		FROM program_exercises pe
+		WHERE pe.program_id = ANY($1)
+		ORDER BY pe.week, pe.day, pe.position, pe.id`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e ProgramExercise
+		var programID int
+		err := rows.Scan(&e.ID, &e.Week, &e.Day, &e.ExerciseID, &e.Sets, &e.Reps, &e.Percent, &e.AMRAP, &programID)
+		if err != nil {
+			return err
+		}
+		program := byID[programID]
+		program.Exercises = append(program.Exercises, e)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rules, err := db.Query(`SELECT pr.id, pr.exercise_id, pr.increment::text, pr.advance_on, pr.failures_to_reset, pr.reset_percent, pr.program_id
+		FROM program_rules pr
+		WHERE pr.program_id = ANY($1)
+		ORDER BY pr.id`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rules.Close()
+
+	for rules.Next() {
+		var rule ProgressionRule
+		var kg string
+		var programID int
+		err := rules.Scan(&rule.ID, &rule.ExerciseID, &kg, &rule.AdvanceOn, &rule.FailuresToReset, &rule.ResetPercent, &programID)
+		if err != nil {
+			return err
+		}
+		if rule.Increment, err = fromKg(kg, UnitKg); err != nil {
+			return err
+		}
+		rule.WeightUnit = UnitKg
+		program := byID[programID]
+		program.Rules = append(program.Rules, rule)
+	}
+
+	return rules.Err()
+}
+
+// GetPrograms gets every program by name
+func GetPrograms(db *sql.DB) ([]Program, error) {
+	rows, err := db.Query(`SELECT ` + programColumns + ` FROM programs p ORDER BY p.name, p.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	programs := []Program{}
+	for rows.Next() {
+		program, err := scanProgram(rows)
+		if err != nil {
+			return nil, err
+		}
+		programs = append(programs, program)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return programs, loadProgramDetails(db, programs)
+}
+
+// GetProgram gets a program by ID
+func GetProgram(db *sql.DB, id int) (Program, error) {
+	program, err := scanProgram(db.QueryRow(`SELECT `+programColumns+` FROM programs p WHERE p.id = $1`, id))
+	if err != nil {
+		return program, err
+	}
+
+	programs := []Program{program}
+	err = loadProgramDetails(db, programs)
+	return programs[0], err
+}
+
+// CreateProgram creates a program along its exercises and rules, rule increments
+// are in their weight unit
+func CreateProgram(db *sql.DB, p Program) (Program, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return p, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`INSERT INTO programs(name, description, weeks, days_per_week, user_id)
+		VALUES
+		($1, $2, $3, $4, $5)
+		RETURNING id`, p.Name, p.Description, p.Weeks, p.DaysPerWeek, p.UserID).Scan(&p.ID)
+	if err != nil {
+		return p, err
+	}
+
+	for i, e := range p.Exercises {
+		_, err := tx.Exec(`INSERT INTO program_exercises(week, day, position, sets, reps, percent, amrap, program_id, exercise_id)
+			VALUES
+			($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			e.Week, e.Day, i+1, e.Sets, e.Reps, e.Percent, e.AMRAP, p.ID, e.ExerciseID)
+		if err != nil {
+			return p, err
+		}
+	}
+	for _, rule := range p.Rules {
+		_, err := tx.Exec(`INSERT INTO program_rules(increment, advance_on, failures_to_reset, reset_percent, program_id, exercise_id)
+			VALUES
+			($1, $2, $3, $4, $5, $6)`,
+			toKg(rule.Increment, rule.WeightUnit), rule.AdvanceOn, rule.FailuresToReset, rule.ResetPercent, p.ID, rule.ExerciseID)
+		if err != nil {
+			return p, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return p, err
+	}
+
+	return GetProgram(db, p.ID)
+}
+
+// DeleteProgram deletes a program along its enrollments
+func DeleteProgram(db *sql.DB, id int) (int, error) {
+	res, err := db.Exec(`DELETE FROM programs WHERE id = $1`, id)
+	if err != nil {
+		return 0, err
+	}
+	count, err := res.RowsAffected()
+	return int(count), err
+}
+
+/////////////////////
+//// ENROLLMENTS ////
+/////////////////////
+
+// enrollmentColumns are the enrollments columns scanned by scanEnrollment
+const enrollmentColumns = `e.id, e.program_id, to_char(e.started_on, 'YYYY-MM-DD'), e.cycles, e.status, e.created_at, e.user_id`
+
+// scanEnrollment scans a row selected with enrollmentColumns
+func scanEnrollment(row interface{ Scan(...interface{}) error }) (Enrollment, error) {
+	var e Enrollment
+	err := row.Scan(&e.ID, &e.ProgramID, &e.StartedOn, &e.Cycles, &e.Status, &e.CreatedAt, &e.UserID)
+	e.TrainingMaxes = []TrainingMax{}
+	return e, err
+}
+
+// loadTrainingMaxes fills the training maxes of enrollments in kilograms
+func loadTrainingMaxes(db *sql.DB, enrollments []Enrollment) error {
+	if len(enrollments) == 0 {
+		return nil
+	}
+
+	ids := []int64{}
+	byID := map[int]*Enrollment{}
+	for i := range enrollments {
+		ids = append(ids, int64(enrollments[i].ID))
+		byID[enrollments[i].ID] = &enrollments[i]
+	}
+
+	rows, err := db.Query(`SELECT tm.exercise_id, tm.weight::text, tm.failures, tm.updated_at, tm.enrollment_id
+		FROM training_maxes tm
+		WHERE tm.enrollment_id = ANY($1)
+		ORDER BY tm.id`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tm TrainingMax
+		var kg string
+		var enrollmentID int
+		if err := rows.Scan(&tm.ExerciseID, &kg, &tm.Failures, &tm.UpdatedAt, &enrollmentID); err != nil {
+			return err
+		}
+		if tm.Weight, err = fromKg(kg, UnitKg); err != nil {
+			return err
+		}
+		tm.WeightUnit = UnitKg
+		enrollment := byID[enrollmentID]
+		enrollment.TrainingMaxes = append(enrollment.TrainingMaxes, tm)
+	}
+
+	return rows.Err()
+}
+
+// GetEnrollments gets every enrollment of user, latest first
+func GetEnrollments(db *sql.DB, userID int) ([]Enrollment, error) {
+	rows, err := db.Query(`SELECT `+enrollmentColumns+` FROM enrollments e
+		WHERE e.user_id = $1
+		ORDER BY e.started_on DESC, e.id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	enrollments := []Enrollment{}
+	for rows.Next() {
+		enrollment, err := scanEnrollment(rows)
+		if err != nil {
+			return nil, err
+		}
+		enrollments = append(enrollments, enrollment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return enrollments, loadTrainingMaxes(db, enrollments)
+}
+
+// GetEnrollment gets an enrollment of user by ID
+func GetEnrollment(db *sql.DB, userID int, id int) (Enrollment, error) {
+	enrollment, err := scanEnrollment(db.QueryRow(`SELECT `+enrollmentColumns+` FROM enrollments e
+		WHERE e.id = $1 AND e.user_id = $2`, id, userID))
+	if err != nil {
+		return enrollment, err
+	}
+
+	enrollments := []Enrollment{enrollment}
+	err = loadTrainingMaxes(db, enrollments)
+	return enrollments[0], err
+}
+
+// Enroll enrolls a user in program p and schedules every day of its cycles from
+// the day e starts on. Training maxes are in their weight unit
+func Enroll(db *sql.DB, p Program, e Enrollment) (Enrollment, error) {
+	start, err := time.Parse("2006-01-02", e.StartedOn)
+	if err != nil {
+		return e, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return e, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`INSERT INTO enrollments(started_on, cycles, status, program_id, user_id)
+		VALUES
+		($1, $2, $3, $4, $5)
+		RETURNING id`, e.StartedOn, e.Cycles, EnrollmentActive, p.ID, e.UserID).Scan(&e.ID)
+	if err != nil {
+		return e, err
+	}
+
+	for _, tm := range e.TrainingMaxes {
+		_, err := tx.Exec(`INSERT INTO training_maxes(weight, enrollment_id, exercise_id)
+			VALUES
+			($1, $2, $3)`, toKg(tm.Weight, tm.WeightUnit), e.ID, tm.ExerciseID)
+		if err != nil {
+			return e, err
+		}
+	}
+
+	// only days with exercises are scheduled
+	days := map[[2]int]bool{}
+	for _, pe := range p.Exercises {
+		days[[2]int{pe.Week, pe.Day}] = true
+	}
+	for cycle := 1; cycle <= e.Cycles; cycle++ {
+		for week := 1; week <= p.Weeks; week++ {
+			for day := 1; day <= p.DaysPerWeek; day++ {
+				if !days[[2]int{week, day}] {
+					continue
+				}
+				on := start.AddDate(0, 0, scheduleOffset(cycle, week, day, p))
+				_, err := tx.Exec(`INSERT INTO scheduled_workouts(cycle, week, day, scheduled_on, enrollment_id)
+					VALUES
+					($1, $2, $3, $4, $5)`, cycle, week, day, on.Format("2006-01-02"), e.ID)
+				if err != nil {
+					return e, err
+				}
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return e, err
+	}
+
+	return GetEnrollment(db, e.UserID, e.ID)
+}
+
+// CancelEnrollment cancels an active enrollment of user, its results are kept
+func CancelEnrollment(db *sql.DB, userID int, id int) (int, error) {
+	res, err := db.Exec(`UPDATE enrollments SET status = $1
+		WHERE id = $2 AND user_id = $3 AND status = $4`, EnrollmentCancelled, id, userID, EnrollmentActive)
+	if err != nil {
+		return 0, err
+	}
+	count, err := res.RowsAffected()
+	return int(count), err
+}
+
+// GetScheduledWorkouts gets the scheduled workouts of user by date, with their
+// prescriptions in unit. Cancelled enrollments are left out unless filtered by
+func GetScheduledWorkouts(db *sql.DB, userID int, filter ScheduleFilter, unit string) ([]ScheduledWorkout, error) {
+	where := []string{"e.user_id = $1"}
+	args := []interface{}{userID}
+	if filter.EnrollmentID != 0 {
+		args = append(args, filter.EnrollmentID)
+		where = append(where, fmt.Sprintf("e.id = $%d", len(args)))
+	} else {
+		args = append(args, EnrollmentCancelled)
+		where = append(where, fmt.Sprintf("e.status <> $%d", len(args)))
+	}
+	if filter.From != "" {
+		args = append(args, filter.From)
+		where = append(where, fmt.Sprintf("sw.scheduled_on >= $%d::date", len(args)))
+	}
+	if filter.To != "" {
+		args = append(args, filter.To)
+		where = append(where, fmt.Sprintf("sw.scheduled_on <= $%d::date", len(args)))
+	}
+
+	rows, err := db.Query(`SELECT sw.id, sw.enrollment_id, e.program_id, p.name, sw.cycle, sw.week, sw.day,
+		to_char(sw.scheduled_on, 'YYYY-MM-DD'), sw.completed_at, sw.workout_id
+		FROM scheduled_workouts sw
+		JOIN enrollments e ON e.id = sw.enrollment_id
+		JOIN programs p ON p.id = e.program_id
+		WHERE `+strings.Join(where, ` AND `)+`
+		ORDER BY sw.scheduled_on, sw.id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workouts := []ScheduledWorkout{}
+	for rows.Next() {
+		var sw ScheduledWorkout
+		var workoutID sql.NullInt64
+		err := rows.Scan(&sw.ID, &sw.EnrollmentID, &sw.ProgramID, &sw.ProgramName, &sw.Cycle, &sw.Week, &sw.Day,
+			&sw.ScheduledOn, &sw.CompletedAt, &workoutID)
+		if err != nil {
+			return nil, err
+		}
+		if workoutID.Valid {
+			id := int(workoutID.Int64)
+			sw.WorkoutID = &id
+		}
+		sw.Exercises = []Prescription{}
+		workouts = append(workouts, sw)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return workouts, loadPrescriptions(db, workouts, unit)
+}
+
+// loadPrescriptions fills the prescriptions of scheduled workouts in unit from
+// their program and the current training maxes
+func loadPrescriptions(db *sql.DB, workouts []ScheduledWorkout, unit string) error {
+	if len(workouts) == 0 {
+		return nil
+	}
+
+	ids := []int64{}
+	for _, sw := range workouts {
+		ids = append(ids, int64(sw.ID))
+	}
+
+	rows, err := db.Query(`SELECT sw.id, pe.exercise_id, x.name, pe.sets, pe.reps, pe.percent, pe.amrap, tm.weight::text, pr.outcome
+		FROM scheduled_workouts sw
+		JOIN enrollments e ON e.id = sw.enrollment_id
+		JOIN program_exercises pe ON pe.program_id = e.program_id AND pe.week = sw.week AND pe.day = sw.day
+		JOIN exercise x ON x.id = pe.exercise_id
+		JOIN training_maxes tm ON tm.enrollment_id = e.id AND tm.exercise_id = pe.exercise_id
+		LEFT JOIN program_results pr ON pr.scheduled_workout_id = sw.id AND pr.exercise_id = pe.exercise_id
+		WHERE sw.id = ANY($1)
+		ORDER BY pe.position, pe.id`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	byID := map[int]*ScheduledWorkout{}
+	for i := range workouts {
+		byID[workouts[i].ID] = &workouts[i]
+	}
+	for rows.Next() {
+		var id int
+		var kg string
+		var outcome sql.NullString
+		p := Prescription{WeightUnit: unit}
+		err := rows.Scan(&id, &p.ExerciseID, &p.ExerciseName, &p.Sets, &p.Reps, &p.Percent, &p.AMRAP, &kg, &outcome)
+		if err != nil {
+			return err
+		}
+		tm, ok := new(big.Rat).SetString(kg)
+		if !ok {
+			return fmt.Errorf("invalid stored weight %s", kg)
+		}
+		p.Weight = prescribedWeight(tm, p.Percent, unit)
+		if outcome.Valid {
+			p.Outcome = &outcome.String
+		}
+		sw := byID[id]
+		sw.Exercises = append(sw.Exercises, p)
+	}
+
+	return rows.Err()
+}
+
+// ProgramResult is the DB response struct from program_results table, the
+// outcome of an exercise of a scheduled workout and the set that decided it
+type ProgramResult struct {
+	ID                 int       `json:"id"`
+	Outcome            string    `json:"outcome"`
+	CreatedAt          time.Time `json:"created_at"`
+	EnrollmentID       int       `json:"enrollment_id"`
+	ScheduledWorkoutID int       `json:"scheduled_workout_id"`
+	ScheduledOn        string    `json:"scheduled_on"`
+	ExerciseID         int       `json:"exercise_id"`
+	RecordID           *int      `json:"record_id"`
+}
+
+// GetProgramResults gets every program result of user, oldest first
+func GetProgramResults(db *sql.DB, userID int) ([]ProgramResult, error) {
+	rows, err := db.Query(`SELECT pr.id, pr.outcome, pr.created_at, sw.enrollment_id, sw.id,
+		to_char(sw.scheduled_on, 'YYYY-MM-DD'), pr.exercise_id, pr.record_id
+		FROM program_results pr
+		JOIN scheduled_workouts sw ON sw.id = pr.scheduled_workout_id
+		JOIN enrollments e ON e.id = sw.enrollment_id
+		WHERE e.user_id = $1
+		ORDER BY sw.scheduled_on, pr.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []ProgramResult{}
+	for rows.Next() {
+		var r ProgramResult
+		err := rows.Scan(&r.ID, &r.Outcome, &r.CreatedAt, &r.EnrollmentID, &r.ScheduledWorkoutID,
+			&r.ScheduledOn, &r.ExerciseID, &r.RecordID)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+
+	return results, rows.Err()
+}
+
+// prescribedSets lists what program prescribes for exercise on a scheduled
+// workout, heaviest first
+func prescribedSets(tx *sql.Tx, scheduledWorkoutID int, programID int, exerciseID int) ([]Prescription, error) {
+	rows, err := tx.Query(`SELECT pe.sets, pe.reps, pe.percent
+		FROM program_exercises pe
+		JOIN scheduled_workouts sw ON sw.week = pe.week AND sw.day = pe.day
+		WHERE sw.id = $1 AND pe.program_id = $2 AND pe.exercise_id = $3
+		ORDER BY pe.percent DESC, pe.reps DESC`, scheduledWorkoutID, programID, exerciseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prescribed := []Prescription{}
+	for rows.Next() {
+		p := Prescription{ExerciseID: exerciseID}
+		if err := rows.Scan(&p.Sets, &p.Reps, &p.Percent); err != nil {
+			return nil, err
+		}
+		prescribed = append(prescribed, p)
+	}
+	return prescribed, rows.Err()
+}
+
+// loggedSets lists the working and failure sets user logged of the exercise of
+// record on its day, weights are in the unit they were logged in
+func loggedSets(tx *sql.Tx, record Record) ([]Record, error) {
+	rows, err := tx.Query(`SELECT r.weight::text, r.weight_unit, r.reps FROM user_records r
+		WHERE r.user_id = $1 AND r.exercise_id = $2 AND r.date_performed = $3::date AND r.set_type IN ($4, $5)
+		ORDER BY r.id`, record.UserID, record.ExerciseID, record.DatePerformed, SetWorking, SetFailure)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sets := []Record{}
+	for rows.Next() {
+		var kg string
+		var set Record
+		if err := rows.Scan(&kg, &set.WeightUnit, &set.Reps); err != nil {
+			return nil, err
+		}
+		if set.Weight, err = fromKg(kg, set.WeightUnit); err != nil {
+			return nil, err
+		}
+		sets = append(sets, set)
+	}
+	return sets, rows.Err()
+}
+
+// setsMet checks every prescribed set, heaviest first, is matched by its own
+// logged set at least as heavy as prescribed from the training max tm, with at
+// least its reps. Each takes the matching set with the fewest reps, keeping the
+// longer ones for lighter prescriptions
+func setsMet(tm *big.Rat, prescribed []Prescription, logged []Record) bool {
+	used := make([]bool, len(logged))
+	for _, p := range prescribed {
+		for n := 0; n < p.Sets; n++ {
+			match := -1
+			for i, l := range logged {
+				if used[i] || l.Reps < p.Reps || l.Weight < prescribedWeight(tm, p.Percent, l.WeightUnit) {
+					continue
+				}
+				if match == -1 || l.Reps < logged[match].Reps {
+					match = i
+				}
+			}
+			if match == -1 {
+				return false
+			}
+			used[match] = true
+		}
+	}
+	return true
+}
+
+// ApplyProgression decides the result of an exercise of the latest pending
+// scheduled workout of user that prescribes it, on or before the day record was
+// performed. The sets of a day decide at most one scheduled workout of an
+// enrollment, older pending ones are skipped. The result waits until as many working sets as prescribed were
+// logged that day, or until the workout of record is closed. It is a success
+// when every prescribed set was matched by a set at least as heavy with at least
+// its reps. A success advances the training max by the program rule, a failure
+// resets it after enough failures in a row. Returns nil when record doesn't
+// decide a result, weights are in kilograms
+func ApplyProgression(db *sql.DB, record Record) (*ProgressionEvent, error) {
+	if record.SetType != SetWorking && record.SetType != SetFailure {
+		return nil, nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ev := ProgressionEvent{ExerciseID: record.ExerciseID, WeightUnit: UnitKg}
+	var programID, week, weeks int
+	err = tx.QueryRow(`SELECT sw.id, sw.enrollment_id, sw.week, p.id, p.weeks
+		FROM scheduled_workouts sw
+		JOIN enrollments e ON e.id = sw.enrollment_id
+		JOIN programs p ON p.id = e.program_id
+		WHERE e.user_id = $1 AND e.status = $2 AND sw.completed_at IS NULL AND sw.scheduled_on <= $3::date
+		AND EXISTS (SELECT 1 FROM program_exercises pe
+			WHERE pe.program_id = p.id AND pe.week = sw.week AND pe.day = sw.day AND pe.exercise_id = $4)
+		AND NOT EXISTS (SELECT 1 FROM program_results pr
+			WHERE pr.scheduled_workout_id = sw.id AND pr.exercise_id = $4)
+		AND NOT EXISTS (SELECT 1 FROM program_results pr
+			JOIN scheduled_workouts dsw ON dsw.id = pr.scheduled_workout_id
+			WHERE dsw.enrollment_id = sw.enrollment_id AND pr.exercise_id = $4 AND pr.date_performed = $3::date)
+		ORDER BY sw.scheduled_on DESC, sw.id DESC
+		LIMIT 1
+		FOR UPDATE OF sw`, record.UserID, EnrollmentActive, record.DatePerformed, record.ExerciseID).Scan(
+		&ev.ScheduledWorkoutID, &ev.EnrollmentID, &week, &programID, &weeks)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var kg string
+	err = tx.QueryRow(`SELECT tm.weight::text, tm.failures FROM training_maxes tm
+		WHERE tm.enrollment_id = $1 AND tm.exercise_id = $2
+		FOR UPDATE`, ev.EnrollmentID, record.ExerciseID).Scan(&kg, &ev.Failures)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	tm, ok := new(big.Rat).SetString(kg)
+	if !ok {
+		return nil, fmt.Errorf("invalid stored weight %s", kg)
+	}
+	failures := ev.Failures
+
+	prescribed, err := prescribedSets(tx, ev.ScheduledWorkoutID, programID, record.ExerciseID)
+	if err != nil {
+		return nil, err
+	}
+	count := 0
+	for _, p := range prescribed {
+		count += p.Sets
+	}
+	logged, err := loggedSets(tx, record)
+	if err != nil {
+		return nil, err
+	}
+	closed := false
+	if record.WorkoutID != 0 {
+		err = tx.QueryRow(`SELECT ended_at IS NOT NULL FROM workouts WHERE id = $1`, record.WorkoutID).Scan(&closed)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+	if len(logged) < count && !closed {
+		return nil, nil
+	}
+
+	// exercises without a rule keep their training max
+	var rule ProgressionRule
+	var increment string
+	err = tx.QueryRow(`SELECT pr.increment::text, pr.advance_on, pr.failures_to_reset, pr.reset_percent
+		FROM program_rules pr
+		WHERE pr.program_id = $1 AND pr.exercise_id = $2`, programID, record.ExerciseID).Scan(
+		&increment, &rule.AdvanceOn, &rule.FailuresToReset, &rule.ResetPercent)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	hasRule := err == nil
+
+	previous := new(big.Rat).Set(tm)
+	if setsMet(tm, prescribed, logged) {
+		ev.Outcome = OutcomeSuccess
+		ev.Failures = 0
+		if hasRule && (rule.AdvanceOn == AdvanceSession || week == weeks) {
+			step, ok := new(big.Rat).SetString(increment)
+			if !ok {
+				return nil, fmt.Errorf("invalid stored weight %s", increment)
+			}
+			tm.Add(tm, step)
+		}
+	} else {
+		ev.Outcome = OutcomeFailure
+		ev.Failures++
+		if hasRule && rule.FailuresToReset > 0 && ev.Failures >= rule.FailuresToReset {
+			tm = percentOf(tm, rule.ResetPercent)
+			ev.Failures = 0
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE training_maxes SET weight = $1, failures = $2, updated_at = NOW()
+		WHERE enrollment_id = $3 AND exercise_id = $4`, tm.FloatString(11), ev.Failures, ev.EnrollmentID, record.ExerciseID)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`INSERT INTO program_results(outcome, date_performed, previous_weight, previous_failures,
+		scheduled_workout_id, exercise_id, record_id)
+		VALUES
+		($1, $2::date, $3, $4, $5, $6, $7)`, ev.Outcome, record.DatePerformed, previous.FloatString(11), failures,
+		ev.ScheduledWorkoutID, record.ExerciseID, record.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the scheduled workout is completed once every exercise has a result
+	var workoutID sql.NullInt64
+	if record.WorkoutID != 0 {
+		workoutID = sql.NullInt64{Int64: int64(record.WorkoutID), Valid: true}
+	}
+	_, err = tx.Exec(`UPDATE scheduled_workouts sw SET completed_at = NOW(), workout_id = $2
+		WHERE sw.id = $1 AND NOT EXISTS (SELECT 1 FROM program_exercises pe
+			JOIN enrollments e ON e.program_id = pe.program_id
+			WHERE e.id = sw.enrollment_id AND pe.week = sw.week AND pe.day = sw.day
+			AND NOT EXISTS (SELECT 1 FROM program_results pr
+				WHERE pr.scheduled_workout_id = sw.id AND pr.exercise_id = pe.exercise_id))`,
+		ev.ScheduledWorkoutID, workoutID)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`UPDATE enrollments e SET status = $2
+		WHERE e.id = $1 AND NOT EXISTS (SELECT 1 FROM scheduled_workouts sw
+			WHERE sw.enrollment_id = e.id AND sw.completed_at IS NULL)`, ev.EnrollmentID, EnrollmentCompleted)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	ev.Previous = roundDecimal(previous)
+	ev.TrainingMax = roundDecimal(tm)
+	return &ev, nil
+}
+
+// UndoProgression undoes the program results decided by the sets user logged of
+// an exercise on a day, putting back the training max they replaced and
+// reopening their scheduled workout. Results a later result of the same training
+// max built on are kept
+func UndoProgression(db *sql.DB, userID int, exerciseID int, date string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	type undo struct {
+		resultID           int
+		scheduledWorkoutID int
+		enrollmentID       int
+		weight             string
+		failures           int
+	}
+	rows, err := tx.Query(`SELECT pr.id, sw.id, sw.enrollment_id, pr.previous_weight::text, pr.previous_failures
+		FROM program_results pr
+		JOIN scheduled_workouts sw ON sw.id = pr.scheduled_workout_id
+		JOIN enrollments e ON e.id = sw.enrollment_id
+		WHERE e.user_id = $1 AND pr.exercise_id = $2 AND pr.date_performed = $3::date AND pr.previous_weight IS NOT NULL
+		AND NOT EXISTS (SELECT 1 FROM program_results later
+			JOIN scheduled_workouts lsw ON lsw.id = later.scheduled_workout_id
+			WHERE lsw.enrollment_id = sw.enrollment_id AND later.exercise_id = pr.exercise_id AND later.id > pr.id)
+		FOR UPDATE OF pr`, userID, exerciseID, date)
+	if err != nil {
+		return err
+	}
+	undos := []undo{}
+	for rows.Next() {
+		var u undo
+		if err := rows.Scan(&u.resultID, &u.scheduledWorkoutID, &u.enrollmentID, &u.weight, &u.failures); err != nil {
+			rows.Close()
+			return err
+		}
+		undos = append(undos, u)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, u := range undos {
+		_, err := tx.Exec(`UPDATE training_maxes SET weight = $1, failures = $2, updated_at = NOW()
+			WHERE enrollment_id = $3 AND exercise_id = $4`, u.weight, u.failures, u.enrollmentID, exerciseID)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM program_results WHERE id = $1`, u.resultID); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE scheduled_workouts SET completed_at = NULL, workout_id = NULL WHERE id = $1`, u.scheduledWorkoutID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE enrollments SET status = $2 WHERE id = $1 AND status = $3`,
+			u.enrollmentID, EnrollmentActive, EnrollmentCompleted)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RedecideProgression decides again the program results of the sets user logged
+// of an exercise on a day, once they were edited or deleted
+func RedecideProgression(db *sql.DB, userID int, exerciseID int, date string) (*ProgressionEvent, error) {
+	if err := UndoProgression(db, userID, exerciseID, date); err != nil {
+		return nil, err
+	}
+
+	last, err := scanRecord(db.QueryRow(`SELECT `+recordColumns+` FROM user_records r
+		WHERE r.user_id = $1 AND r.exercise_id = $2 AND r.date_performed = $3::date AND r.set_type IN ($4, $5)
+		ORDER BY r.id DESC
+		LIMIT 1`, userID, exerciseID, date, SetWorking, SetFailure))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ApplyProgression(db, last)
+}
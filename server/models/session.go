@@ -0,0 +1,133 @@
+package models
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// Session is a logged in device, its ID is the jti claim of the access tokens
+// and the family of the refresh tokens issued to it
+type Session struct {
+	ID        string    `json:"id"`
+	UserID    int       `json:"-"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	Current   bool      `json:"current"`
+}
+
+// sessionKey is the redis key holding a session by ID
+func sessionKey(id string) string {
+	return "session:" + id
+}
+
+// userSessionsKey is the redis key holding every session ID of a user
+func userSessionsKey(userID int) string {
+	return "user_sessions:" + strconv.Itoa(userID)
+}
+
+// CreateSession stores a new session and adds it to the user sessions
+func CreateSession(c *redis.Client, s Session, ttl time.Duration) error {
+	_, err := c.TxPipelined(func(pipe redis.Pipeliner) error {
+		key := sessionKey(s.ID)
+		pipe.HMSet(key, map[string]interface{}{
+			"user_id":    s.UserID,
+			"user_agent": s.UserAgent,
+			"created_at": s.CreatedAt.Unix(),
+			"last_seen":  s.LastSeen.Unix(),
+		})
+		pipe.Expire(key, ttl)
+		pipe.SAdd(userSessionsKey(s.UserID), s.ID)
+		return nil
+	})
+	return err
+}
+
+// GetSession gets session by ID, returns redis.Nil if it does not exist
+func GetSession(c *redis.Client, id string) (Session, error) {
+	session := Session{ID: id}
+	fields, err := c.HGetAll(sessionKey(id)).Result()
+	if err != nil {
+		return session, err
+	}
+	if len(fields) == 0 {
+		return session, redis.Nil
+	}
+
+	session.UserID, err = strconv.Atoi(fields["user_id"])
+	if err != nil {
+		return session, err
+	}
+	createdAt, _ := strconv.ParseInt(fields["created_at"], 10, 64)
+	lastSeen, _ := strconv.ParseInt(fields["last_seen"], 10, 64)
+	session.UserAgent = fields["user_agent"]
+	session.CreatedAt = time.Unix(createdAt, 0)
+	session.LastSeen = time.Unix(lastSeen, 0)
+
+	return session, nil
+}
+
+// TouchSession updates session last seen time
+func TouchSession(c *redis.Client, id string) error {
+	return c.HSet(sessionKey(id), "last_seen", time.Now().Unix()).Err()
+}
+
+// ExtendSession pushes back session expiration
+func ExtendSession(c *redis.Client, id string, ttl time.Duration) error {
+	return c.Expire(sessionKey(id), ttl).Err()
+}
+
+// GetUserSessions gets every active session of a user
+func GetUserSessions(c *redis.Client, userID int) ([]Session, error) {
+	ids, err := c.SMembers(userSessionsKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []Session{}
+	for _, id := range ids {
+		session, err := GetSession(c, id)
+		if err == redis.Nil {
+			// session expired on its own, forget about it
+			c.SRem(userSessionsKey(userID), id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+// DeleteSession revokes session and every refresh token issued to it
+func DeleteSession(c *redis.Client, s Session) error {
+	_, err := c.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Del(sessionKey(s.ID))
+		pipe.SRem(userSessionsKey(s.UserID), s.ID)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return RevokeRefreshFamily(c, s.ID)
+}
+
+// DeleteUserSessions revokes every session of a user
+func DeleteUserSessions(c *redis.Client, userID int) error {
+	sessions, err := GetUserSessions(c, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		if err := DeleteSession(c, session); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
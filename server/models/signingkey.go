@@ -0,0 +1,90 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// signingKeyLock is the postgres advisory lock taken while rotating keys,
+// so server instances don't all create a key at once
+const signingKeyLock = 4751
+
+// SigningKey is the DB response struct from signing_keys table,
+// PrivateKey is PKCS #8 PEM encoded
+type SigningKey struct {
+	ID          string
+	Alg         string
+	PrivateKey  string
+	CreatedAt   time.Time
+	ActivatesAt time.Time
+}
+
+// GetSigningKeys gets every signing key, oldest activation first
+func GetSigningKeys(db *sql.DB) ([]SigningKey, error) {
+	rows, err := db.Query(`SELECT k.kid, k.alg, k.private_key, k.created_at, k.activates_at
+		FROM signing_keys k
+		ORDER BY k.activates_at, k.created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []SigningKey
+	for rows.Next() {
+		var key SigningKey
+		err := rows.Scan(
+			&key.ID,
+			&key.Alg,
+			&key.PrivateKey,
+			&key.CreatedAt,
+			&key.ActivatesAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// CreateSigningKeyIfStale creates k unless a key of the same algorithm was created
+// less than maxAge ago, returns whether k was created
+func CreateSigningKeyIfStale(db *sql.DB, k SigningKey, maxAge time.Duration) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`SELECT pg_advisory_xact_lock($1)`, signingKeyLock)
+	if err != nil {
+		return false, err
+	}
+
+	var fresh bool
+	err = tx.QueryRow(`SELECT EXISTS (
+		SELECT 1 FROM signing_keys WHERE alg = $1 AND created_at > $2
+	)`, k.Alg, time.Now().Add(-maxAge)).Scan(&fresh)
+	if err != nil {
+		return false, err
+	}
+	if fresh {
+		return false, nil
+	}
+
+	_, err = tx.Exec(`INSERT INTO signing_keys(kid, alg, private_key, activates_at)
+		VALUES
+		($1, $2, $3, $4)`, k.ID, k.Alg, k.PrivateKey, k.ActivatesAt)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// DeleteSigningKey deletes signing key by ID
+func DeleteSigningKey(db *sql.DB, id string) error {
+	_, err := db.Exec(`DELETE FROM signing_keys WHERE kid = $1`, id)
+	return err
+}
@@ -0,0 +1,207 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"math/big"
+)
+
+// Formulas estimating a one-rep max from a set
+const (
+	FormulaEpley   = "epley"
+	FormulaBrzycki = "brzycki"
+	FormulaRPE     = "rpe"
+)
+
+// Formulas lists every valid one-rep max formula
+var Formulas = map[string]bool{
+	FormulaEpley:   true,
+	FormulaBrzycki: true,
+	FormulaRPE:     true,
+}
+
+// MaxEstimateReps is the most reps a set can have to estimate a one-rep max,
+// past it the formulas stop being meaningful
+const MaxEstimateReps = 12
+
+// rpeChart is the Tuchscherer RPE chart in tenths of a percent of the one-rep max,
+// indexed by half reps to failure from 1, the reps done plus the reps in reserve.
+// 5 reps at RPE 8 and 7 reps at RPE 10 both read rpeChart[2*7-2]
+var rpeChart = []int64{
+	1000, 978, 955, 939, 922, 907, 892, 878, 863, 850,
+	837, 824, 811, 799, 786, 774, 762, 751, 739, 723,
+	707, 694, 680, 667, 653, 640, 626, 613, 599, 586,
+	572,
+}
+
+// rpeChartPercent returns the percent of the one-rep max in tenths a set of reps
+// at rir reps in reserve is done at, false when it is off the chart
+func rpeChartPercent(reps int, rir Decimal) (int64, bool) {
+	// half reps to failure minus the first rep
+	i := (int64(reps)*decimalScale+int64(rir))/effortStep - 2
+	if rir%effortStep != 0 || i < 0 || i >= int64(len(rpeChart)) {
+		return 0, false
+	}
+	return rpeChart[i], true
+}
+
+// OneRepMax estimates the one-rep max of a set of reps at weight with formula,
+// false when the set can't be estimated: no reps, too many reps, or no RPE for
+// the RPE chart
+func OneRepMax(formula string, weight Decimal, reps int, rir *Decimal) (Decimal, bool) {
+	if weight <= 0 || reps < 1 || reps > MaxEstimateReps {
+		return 0, false
+	}
+
+	w := weight.rat()
+	switch formula {
+	case FormulaEpley:
+		if reps == 1 {
+			return weight, true
+		}
+		return roundDecimal(w.Mul(w, big.NewRat(int64(30+reps), 30))), true
+	case FormulaBrzycki:
+		return roundDecimal(w.Mul(w, big.NewRat(36, int64(37-reps)))), true
+	case FormulaRPE:
+		if rir == nil {
+			return 0, false
+		}
+		percent, ok := rpeChartPercent(reps, *rir)
+		if !ok {
+			return 0, false
+		}
+		return roundDecimal(w.Mul(w, big.NewRat(1000, percent))), true
+	}
+	return 0, false
+}
+
+// RepMax projects the most weight that can be lifted for reps from a one-rep max
+// with formula, the inverse of OneRepMax
+func RepMax(formula string, oneRepMax Decimal, reps int) Decimal {
+	w := oneRepMax.rat()
+	switch formula {
+	case FormulaBrzycki:
+		w.Mul(w, big.NewRat(int64(37-reps), 36))
+	case FormulaRPE:
+		percent, _ := rpeChartPercent(reps, 0)
+		w.Mul(w, big.NewRat(percent, 1000))
+	default:
+		// Epley counts a single as the one-rep max itself
+		if reps > 1 {
+			w.Mul(w, big.NewRat(30, int64(30+reps)))
+		}
+	}
+	return roundDecimal(w)
+}
+
+// EstimatedRecord is a record with its estimated one-rep max
+type EstimatedRecord struct {
+	RecordID      int      `json:"record_id"`
+	DatePerformed string   `json:"date_performed"`
+	Weight        Decimal  `json:"weight"`
+	Reps          int      `json:"reps"`
+	RPE           *Decimal `json:"rpe"`
+	OneRepMax     Decimal  `json:"e1rm"`
+}
+
+// RepMaxEstimate is the projected most weight for a number of reps
+type RepMaxEstimate struct {
+	Reps   int     `json:"reps"`
+	Weight Decimal `json:"weight"`
+}
+
+// OneRepMaxReport is the one-rep max history of an exercise for a user
+type OneRepMaxReport struct {
+	ExerciseID int               `json:"exercise_id"`
+	Formula    string            `json:"formula"`
+	WeightUnit string            `json:"weight_unit"`
+	Records    []EstimatedRecord `json:"records"`
+	History    []EstimatedRecord `json:"history"`
+	Best       *EstimatedRecord  `json:"best"`
+	RepMaxes   []RepMaxEstimate  `json:"rep_maxes"`
+}
+
+// GetExerciseRecords gets the records of user for an exercise, oldest first
+func GetExerciseRecords(db *sql.DB, userID int, exerciseID int) ([]Record, error) {
+	rows, err := db.Query(`SELECT `+recordColumns+` FROM user_records r
+		WHERE r.user_id = $1 AND r.exercise_id = $2
+		ORDER BY r.date_performed, r.workout_exercise_id, r.position, r.id`, userID, exerciseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []Record{}
+	for rows.Next() {
+		record, err := scanRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+// EstimateOneRepMax builds the one-rep max report of records, sorted oldest first,
+// in unit. Warm-up sets and sets that can't be estimated are left out, history
+// holds the best set of each day and rep maxes are projected from the best set
+func EstimateOneRepMax(exercise Exercise, records []Record, formula string, unit string) (OneRepMaxReport, error) {
+	report := OneRepMaxReport{
+		ExerciseID: exercise.ID,
+		Formula:    formula,
+		WeightUnit: unit,
+		Records:    []EstimatedRecord{},
+		History:    []EstimatedRecord{},
+		RepMaxes:   []RepMaxEstimate{},
+	}
+	if exercise.Measurement != MeasureWeightReps {
+		return report, fmt.Errorf("one-rep max can only be estimated for %s exercises", MeasureWeightReps)
+	}
+	if !Formulas[formula] {
+		return report, fmt.Errorf("unknown formula %s", formula)
+	}
+
+	for _, record := range records {
+		if record.SetType == SetWarmUp {
+			continue
+		}
+		record.ConvertWeight(unit)
+		e1rm, ok := OneRepMax(formula, record.Weight, record.Reps, record.RIR)
+		if !ok {
+			continue
+		}
+
+		estimate := EstimatedRecord{
+			RecordID:      record.ID,
+			DatePerformed: record.DatePerformed,
+			Weight:        record.Weight,
+			Reps:          record.Reps,
+			RPE:           record.RPE,
+			OneRepMax:     e1rm,
+		}
+		report.Records = append(report.Records, estimate)
+
+		last := len(report.History) - 1
+		if last < 0 || report.History[last].DatePerformed != estimate.DatePerformed {
+			report.History = append(report.History, estimate)
+		} else if e1rm > report.History[last].OneRepMax {
+			report.History[last] = estimate
+		}
+		if report.Best == nil || e1rm > report.Best.OneRepMax {
+			best := estimate
+			report.Best = &best
+		}
+	}
+
+	if report.Best != nil {
+		for reps := 1; reps <= MaxEstimateReps; reps++ {
+			report.RepMaxes = append(report.RepMaxes, RepMaxEstimate{
+				Reps:   reps,
+				Weight: RepMax(formula, report.Best.OneRepMax, reps),
+			})
+		}
+	}
+
+	return report, nil
+}
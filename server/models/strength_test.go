@@ -0,0 +1,82 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestRPEChartPercent(t *testing.T) {
+	tests := []struct {
+		reps    int
+		rir     Decimal
+		percent int64
+		ok      bool
+	}{
+		{1, 0, 1000, true},    // 1 @ 10
+		{1, 500, 978, true},   // 1 @ 9.5
+		{1, 1000, 955, true},  // 1 @ 9
+		{3, 0, 922, true},     // 3 @ 10
+		{5, 2000, 811, true},  // 5 @ 8
+		{7, 0, 811, true},     // 7 @ 10, as many reps to failure as 5 @ 8
+		{10, 0, 739, true},    // 10 @ 10
+		{12, 4000, 572, true}, // 12 @ 6, the end of the chart
+		{12, 4500, 0, false},  // past the end of the chart
+		{0, 0, 0, false},      // no reps
+		{5, 300, 0, false},    // not a half rep
+	}
+	for _, tt := range tests {
+		percent, ok := rpeChartPercent(tt.reps, tt.rir)
+		if percent != tt.percent || ok != tt.ok {
+			t.Errorf("rpeChartPercent(%d, %d) = %d, %t, want %d, %t", tt.reps, tt.rir, percent, ok, tt.percent, tt.ok)
+		}
+	}
+}
+
+func TestOneRepMax(t *testing.T) {
+	rir := func(v Decimal) *Decimal {
+		return &v
+	}
+	tests := []struct {
+		formula string
+		weight  Decimal
+		reps    int
+		rir     *Decimal
+		want    Decimal
+		ok      bool
+	}{
+		{FormulaEpley, 100000, 1, nil, 100000, true},
+		{FormulaEpley, 100000, 5, nil, 116667, true},  // 100 × 35 / 30
+		{FormulaEpley, 100000, 10, nil, 133333, true}, // 100 × 40 / 30
+		{FormulaBrzycki, 100000, 1, nil, 100000, true},
+		{FormulaBrzycki, 100000, 5, nil, 112500, true},   // 100 × 36 / 32
+		{FormulaRPE, 100000, 5, rir(2000), 123305, true}, // 100 / 0.811
+		{FormulaRPE, 100000, 5, nil, 0, false},
+		{FormulaEpley, 100000, MaxEstimateReps + 1, nil, 0, false},
+		{FormulaEpley, 100000, 0, nil, 0, false},
+		{FormulaEpley, 0, 5, nil, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := OneRepMax(tt.formula, tt.weight, tt.reps, tt.rir)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("OneRepMax(%s, %d, %d) = %d, %t, want %d, %t", tt.formula, tt.weight, tt.reps, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRepMax(t *testing.T) {
+	tests := []struct {
+		formula   string
+		oneRepMax Decimal
+		reps      int
+		want      Decimal
+	}{
+		{FormulaEpley, 100000, 1, 100000},
+		{FormulaEpley, 116667, 5, 100000},
+		{FormulaBrzycki, 112500, 5, 100000},
+		{FormulaRPE, 100000, 3, 92200},
+	}
+	for _, tt := range tests {
+		if got := RepMax(tt.formula, tt.oneRepMax, tt.reps); got != tt.want {
+			t.Errorf("RepMax(%s, %d, %d) = %d, want %d", tt.formula, tt.oneRepMax, tt.reps, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Template is the DB response struct from templates table, a reusable routine
+// with its exercises in the order they are performed
+type Template struct {
+	ID        int                `json:"id"`
+	Name      string             `json:"name"`
+	Notes     string             `json:"notes"`
+	CreatedAt time.Time          `json:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at"`
+	Exercises []TemplateExercise `json:"exercises"`
+	UserID    int                `json:"user_id"`
+}
+
+// TemplateExercise is the DB response struct from template_exercises table, the
+// target sets of an exercise of a template. Reps go from reps min to reps max
+// and rest is in seconds
+type TemplateExercise struct {
+	ID         int      `json:"id"`
+	ExerciseID int      `json:"exercise_id"`
+	Sets       int      `json:"sets"`
+	RepsMin    int      `json:"reps_min"`
+	RepsMax    int      `json:"reps_max"`
+	RPE        *Decimal `json:"rpe"`
+	Rest       int      `json:"rest"`
+	Notes      string   `json:"notes"`
+}
+
+// templateColumns are the templates columns scanned by scanTemplate
+const templateColumns = `t.id, t.name, t.notes, t.created_at, t.updated_at, t.user_id`
+
+// scanTemplate scans a row selected with templateColumns
+func scanTemplate(row interface{ Scan(...interface{}) error }) (Template, error) {
+	var t Template
+	err := row.Scan(&t.ID, &t.Name, &t.Notes, &t.CreatedAt, &t.UpdatedAt, &t.UserID)
+	t.Exercises = []TemplateExercise{}
+	return t, err
+}
+
+// loadTemplateExercises fills the exercises of templates
+func loadTemplateExercises(db *sql.DB, templates []Template) error {
+	if len(templates) == 0 {
+		return nil
+	}
+
+	ids := []int64{}
+	byID := map[int]*Template{}
+	for i := range templates {
+		ids = append(ids, int64(templates[i].ID))
+		byID[templates[i].ID] = &templates[i]
+	}
+
+	rows, err := db.Query(`SELECT te.id, te.exercise_id, te.sets, te.reps_min, te.reps_max, te.rpe, te.rest, te.notes, te.template_id
+		FROM template_exercises te
+		WHERE te.template_id = ANY($1)
+		ORDER BY te.position, te.id`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e TemplateExercise
+		var templateID int
+		err := rows.Scan(&e.ID, &e.ExerciseID, &e.Sets, &e.RepsMin, &e.RepsMax, &e.RPE, &e.Rest, &e.Notes, &templateID)
+		if err != nil {
+			return err
+		}
+		template := byID[templateID]
+		template.Exercises = append(template.Exercises, e)
+	}
+
+	return rows.Err()
+}
+
+// GetTemplates gets every template of user by name
+func GetTemplates(db *sql.DB, userID int) ([]Template, error) {
+	rows, err := db.Query(`SELECT `+templateColumns+` FROM templates t
+		WHERE t.user_id = $1
+		ORDER BY t.name, t.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []Template{}
+	for rows.Next() {
+		template, err := scanTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, template)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return templates, loadTemplateExercises(db, templates)
+}
+
+// GetTemplate gets a template of user by ID
+func GetTemplate(db *sql.DB, userID int, id int) (Template, error) {
+	template, err := scanTemplate(db.QueryRow(`SELECT `+templateColumns+` FROM templates t
+		WHERE t.id = $1 AND t.user_id = $2`, id, userID))
+	if err != nil {
+		return template, err
+	}
+
+	templates := []Template{template}
+	err = loadTemplateExercises(db, templates)
+	return templates[0], err
+}
+
+// saveTemplateExercises replaces the exercises of template t
+func saveTemplateExercises(tx *sql.Tx, t Template) error {
+	_, err := tx.Exec(`DELETE FROM template_exercises WHERE template_id = $1`, t.ID)
+	if err != nil {
+		return err
+	}
+
+	for i, e := range t.Exercises {
+		_, err := tx.Exec(`INSERT INTO template_exercises(position, sets, reps_min, reps_max, rpe, rest, notes, template_id, exercise_id)
+			VALUES
+			($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			i+1, e.Sets, e.RepsMin, e.RepsMax, e.RPE, e.Rest, e.Notes, t.ID, e.ExerciseID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateTemplate creates a template along its exercises
+func CreateTemplate(db *sql.DB, t Template) (Template, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return t, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`INSERT INTO templates(name, notes, user_id)
+		VALUES
+		($1, $2, $3)
+		RETURNING id`, t.Name, t.Notes, t.UserID).Scan(&t.ID)
+	if err != nil {
+		return t, err
+	}
+
+	if err := saveTemplateExercises(tx, t); err != nil {
+		return t, err
+	}
+	if err := tx.Commit(); err != nil {
+		return t, err
+	}
+
+	return GetTemplate(db, t.UserID, t.ID)
+}
+
+// UpdateTemplate replaces a template of user, returns sql.ErrNoRows if it does not exist
+func UpdateTemplate(db *sql.DB, t Template) (Template, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return t, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`UPDATE templates
+		SET name = $1, notes = $2, updated_at = NOW()
+		WHERE id = $3 AND user_id = $4
+		RETURNING id`, t.Name, t.Notes, t.ID, t.UserID).Scan(&t.ID)
+	if err != nil {
+		return t, err
+	}
+
+	if err := saveTemplateExercises(tx, t); err != nil {
+		return t, err
+	}
+	if err := tx.Commit(); err != nil {
+		return t, err
+	}
+
+	return GetTemplate(db, t.UserID, t.ID)
+}
+
+// DeleteTemplate deletes a template of user, workouts started from it are kept
+func DeleteTemplate(db *sql.DB, userID int, id int) (int, error) {
+	res, err := db.Exec(`DELETE FROM templates WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return 0, err
+	}
+	count, err := res.RowsAffected()
+	return int(count), err
+}
+
+// lastWeight returns the weight of the last working set user logged for an
+// exercise, in the unit it was logged in
+func lastWeight(db *sql.DB, userID int, exerciseID int) (Decimal, string, error) {
+	record, err := scanRecord(db.QueryRow(`SELECT `+recordColumns+` FROM user_records r
+		WHERE r.user_id = $1 AND r.exercise_id = $2 AND r.set_type <> $3
+		ORDER BY r.date_performed DESC, r.id DESC
+		LIMIT 1`, userID, exerciseID, SetWarmUp))
+	if err == sql.ErrNoRows {
+		return 0, UnitKg, nil
+	}
+	return record.Weight, record.WeightUnit, err
+}
+
+// StartTemplate creates a workout of user from a template starting at startedAt.
+// The workout has the exercises of the template without sets, the returned one
+// has its target sets pre-filled with the last weight used and no ID, to be
+// saved once performed
+func StartTemplate(db *sql.DB, t Template, startedAt time.Time) (Workout, error) {
+	workout := Workout{
+		StartedAt:  startedAt,
+		Notes:      t.Name,
+		TemplateID: &t.ID,
+		UserID:     t.UserID,
+	}
+	for _, e := range t.Exercises {
+		workout.Exercises = append(workout.Exercises, WorkoutExercise{ExerciseID: e.ExerciseID, Notes: e.Notes})
+	}
+
+	workout, err := CreateWorkout(db, workout)
+	if err != nil {
+		return workout, err
+	}
+
+	for i, e := range t.Exercises {
+		weight, unit, err := lastWeight(db, t.UserID, e.ExerciseID)
+		if err != nil {
+			return workout, err
+		}
+		for j := 0; j < e.Sets; j++ {
+			set := Record{
+				Weight:            weight,
+				WeightUnit:        unit,
+				Reps:              e.RepsMin,
+				RPE:               e.RPE,
+				SetType:           SetWorking,
+				DatePerformed:     workout.StartedAt.Format("2006-01-02"),
+				ExerciseID:        e.ExerciseID,
+				WorkoutID:         workout.ID,
+				WorkoutExerciseID: workout.Exercises[i].ID,
+				UserID:            t.UserID,
+			}
+			fillEffort(&set.RPE, &set.RIR)
+			workout.Exercises[i].Sets = append(workout.Exercises[i].Sets, set)
+		}
+	}
+
+	return workout, nil
+}
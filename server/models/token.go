@@ -0,0 +1,127 @@
+package models
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// RefreshToken is the server side state of an opaque refresh token
+type RefreshToken struct {
+	UserID int
+	Family string
+}
+
+// refreshTokenKey is the redis key holding a refresh token by its hash
+func refreshTokenKey(hash string) string {
+	return "refresh:" + hash
+}
+
+// refreshFamilyKey is the redis key holding every token hash of a family
+func refreshFamilyKey(family string) string {
+	return "refresh_family:" + family
+}
+
+// SaveRefreshToken stores refresh token by hash and adds it to its family
+func SaveRefreshToken(c *redis.Client, hash string, t RefreshToken, ttl time.Duration) error {
+	_, err := c.TxPipelined(func(pipe redis.Pipeliner) error {
+		key := refreshTokenKey(hash)
+		pipe.HMSet(key, map[string]interface{}{
+			"user_id": t.UserID,
+			"family":  t.Family,
+		})
+		pipe.Expire(key, ttl)
+		pipe.SAdd(refreshFamilyKey(t.Family), hash)
+		pipe.Expire(refreshFamilyKey(t.Family), ttl)
+		return nil
+	})
+	return err
+}
+
+// GetRefreshToken gets refresh token by hash, returns redis.Nil if it does not exist
+func GetRefreshToken(c *redis.Client, hash string) (RefreshToken, error) {
+	var token RefreshToken
+	fields, err := c.HGetAll(refreshTokenKey(hash)).Result()
+	if err != nil {
+		return token, err
+	}
+	if len(fields) == 0 {
+		return token, redis.Nil
+	}
+
+	token.UserID, err = strconv.Atoi(fields["user_id"])
+	if err != nil {
+		return token, err
+	}
+	token.Family = fields["family"]
+
+	return token, nil
+}
+
+// markRefreshTokenUsed flags an existing refresh token as rotated in one step,
+// so a token expiring in between is never recreated without its TTL
+var markRefreshTokenUsed = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return -1
+end
+return redis.call('HSETNX', KEYS[1], 'used_at', ARGV[1])`)
+
+// MarkRefreshTokenUsed flags refresh token as rotated, returns false if it had
+// already been rotated before and redis.Nil if it does not exist anymore
+func MarkRefreshTokenUsed(c *redis.Client, hash string) (bool, error) {
+	set, err := markRefreshTokenUsed.Run(c, []string{refreshTokenKey(hash)}, time.Now().Unix()).Int()
+	if err != nil {
+		return false, err
+	}
+	if set == -1 {
+		return false, redis.Nil
+	}
+	return set == 1, nil
+}
+
+// RevokeRefreshFamily deletes every refresh token issued in a family
+func RevokeRefreshFamily(c *redis.Client, family string) error {
+	hashes, err := c.SMembers(refreshFamilyKey(family)).Result()
+	if err != nil {
+		return err
+	}
+
+	keys := []string{refreshFamilyKey(family)}
+	for _, hash := range hashes {
+		keys = append(keys, refreshTokenKey(hash))
+	}
+
+	return c.Del(keys...).Err()
+}
+
+// oneTimeTokenKey is the redis key holding a single use token of kind by its hash
+func oneTimeTokenKey(kind string, hash string) string {
+	return kind + ":" + hash
+}
+
+// SaveOneTimeToken stores a single use token of kind pointing to user
+func SaveOneTimeToken(c *redis.Client, kind string, hash string, userID int, ttl time.Duration) error {
+	return c.Set(oneTimeTokenKey(kind, hash), userID, ttl).Err()
+}
+
+// ConsumeOneTimeToken returns the user of a single use token and deletes it,
+// returns redis.Nil if it does not exist or was already used
+func ConsumeOneTimeToken(c *redis.Client, kind string, hash string) (int, error) {
+	key := oneTimeTokenKey(kind, hash)
+	userID, err := c.Get(key).Int()
+	if err != nil {
+		return 0, err
+	}
+
+	// only the request that actually deletes the key gets to use it
+	count, err := c.Del(key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 0, redis.Nil
+	}
+
+	return userID, nil
+}
@@ -0,0 +1,101 @@
+package models
+
+import (
+	"database/sql"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// TOTPCodeRequest a struct to read a TOTP code from the request body
+type TOTPCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// TwoFactorLoginRequest a struct to read the second login step,
+// either Code or RecoveryCode must be set
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code"`
+}
+
+// ChallengeClaims a struct encoded in the token returned by the password step
+// of a two factor login
+type ChallengeClaims struct {
+	ID int `json:"id"`
+	jwt.StandardClaims
+}
+
+// TOTP is the two factor state of a user
+type TOTP struct {
+	Secret  string
+	Enabled bool
+}
+
+// GetTOTP gets two factor state of user
+func GetTOTP(db *sql.DB, userID int) (TOTP, error) {
+	var totp TOTP
+	var secret sql.NullString
+	err := db.QueryRow(
+		`SELECT u.totp_secret, u.totp_enabled_at IS NOT NULL FROM users u WHERE id = $1`,
+		userID).Scan(&secret, &totp.Enabled)
+	if err != nil {
+		return totp, err
+	}
+	totp.Secret = secret.String
+	return totp, nil
+}
+
+// SetTOTPSecret stores a pending secret, two factor stays disabled until confirmed
+func SetTOTPSecret(db *sql.DB, userID int, secret string) error {
+	_, err := db.Exec(`UPDATE users
+		SET totp_secret = $1, totp_enabled_at = NULL
+		WHERE id = $2`, secret, userID)
+	return err
+}
+
+// EnableTOTP enables two factor and replaces user recovery codes
+func EnableTOTP(db *sql.DB, userID int, codeHashes []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE users SET totp_enabled_at = NOW() WHERE id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM user_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range codeHashes {
+		_, err = tx.Exec(`INSERT INTO user_recovery_codes(code_hash, user_id)
+			VALUES
+			($1, $2)`, hash, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UseRecoveryCode marks recovery code as used, returns false if it does not exist
+// or was already used
+func UseRecoveryCode(db *sql.DB, userID int, codeHash string) (bool, error) {
+	res, err := db.Exec(`UPDATE user_recovery_codes
+		SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,171 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Weight units, weights are stored in kilograms
+const (
+	UnitKg = "kg"
+	UnitLb = "lb"
+)
+
+// Units lists every valid weight unit
+var Units = map[string]bool{
+	UnitKg: true,
+	UnitLb: true,
+}
+
+// poundInKg is the exact international pound
+var poundInKg = big.NewRat(45359237, 100000000)
+
+// decimalScale is the number of Decimal units in 1
+const decimalScale = 1000
+
+// Decimal is an exact number with up to 3 decimals, so 102.5 kg or 1.25 lb plates
+// don't go through floats. It reads and writes as a plain JSON number
+type Decimal int64
+
+// String formats d without trailing zeros
+func (d Decimal) String() string {
+	sign := ""
+	if d < 0 {
+		sign = "-"
+		d = -d
+	}
+	s := fmt.Sprintf("%s%d.%03d", sign, d/decimalScale, d%decimalScale)
+	return strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+}
+
+// Int returns d rounded half away from zero to an integer
+func (d Decimal) Int() int {
+	if d < 0 {
+		return -(-d).Int()
+	}
+	return int((d + decimalScale/2) / decimalScale)
+}
+
+// ParseDecimal parses a decimal number with up to 3 decimals
+func ParseDecimal(s string) (Decimal, error) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, fmt.Errorf("invalid number %s", s)
+	}
+	r.Mul(r, big.NewRat(decimalScale, 1))
+	if !r.IsInt() || !r.Num().IsInt64() {
+		return 0, fmt.Errorf("%s can have at most 3 decimals", s)
+	}
+	return Decimal(r.Num().Int64()), nil
+}
+
+// ParseRoundedDecimal parses a decimal number rounded to 3 decimals
+func ParseRoundedDecimal(s string) (Decimal, error) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, fmt.Errorf("invalid number %s", s)
+	}
+	return roundDecimal(r), nil
+}
+
+// MarshalJSON writes d as a JSON number
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalJSON reads d from a JSON number
+func (d *Decimal) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if s == "null" {
+		return nil
+	}
+	if _, err := strconv.ParseFloat(s, 64); err != nil {
+		return fmt.Errorf("invalid number %s", s)
+	}
+	v, err := ParseDecimal(s)
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+// Value writes d as a decimal string, which NUMERIC columns read exactly
+func (d Decimal) Value() (driver.Value, error) {
+	return d.String(), nil
+}
+
+// Scan reads d from a NUMERIC column
+func (d *Decimal) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return d.Scan(string(v))
+	case string:
+		parsed, err := ParseDecimal(v)
+		if err != nil {
+			return err
+		}
+		*d = parsed
+		return nil
+	case int64:
+		*d = Decimal(v * decimalScale)
+		return nil
+	}
+	return fmt.Errorf("can't scan %T into Decimal", src)
+}
+
+// rat returns d as a rational number
+func (d Decimal) rat() *big.Rat {
+	return big.NewRat(int64(d), decimalScale)
+}
+
+// roundDecimal rounds r half away from zero to the nearest Decimal
+func roundDecimal(r *big.Rat) Decimal {
+	scaled := new(big.Rat).Mul(r, big.NewRat(decimalScale, 1))
+	num, den := scaled.Num(), scaled.Denom()
+	q, m := new(big.Int).QuoRem(num, den, new(big.Int))
+	if m.Abs(m).Mul(m, big.NewInt(2)).Cmp(den) >= 0 {
+		if num.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return Decimal(q.Int64())
+}
+
+// unitInKg returns how many kilograms one unit weighs
+func unitInKg(unit string) *big.Rat {
+	if unit == UnitLb {
+		return poundInKg
+	}
+	return big.NewRat(1, 1)
+}
+
+// toKg converts a weight in unit to the exact kilograms stored in the database
+func toKg(w Decimal, unit string) string {
+	kg := new(big.Rat).Mul(w.rat(), unitInKg(unit))
+	// 3 decimals times the 8 of a pound always fit in 11 decimals
+	return kg.FloatString(11)
+}
+
+// fromKg converts stored kilograms to a weight in unit
+func fromKg(kg string, unit string) (Decimal, error) {
+	r, ok := new(big.Rat).SetString(kg)
+	if !ok {
+		return 0, fmt.Errorf("invalid stored weight %s", kg)
+	}
+	return roundDecimal(r.Quo(r, unitInKg(unit))), nil
+}
+
+// ConvertWeight converts a weight between units, rounding to 3 decimals
+func ConvertWeight(w Decimal, from string, to string) Decimal {
+	if from == to {
+		return w
+	}
+	r := new(big.Rat).Mul(w.rat(), unitInKg(from))
+	return roundDecimal(r.Quo(r, unitInKg(to)))
+}
@@ -0,0 +1,307 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Set types a record can have
+const (
+	SetWarmUp  = "warm-up"
+	SetWorking = "working"
+	SetDrop    = "drop"
+	SetFailure = "failure"
+)
+
+// SetTypes lists every valid set type
+var SetTypes = map[string]bool{
+	SetWarmUp:  true,
+	SetWorking: true,
+	SetDrop:    true,
+	SetFailure: true,
+}
+
+// Workout is the DB response struct from workouts table,
+// exercises and their sets are in the order they were performed
+type Workout struct {
+	ID         int               `json:"id"`
+	StartedAt  time.Time         `json:"started_at"`
+	EndedAt    *time.Time        `json:"ended_at"`
+	Notes      string            `json:"notes"`
+	TemplateID *int              `json:"template_id"`
+	Exercises  []WorkoutExercise `json:"exercises"`
+	UserID     int               `json:"user_id"`
+}
+
+// WorkoutExercise is the DB response struct from workout_exercises table
+type WorkoutExercise struct {
+	ID         int      `json:"id"`
+	ExerciseID int      `json:"exercise_id"`
+	Notes      string   `json:"notes"`
+	Sets       []Record `json:"sets"`
+}
+
+// ConvertWeights converts the weight of every set of the workout to unit
+func (w *Workout) ConvertWeights(unit string) {
+	for i := range w.Exercises {
+		for j := range w.Exercises[i].Sets {
+			w.Exercises[i].Sets[j].ConvertWeight(unit)
+		}
+	}
+}
+
+// loadWorkoutExercises fills the exercises and sets of workouts
+func loadWorkoutExercises(db *sql.DB, workouts []Workout) error {
+	if len(workouts) == 0 {
+		return nil
+	}
+
+	ids := []int64{}
+	byID := map[int]*Workout{}
+	for i := range workouts {
+		workouts[i].Exercises = []WorkoutExercise{}
+		ids = append(ids, int64(workouts[i].ID))
+		byID[workouts[i].ID] = &workouts[i]
+	}
+
+	rows, err := db.Query(`SELECT we.id, we.exercise_id, we.notes, we.workout_id
+		FROM workout_exercises we
+		WHERE we.workout_id = ANY($1)
+		ORDER BY we.position, we.id`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// exercise indexes by workout exercise ID, the slices can't be pointed into
+	// until every exercise has been appended
+	type exerciseIndex struct{ workout, exercise int }
+	indexes := map[int]exerciseIndex{}
+	for rows.Next() {
+		var exercise WorkoutExercise
+		var workoutID int
+		err := rows.Scan(&exercise.ID, &exercise.ExerciseID, &exercise.Notes, &workoutID)
+		if err != nil {
+			return err
+		}
+		exercise.Sets = []Record{}
+		workout := byID[workoutID]
+		workout.Exercises = append(workout.Exercises, exercise)
+		indexes[exercise.ID] = exerciseIndex{workoutID, len(workout.Exercises) - 1}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	sets, err := db.Query(`SELECT `+recordColumns+`
+		FROM user_records r
+		JOIN workout_exercises we ON we.id = r.workout_exercise_id
+		WHERE we.workout_id = ANY($1)
+		ORDER BY r.position, r.id`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer sets.Close()
+
+	for sets.Next() {
+		set, err := scanRecord(sets)
+		if err != nil {
+			return err
+		}
+		index := indexes[set.WorkoutExerciseID]
+		exercise := &byID[index.workout].Exercises[index.exercise]
+		exercise.Sets = append(exercise.Sets, set)
+	}
+
+	return sets.Err()
+}
+
+// GetWorkouts gets every workout of user, latest first
+func GetWorkouts(db *sql.DB, userID int) ([]Workout, error) {
+	rows, err := db.Query(`SELECT w.id, w.started_at, w.ended_at, w.notes, w.template_id, w.user_id
+		FROM workouts w
+		WHERE w.user_id = $1
+		ORDER BY w.started_at DESC, w.id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workouts := []Workout{}
+	for rows.Next() {
+		var workout Workout
+		err := rows.Scan(&workout.ID, &workout.StartedAt, &workout.EndedAt, &workout.Notes, &workout.TemplateID, &workout.UserID)
+		if err != nil {
+			return nil, err
+		}
+		workouts = append(workouts, workout)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return workouts, loadWorkoutExercises(db, workouts)
+}
+
+// GetWorkout gets a workout of user by ID
+func GetWorkout(db *sql.DB, userID int, id int) (Workout, error) {
+	var workout Workout
+	err := db.QueryRow(`SELECT w.id, w.started_at, w.ended_at, w.notes, w.template_id, w.user_id
+		FROM workouts w
+		WHERE w.id = $1 AND w.user_id = $2`, id, userID,
+	).Scan(&workout.ID, &workout.StartedAt, &workout.EndedAt, &workout.Notes, &workout.TemplateID, &workout.UserID)
+	if err != nil {
+		return workout, err
+	}
+
+	workouts := []Workout{workout}
+	err = loadWorkoutExercises(db, workouts)
+	return workouts[0], err
+}
+
+// saveWorkoutExercises makes the exercises and sets of a workout match w,
+// exercises and sets with an ID are updated, the others created, and the ones
+// left out deleted
+func saveWorkoutExercises(tx *sql.Tx, w Workout) error {
+	exerciseIDs := []int64{}
+	setIDs := []int64{}
+
+	for i, e := range w.Exercises {
+		id := e.ID
+		if id != 0 {
+			err := tx.QueryRow(`UPDATE workout_exercises
+				SET position = $1, notes = $2, exercise_id = $3
+				WHERE id = $4 AND workout_id = $5
+				RETURNING id`, i+1, e.Notes, e.ExerciseID, id, w.ID).Scan(&id)
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("workout exercise %d is not part of this workout", e.ID)
+			}
+			if err != nil {
+				return err
+			}
+		} else {
+			err := tx.QueryRow(`INSERT INTO workout_exercises(position, notes, workout_id, exercise_id)
+				VALUES
+				($1, $2, $3, $4)
+				RETURNING id`, i+1, e.Notes, w.ID, e.ExerciseID).Scan(&id)
+			if err != nil {
+				return err
+			}
+		}
+		exerciseIDs = append(exerciseIDs, int64(id))
+
+		for j, s := range e.Sets {
+			setID := s.ID
+			if setID != 0 {
+				// sets can move to another exercise of the same workout
+				err := tx.QueryRow(`UPDATE user_records
+					SET weight = $1, weight_unit = $2, reps = $3, rpe = $4, rir = $5, duration = $6, distance = $7, set_type = $8,
+					position = $9, workout_exercise_id = $10
+					WHERE id = $11 AND workout_exercise_id IN (SELECT we.id FROM workout_exercises we WHERE we.workout_id = $12)
+					RETURNING id`, toKg(s.Weight, s.WeightUnit), s.WeightUnit, s.Reps, s.RPE, s.RIR, s.Duration, s.Distance, s.SetType, j+1, id, setID, w.ID).Scan(&setID)
+				if err == sql.ErrNoRows {
+					return fmt.Errorf("set %d is not part of this workout", s.ID)
+				}
+				if err != nil {
+					return err
+				}
+			} else {
+				err := tx.QueryRow(`INSERT INTO user_records(weight, weight_unit, reps, rpe, rir, duration, distance, set_type, position,
+					date_performed, exercise_id, workout_exercise_id, user_id)
+					VALUES
+					($1, $2, $3, $4, $5, $6, $7, $8, $9, $10::date, $11, $12, $13)
+					RETURNING id`, toKg(s.Weight, s.WeightUnit), s.WeightUnit, s.Reps, s.RPE, s.RIR, s.Duration, s.Distance, s.SetType, j+1, w.StartedAt, e.ExerciseID, id, w.UserID).Scan(&setID)
+				if err != nil {
+					return err
+				}
+			}
+			setIDs = append(setIDs, int64(setID))
+		}
+	}
+
+	_, err := tx.Exec(`DELETE FROM user_records r
+		USING workout_exercises we
+		WHERE r.workout_exercise_id = we.id AND we.workout_id = $1 AND NOT (r.id = ANY($2))`,
+		w.ID, pq.Array(setIDs))
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM workout_exercises WHERE workout_id = $1 AND NOT (id = ANY($2))`,
+		w.ID, pq.Array(exerciseIDs))
+	if err != nil {
+		return err
+	}
+
+	// records keep a copy of their workout date and exercise
+	_, err = tx.Exec(`UPDATE user_records r
+		SET date_performed = w.started_at::date, exercise_id = we.exercise_id
+		FROM workout_exercises we
+		JOIN workouts w ON w.id = we.workout_id
+		WHERE r.workout_exercise_id = we.id AND w.id = $1`, w.ID)
+	return err
+}
+
+// CreateWorkout creates a workout along its exercises and sets
+func CreateWorkout(db *sql.DB, w Workout) (Workout, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return w, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`INSERT INTO workouts(started_at, ended_at, notes, template_id, user_id)
+		VALUES
+		($1, $2, $3, $4, $5)
+		RETURNING id`, w.StartedAt, w.EndedAt, w.Notes, w.TemplateID, w.UserID).Scan(&w.ID)
+	if err != nil {
+		return w, err
+	}
+
+	if err := saveWorkoutExercises(tx, w); err != nil {
+		return w, err
+	}
+	if err := tx.Commit(); err != nil {
+		return w, err
+	}
+
+	return GetWorkout(db, w.UserID, w.ID)
+}
+
+// UpdateWorkout replaces a workout of user, returns sql.ErrNoRows if it does not exist
+func UpdateWorkout(db *sql.DB, w Workout) (Workout, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return w, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`UPDATE workouts
+		SET started_at = $1, ended_at = $2, notes = $3
+		WHERE id = $4 AND user_id = $5
+		RETURNING id`, w.StartedAt, w.EndedAt, w.Notes, w.ID, w.UserID).Scan(&w.ID)
+	if err != nil {
+		return w, err
+	}
+
+	if err := saveWorkoutExercises(tx, w); err != nil {
+		return w, err
+	}
+	if err := tx.Commit(); err != nil {
+		return w, err
+	}
+
+	return GetWorkout(db, w.UserID, w.ID)
+}
+
+// DeleteWorkout deletes a workout of user along its sets
+func DeleteWorkout(db *sql.DB, userID int, id int) (int, error) {
+	res, err := db.Exec(`DELETE FROM workouts WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return 0, err
+	}
+	count, err := res.RowsAffected()
+	return int(count), err
+}
@@ -28,14 +28,164 @@ func (s *Server) routeNotFound(w http.ResponseWriter, req *http.Request) {
 	w.Write([]byte("route not found"))
 }
 
+// JWKS route wrapper
+func (s *Server) JWKS(w http.ResponseWriter, r *http.Request) {
+	auth.JWKS(w, r)
+}
+
 // Login route wrapper
 func (s *Server) Login(w http.ResponseWriter, r *http.Request) {
-	auth.Login(s.DB, w, r)
+	auth.Login(s.DB, s.Cache, s.Mailer, w, r)
+}
+
+// UnlockLogin route wrapper
+func (s *Server) UnlockLogin(w http.ResponseWriter, r *http.Request) {
+	auth.UnlockLogin(s.DB, s.Cache, w, r)
 }
 
 // Register route wrapper
 func (s *Server) Register(w http.ResponseWriter, r *http.Request) {
-	auth.Register(s.DB, w, r)
+	auth.Register(s.DB, s.Cache, s.Mailer, w, r)
+}
+
+// LoginTwoFactor route wrapper
+func (s *Server) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	auth.LoginTwoFactor(s.DB, s.Cache, s.Mailer, w, r)
+}
+
+// EnrollTOTP route wrapper
+func (s *Server) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	auth.EnrollTOTP(s.DB, w, r)
+}
+
+// ConfirmTOTP route wrapper
+func (s *Server) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	auth.ConfirmTOTP(s.DB, s.Cache, w, r)
+}
+
+// GetMe route wrapper
+func (s *Server) GetMe(w http.ResponseWriter, r *http.Request) {
+	auth.GetMe(s.DB, w, r)
+}
+
+// UpdateMe route wrapper
+func (s *Server) UpdateMe(w http.ResponseWriter, r *http.Request) {
+	auth.UpdateMe(s.DB, s.Mailer, w, r)
+}
+
+// DeleteMe route wrapper
+func (s *Server) DeleteMe(w http.ResponseWriter, r *http.Request) {
+	auth.DeleteMe(s.DB, s.Cache, w, r)
+}
+
+// ChangePassword route wrapper
+func (s *Server) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	auth.ChangePassword(s.DB, s.Cache, w, r)
+}
+
+// ConfirmEmailChange route wrapper
+func (s *Server) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	auth.ConfirmEmailChange(s.DB, w, r)
+}
+
+// StartExport route wrapper
+func (s *Server) StartExport(w http.ResponseWriter, r *http.Request) {
+	auth.StartExport(s.DB, s.Cache, w, r)
+}
+
+// GetExport route wrapper
+func (s *Server) GetExport(w http.ResponseWriter, r *http.Request) {
+	auth.GetExport(s.DB, w, r)
+}
+
+// DownloadExport route wrapper
+func (s *Server) DownloadExport(w http.ResponseWriter, r *http.Request) {
+	auth.DownloadExport(s.DB, w, r)
+}
+
+// GetOAuthProviders route wrapper
+func (s *Server) GetOAuthProviders(w http.ResponseWriter, r *http.Request) {
+	auth.GetOAuthProviders(w, r)
+}
+
+// OAuthLogin route wrapper
+func (s *Server) OAuthLogin(w http.ResponseWriter, r *http.Request) {
+	auth.OAuthLogin(s.Cache, w, r)
+}
+
+// OAuthCallback route wrapper
+func (s *Server) OAuthCallback(w http.ResponseWriter, r *http.Request) {
+	auth.OAuthCallback(s.DB, s.Cache, w, r)
+}
+
+// Refresh route wrapper
+func (s *Server) Refresh(w http.ResponseWriter, r *http.Request) {
+	auth.Refresh(s.DB, s.Cache, w, r)
+}
+
+// ForgotPassword route wrapper
+func (s *Server) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	auth.ForgotPassword(s.DB, s.Cache, s.Mailer, w, r)
+}
+
+// ResetPassword route wrapper
+func (s *Server) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	auth.ResetPassword(s.DB, s.Cache, w, r)
+}
+
+// VerifyEmail route wrapper
+func (s *Server) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	auth.VerifyEmail(s.DB, w, r)
+}
+
+// ResendVerification route wrapper
+func (s *Server) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	auth.ResendVerification(s.DB, s.Cache, s.Mailer, w, r)
+}
+
+// Logout route wrapper
+func (s *Server) Logout(w http.ResponseWriter, r *http.Request) {
+	auth.Logout(s.Cache, w, r)
+}
+
+// GetSessions route wrapper
+func (s *Server) GetSessions(w http.ResponseWriter, r *http.Request) {
+	auth.GetSessions(s.Cache, w, r)
+}
+
+// DeleteSession route wrapper
+func (s *Server) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	auth.DeleteSession(s.Cache, w, r)
+}
+
+// GetAPIKeys route wrapper
+func (s *Server) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	auth.GetAPIKeys(s.DB, w, r)
+}
+
+// CreateAPIKey route wrapper
+func (s *Server) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	auth.CreateAPIKey(s.DB, w, r)
+}
+
+// RevokeAPIKey route wrapper
+func (s *Server) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	auth.RevokeAPIKey(s.DB, w, r)
+}
+
+// CreateCalendarToken route wrapper
+func (s *Server) CreateCalendarToken(w http.ResponseWriter, r *http.Request) {
+	auth.CreateCalendarToken(s.DB, w, r)
+}
+
+// RevokeCalendarToken route wrapper
+func (s *Server) RevokeCalendarToken(w http.ResponseWriter, r *http.Request) {
+	auth.RevokeCalendarToken(s.DB, w, r)
+}
+
+// CalendarFeed route wrapper
+func (s *Server) CalendarFeed(w http.ResponseWriter, r *http.Request) {
+	auth.CalendarFeed(s.DB, w, r)
 }
 
 //////////////////
@@ -62,6 +212,152 @@ func (s *Server) DeleteUserRecord(w http.ResponseWriter, r *http.Request) {
 	exercise.DeleteUserRecord(s.DB, w, r)
 }
 
+// ExportRecords route wrapper
+func (s *Server) ExportRecords(w http.ResponseWriter, r *http.Request) {
+	exercise.ExportRecords(s.DB, w, r)
+}
+
+// GetOneRepMax route wrapper
+func (s *Server) GetOneRepMax(w http.ResponseWriter, r *http.Request) {
+	exercise.GetOneRepMax(s.DB, w, r)
+}
+
+// GetPersonalRecords route wrapper
+func (s *Server) GetPersonalRecords(w http.ResponseWriter, r *http.Request) {
+	exercise.GetPersonalRecords(s.DB, w, r)
+}
+
+// GetVolume route wrapper
+func (s *Server) GetVolume(w http.ResponseWriter, r *http.Request) {
+	exercise.GetVolume(s.DB, w, r)
+}
+
+// ImportRecords route wrapper
+func (s *Server) ImportRecords(w http.ResponseWriter, r *http.Request) {
+	exercise.ImportRecords(s.DB, w, r)
+}
+
+//////////////////
+//// WORKOUT  ////
+//////////////////
+
+// GetWorkouts route wrapper
+func (s *Server) GetWorkouts(w http.ResponseWriter, r *http.Request) {
+	exercise.GetWorkouts(s.DB, w, r)
+}
+
+// GetWorkout route wrapper
+func (s *Server) GetWorkout(w http.ResponseWriter, r *http.Request) {
+	exercise.GetWorkout(s.DB, w, r)
+}
+
+// AddWorkout route wrapper
+func (s *Server) AddWorkout(w http.ResponseWriter, r *http.Request) {
+	exercise.AddWorkout(s.DB, w, r)
+}
+
+// EditWorkout route wrapper
+func (s *Server) EditWorkout(w http.ResponseWriter, r *http.Request) {
+	exercise.EditWorkout(s.DB, w, r)
+}
+
+// DeleteWorkout route wrapper
+func (s *Server) DeleteWorkout(w http.ResponseWriter, r *http.Request) {
+	exercise.DeleteWorkout(s.DB, w, r)
+}
+
+//////////////////
+//// TEMPLATE ////
+//////////////////
+
+// GetTemplates route wrapper
+func (s *Server) GetTemplates(w http.ResponseWriter, r *http.Request) {
+	exercise.GetTemplates(s.DB, w, r)
+}
+
+// GetTemplate route wrapper
+func (s *Server) GetTemplate(w http.ResponseWriter, r *http.Request) {
+	exercise.GetTemplate(s.DB, w, r)
+}
+
+// AddTemplate route wrapper
+func (s *Server) AddTemplate(w http.ResponseWriter, r *http.Request) {
+	exercise.AddTemplate(s.DB, w, r)
+}
+
+// EditTemplate route wrapper
+func (s *Server) EditTemplate(w http.ResponseWriter, r *http.Request) {
+	exercise.EditTemplate(s.DB, w, r)
+}
+
+// DeleteTemplate route wrapper
+func (s *Server) DeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	exercise.DeleteTemplate(s.DB, w, r)
+}
+
+// StartTemplate route wrapper
+func (s *Server) StartTemplate(w http.ResponseWriter, r *http.Request) {
+	exercise.StartTemplate(s.DB, w, r)
+}
+
+/////////////////
+//// PROGRAM ////
+/////////////////
+
+// GetPrograms route wrapper
+func (s *Server) GetPrograms(w http.ResponseWriter, r *http.Request) {
+	exercise.GetPrograms(s.DB, w, r)
+}
+
+// GetProgram route wrapper
+func (s *Server) GetProgram(w http.ResponseWriter, r *http.Request) {
+	exercise.GetProgram(s.DB, w, r)
+}
+
+// AddProgram route wrapper
+func (s *Server) AddProgram(w http.ResponseWriter, r *http.Request) {
+	exercise.AddProgram(s.DB, w, r)
+}
+
+// DeleteProgram route wrapper
+func (s *Server) DeleteProgram(w http.ResponseWriter, r *http.Request) {
+	exercise.DeleteProgram(s.DB, w, r)
+}
+
+// EnrollProgram route wrapper
+func (s *Server) EnrollProgram(w http.ResponseWriter, r *http.Request) {
+	exercise.EnrollProgram(s.DB, w, r)
+}
+
+// GetEnrollments route wrapper
+func (s *Server) GetEnrollments(w http.ResponseWriter, r *http.Request) {
+	exercise.GetEnrollments(s.DB, w, r)
+}
+
+// GetEnrollment route wrapper
+func (s *Server) GetEnrollment(w http.ResponseWriter, r *http.Request) {
+	exercise.GetEnrollment(s.DB, w, r)
+}
+
+// CancelEnrollment route wrapper
+func (s *Server) CancelEnrollment(w http.ResponseWriter, r *http.Request) {
+	exercise.CancelEnrollment(s.DB, w, r)
+}
+
+// GetEnrollmentSchedule route wrapper
+func (s *Server) GetEnrollmentSchedule(w http.ResponseWriter, r *http.Request) {
+	exercise.GetEnrollmentSchedule(s.DB, w, r)
+}
+
+//////////////////
+//// CALENDAR ////
+//////////////////
+
+// GetCalendar route wrapper
+func (s *Server) GetCalendar(w http.ResponseWriter, r *http.Request) {
+	exercise.GetCalendar(s.DB, w, r)
+}
+
 //////////////////
 //// Exercise ////
 //////////////////
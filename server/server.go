@@ -10,20 +10,25 @@ import (
 	"github.com/go-redis/redis"
 	"github.com/gorilla/mux"
 	"github.com/reynld/shinpo/server/auth"
+	"github.com/reynld/shinpo/server/mail"
+	"github.com/reynld/shinpo/server/models"
 	"github.com/rs/cors"
 )
 
-// Server has db, router and cache instances
+// Server has db, router, cache and mailer instances
 type Server struct {
 	DB     *sql.DB
 	Router *mux.Router
 	Cache  *redis.Client
+	Mailer mail.Mailer
 }
 
-// Initialize creates DB, Router and Cache instances
+// Initialize creates DB, Router, Cache and Mailer instances
 func (s *Server) Initialize() {
-	s.setRouter()
 	s.connectDB()
+	s.connectCache()
+	s.Mailer = mail.FromEnviroment()
+	s.setRouter()
 }
 
 // setRouter creates and connects mux router to server struct
@@ -33,26 +38,100 @@ func (s *Server) setRouter() {
 
 	// Auth + Default Endpoints
 	s.Router.HandleFunc("/", s.getServerIsUp).Methods("GET")
+	s.Router.HandleFunc("/.well-known/jwks.json", s.JWKS).Methods("GET")
 	s.Router.HandleFunc("/login", s.Login).Methods("POST")
+	s.Router.HandleFunc("/login/2fa", s.LoginTwoFactor).Methods("POST")
+	s.Router.HandleFunc("/login/unlock", s.UnlockLogin).Methods("GET")
 	s.Router.HandleFunc("/register", s.Register).Methods("POST")
+	s.Router.HandleFunc("/token/refresh", s.Refresh).Methods("POST")
+	s.Router.HandleFunc("/password/forgot", s.ForgotPassword).Methods("POST")
+	s.Router.HandleFunc("/password/reset", s.ResetPassword).Methods("POST")
+	s.Router.HandleFunc("/verify-email", s.VerifyEmail).Methods("GET")
+	s.Router.HandleFunc("/verify-email/resend", auth.Protected(s.DB, s.Cache, s.ResendVerification)).Methods("POST")
+	s.Router.HandleFunc("/logout", auth.Protected(s.DB, s.Cache, s.Logout)).Methods("POST")
+
+	// Account Endpoints
+	s.Router.HandleFunc("/me", auth.Protected(s.DB, s.Cache, s.GetMe)).Methods("GET")
+	s.Router.HandleFunc("/me", auth.Protected(s.DB, s.Cache, s.UpdateMe)).Methods("PATCH")
+	s.Router.HandleFunc("/me", auth.Protected(s.DB, s.Cache, s.DeleteMe)).Methods("DELETE")
+	s.Router.HandleFunc("/me/password", auth.Protected(s.DB, s.Cache, s.ChangePassword)).Methods("POST")
+	s.Router.HandleFunc("/me/email/confirm", s.ConfirmEmailChange).Methods("GET")
+	s.Router.HandleFunc("/me/export", auth.Protected(s.DB, s.Cache, s.StartExport)).Methods("GET")
+	s.Router.HandleFunc("/me/export/{id}", auth.Protected(s.DB, s.Cache, s.GetExport)).Methods("GET")
+	s.Router.HandleFunc("/me/export/{id}/download", auth.Protected(s.DB, s.Cache, s.DownloadExport)).Methods("GET")
+
+	// Social Login Endpoints
+	s.Router.HandleFunc("/oauth/providers", s.GetOAuthProviders).Methods("GET")
+	s.Router.HandleFunc("/oauth/{provider}/login", s.OAuthLogin).Methods("GET")
+	s.Router.HandleFunc("/oauth/{provider}/callback", s.OAuthCallback).Methods("GET")
+
+	// Two Factor Endpoints
+	s.Router.HandleFunc("/2fa/enroll", auth.Protected(s.DB, s.Cache, s.EnrollTOTP)).Methods("POST")
+	s.Router.HandleFunc("/2fa/confirm", auth.Protected(s.DB, s.Cache, s.ConfirmTOTP)).Methods("POST")
+
+	// API Key Endpoints
+	s.Router.HandleFunc("/apikeys", auth.Protected(s.DB, s.Cache, s.GetAPIKeys)).Methods("GET")
+	s.Router.HandleFunc("/apikeys", auth.Protected(s.DB, s.Cache, s.CreateAPIKey)).Methods("POST")
+	s.Router.HandleFunc("/apikeys/{id}", auth.Protected(s.DB, s.Cache, s.RevokeAPIKey)).Methods("DELETE")
+
+	// Session Endpoints
+	s.Router.HandleFunc("/sessions", auth.Protected(s.DB, s.Cache, s.GetSessions)).Methods("GET")
+	s.Router.HandleFunc("/sessions/{id}", auth.Protected(s.DB, s.Cache, s.DeleteSession)).Methods("DELETE")
 
 	// User Record Endpoints
-	s.Router.HandleFunc("/record/all", auth.Protected(s.GetUserRecords)).Methods("GET")
-	s.Router.HandleFunc("/record/add", auth.Protected(s.AddUserRecord)).Methods("POST")
-	s.Router.HandleFunc("/record/edit", auth.Protected(s.EditUserRecord)).Methods("PUT")
-	s.Router.HandleFunc("/record/delete/{id}", auth.Protected(s.DeleteUserRecord)).Methods("DELETE")
+	s.Router.HandleFunc("/record/all", auth.Protected(s.DB, s.Cache, s.GetUserRecords, models.ScopeRecordsRead)).Methods("GET")
+	s.Router.HandleFunc("/record/add", auth.Protected(s.DB, s.Cache, auth.Verified(s.AddUserRecord), models.ScopeRecordsWrite)).Methods("POST")
+	s.Router.HandleFunc("/record/edit", auth.Protected(s.DB, s.Cache, auth.Verified(s.EditUserRecord), models.ScopeRecordsWrite)).Methods("PUT")
+	s.Router.HandleFunc("/record/delete/{id}", auth.Protected(s.DB, s.Cache, auth.Verified(s.DeleteUserRecord), models.ScopeRecordsWrite)).Methods("DELETE")
+	s.Router.HandleFunc("/record/export", auth.Protected(s.DB, s.Cache, s.ExportRecords, models.ScopeRecordsRead)).Methods("GET")
+	s.Router.HandleFunc("/records/e1rm", auth.Protected(s.DB, s.Cache, s.GetOneRepMax, models.ScopeRecordsRead)).Methods("GET")
+	s.Router.HandleFunc("/prs", auth.Protected(s.DB, s.Cache, s.GetPersonalRecords, models.ScopeRecordsRead)).Methods("GET")
+	s.Router.HandleFunc("/analytics/volume", auth.Protected(s.DB, s.Cache, s.GetVolume, models.ScopeRecordsRead)).Methods("GET")
+	s.Router.HandleFunc("/import", auth.Protected(s.DB, s.Cache, auth.Verified(s.ImportRecords), models.ScopeRecordsWrite)).Methods("POST")
+
+	// Workout Endpoints
+	s.Router.HandleFunc("/workouts", auth.Protected(s.DB, s.Cache, s.GetWorkouts, models.ScopeRecordsRead)).Methods("GET")
+	s.Router.HandleFunc("/workouts", auth.Protected(s.DB, s.Cache, auth.Verified(s.AddWorkout), models.ScopeRecordsWrite)).Methods("POST")
+	s.Router.HandleFunc("/workouts/{id}", auth.Protected(s.DB, s.Cache, s.GetWorkout, models.ScopeRecordsRead)).Methods("GET")
+	s.Router.HandleFunc("/workouts/{id}", auth.Protected(s.DB, s.Cache, auth.Verified(s.EditWorkout), models.ScopeRecordsWrite)).Methods("PUT")
+	s.Router.HandleFunc("/workouts/{id}", auth.Protected(s.DB, s.Cache, auth.Verified(s.DeleteWorkout), models.ScopeRecordsWrite)).Methods("DELETE")
+
+	// Template Endpoints
+	s.Router.HandleFunc("/templates", auth.Protected(s.DB, s.Cache, s.GetTemplates, models.ScopeRecordsRead)).Methods("GET")
+	s.Router.HandleFunc("/templates", auth.Protected(s.DB, s.Cache, auth.Verified(s.AddTemplate), models.ScopeRecordsWrite)).Methods("POST")
+	s.Router.HandleFunc("/templates/{id}", auth.Protected(s.DB, s.Cache, s.GetTemplate, models.ScopeRecordsRead)).Methods("GET")
+	s.Router.HandleFunc("/templates/{id}", auth.Protected(s.DB, s.Cache, auth.Verified(s.EditTemplate), models.ScopeRecordsWrite)).Methods("PUT")
+	s.Router.HandleFunc("/templates/{id}", auth.Protected(s.DB, s.Cache, auth.Verified(s.DeleteTemplate), models.ScopeRecordsWrite)).Methods("DELETE")
+	s.Router.HandleFunc("/templates/{id}/start", auth.Protected(s.DB, s.Cache, auth.Verified(s.StartTemplate), models.ScopeRecordsWrite)).Methods("POST")
+
+	// Program Endpoints
+	s.Router.HandleFunc("/programs", auth.Protected(s.DB, s.Cache, s.GetPrograms, models.ScopeCatalogRead)).Methods("GET")
+	s.Router.HandleFunc("/programs", auth.Protected(s.DB, s.Cache, auth.RequireRole(models.RoleCoach, s.AddProgram))).Methods("POST")
+	s.Router.HandleFunc("/programs/{id}", auth.Protected(s.DB, s.Cache, s.GetProgram, models.ScopeCatalogRead)).Methods("GET")
+	s.Router.HandleFunc("/programs/{id}", auth.Protected(s.DB, s.Cache, auth.RequireRole(models.RoleAdmin, s.DeleteProgram))).Methods("DELETE")
+	s.Router.HandleFunc("/programs/{id}/enroll", auth.Protected(s.DB, s.Cache, auth.Verified(s.EnrollProgram), models.ScopeRecordsWrite)).Methods("POST")
+	s.Router.HandleFunc("/enrollments", auth.Protected(s.DB, s.Cache, s.GetEnrollments, models.ScopeRecordsRead)).Methods("GET")
+	s.Router.HandleFunc("/enrollments/{id}", auth.Protected(s.DB, s.Cache, s.GetEnrollment, models.ScopeRecordsRead)).Methods("GET")
+	s.Router.HandleFunc("/enrollments/{id}", auth.Protected(s.DB, s.Cache, auth.Verified(s.CancelEnrollment), models.ScopeRecordsWrite)).Methods("DELETE")
+	s.Router.HandleFunc("/enrollments/{id}/schedule", auth.Protected(s.DB, s.Cache, s.GetEnrollmentSchedule, models.ScopeRecordsRead)).Methods("GET")
+
+	// Calendar Endpoints
+	s.Router.HandleFunc("/calendar", auth.Protected(s.DB, s.Cache, s.GetCalendar, models.ScopeRecordsRead)).Methods("GET")
+	s.Router.HandleFunc("/calendar/token", auth.Protected(s.DB, s.Cache, s.CreateCalendarToken)).Methods("POST")
+	s.Router.HandleFunc("/calendar/token", auth.Protected(s.DB, s.Cache, s.RevokeCalendarToken)).Methods("DELETE")
+	s.Router.HandleFunc("/calendar.ics", s.CalendarFeed).Methods("GET")
 
 	// Exercise Endpoints
-	s.Router.HandleFunc("/exercise/all", auth.Protected(s.GetAllExercises)).Methods("GET")
-	s.Router.HandleFunc("/exercise/add", auth.Protected(s.AddExercise)).Methods("POST")
-	s.Router.HandleFunc("/exercise/edit", auth.Protected(s.EditExercise)).Methods("PUT")
-	// s.Router.HandleFunc("/exercise/delete/{id}", auth.Protected(s.DeleteExercise)).Methods("DELETE")
+	s.Router.HandleFunc("/exercise/all", auth.Protected(s.DB, s.Cache, s.GetAllExercises, models.ScopeCatalogRead)).Methods("GET")
+	s.Router.HandleFunc("/exercise/add", auth.Protected(s.DB, s.Cache, auth.RequireRole(models.RoleAdmin, s.AddExercise))).Methods("POST")
+	s.Router.HandleFunc("/exercise/edit", auth.Protected(s.DB, s.Cache, auth.RequireRole(models.RoleAdmin, s.EditExercise))).Methods("PUT")
+	s.Router.HandleFunc("/exercise/delete/{id}", auth.Protected(s.DB, s.Cache, auth.RequireRole(models.RoleAdmin, s.DeleteExercise))).Methods("DELETE")
 
 	// Category Endpoints
-	s.Router.HandleFunc("/category/all", auth.Protected(s.GetAllCategories)).Methods("GET")
-	s.Router.HandleFunc("/category/add", auth.Protected(s.AddCategory)).Methods("POST")
-	s.Router.HandleFunc("/category/edit", auth.Protected(s.EditCategory)).Methods("PUT")
-	// s.Router.HandleFunc("/category/delete/{id}", auth.Protected(s.DeleteCategory)).Methods("DELETE")
+	s.Router.HandleFunc("/category/all", auth.Protected(s.DB, s.Cache, s.GetAllCategories, models.ScopeCatalogRead)).Methods("GET")
+	s.Router.HandleFunc("/category/add", auth.Protected(s.DB, s.Cache, auth.RequireRole(models.RoleAdmin, s.AddCategory))).Methods("POST")
+	s.Router.HandleFunc("/category/edit", auth.Protected(s.DB, s.Cache, auth.RequireRole(models.RoleAdmin, s.EditCategory))).Methods("PUT")
+	s.Router.HandleFunc("/category/delete/{id}", auth.Protected(s.DB, s.Cache, auth.RequireRole(models.RoleAdmin, s.DeleteCategory))).Methods("DELETE")
 
 	s.Router.NotFoundHandler = http.HandlerFunc(s.routeNotFound)
 }
@@ -76,16 +155,27 @@ func (s *Server) connectDB() {
 	s.DB = db
 }
 
+// connectCache connects to redis cache
+func (s *Server) connectCache() {
+	s.Cache = models.InitializeCache()
+}
+
 // Run runs the server
 func (s *Server) Run() {
 	port := fmt.Sprintf(":%s", os.Getenv("PORT"))
 
 	c := cors.New(cors.Options{
-		AllowedOrigins: []string{"*"},                                               // All origins
-		AllowedHeaders: []string{"Authorization", "Content-Type"},                   // All headers
-		AllowedMethods: []string{"GET", "HEAD", "POST", "PUT", "DELETE", "OPTIONS"}, // Allowing only get, just an example
+		AllowedOrigins: []string{"*"},                                                        // All origins
+		AllowedHeaders: []string{"Authorization", "Content-Type"},                            // All headers
+		AllowedMethods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}, // Allowing only get, just an example
 	})
 
+	if err := auth.StartKeyRotation(s.DB); err != nil {
+		log.Fatal(err)
+	}
+	auth.StartAccountPurge(s.DB)
+	auth.StartExportCleanup(s.DB)
+
 	fmt.Printf("server live on port%s\n", port)
 	log.Fatal(http.ListenAndServe(port, c.Handler(s.Router)))
 }